@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// severity tells how bad a finding is.
+type severity int
+
+const (
+	severityInfo    severity = iota // Worth knowing, not a problem by itself.
+	severityWarning                 // May cause trouble, or could not be checked.
+	severityError                   // Keeps the VM from booting or from being reached.
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityInfo:
+		return "info"
+	case severityWarning:
+		return "warning"
+	case severityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// finding is a problem, or something worth knowing, an analyzer found.
+type finding struct {
+	Analyzer    string   `json:"analyzer"`
+	Check       string   `json:"check"` // What was checked, e.g. "fstab-device"; stable for automation.
+	Severity    severity `json:"severity"`
+	Subject     string   `json:"subject"` // The file, line or filesystem the finding is about.
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation,omitempty"`
+}
+
+// analysis is what analyzers work on: the disk, and the tree of its
+// filesystems as the booted system would see it.
+type analysis struct {
+	d        *disk
+	root     *volume // Nil if there is no filesystem.
+	fsys     fs.FS   // The tree below root, nil with root.
+	findings []finding
+	analyzer string // The one running.
+}
+
+// add records a finding of the running analyzer.
+func (a *analysis) add(sev severity, check, subject, remediation, format string, args ...interface{}) {
+	a.findings = append(a.findings, finding{
+		Analyzer:    a.analyzer,
+		Check:       check,
+		Severity:    sev,
+		Subject:     subject,
+		Message:     fmt.Sprintf(format, args...),
+		Remediation: remediation,
+	})
+}
+
+// analyzer looks for one kind of problem.
+type analyzer struct {
+	name string
+	help string
+	run  func(a *analysis)
+}
+
+var analyzers []analyzer
+
+// set up in init, like the commands
+func init() {
+	analyzers = []analyzer{
+		{"boot", "fstab, grub configuration and kernels, and filesystem state.", analyzeBoot},
+		{"ssh", "sshd configuration, host keys, and the permissions of users' authorized keys.", analyzeSSH},
+	}
+}
+
+// analyze runs the analyzers named in names, all if it is empty, on d and
+// returns their findings, the worst first.
+func analyze(d *disk, names []string) ([]finding, error) {
+	a := &analysis{d: d}
+	if v, err := d.selectVolume(""); err == nil {
+		a.root, a.fsys = v, v.r.FS()
+	}
+	for _, name := range names {
+		if findAnalyzer(name) == nil {
+			return nil, fmt.Errorf("unknown analyzer %q, expected one of %s", name, strings.Join(analyzerNames(), ", "))
+		}
+	}
+	for _, an := range analyzers {
+		if len(names) > 0 && !contains(names, an.name) {
+			continue
+		}
+		a.analyzer = an.name
+		an.run(a)
+	}
+	sort.SliceStable(a.findings, func(i, j int) bool { return a.findings[i].Severity > a.findings[j].Severity })
+	if a.findings == nil {
+		a.findings = []finding{}
+	}
+	return a.findings, nil
+}
+
+func findAnalyzer(name string) *analyzer {
+	for i := range analyzers {
+		if analyzers[i].name == name {
+			return &analyzers[i]
+		}
+	}
+	return nil
+}
+
+func analyzerNames() []string {
+	var names []string
+	for _, an := range analyzers {
+		names = append(names, an.name)
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// printFindings writes findings for people to read, with how to fix them.
+func printFindings(w io.Writer, findings []finding) {
+	if len(findings) == 0 {
+		fmt.Fprintf(w, "No problems found.\n")
+		return
+	}
+	for _, f := range findings {
+		fmt.Fprintf(w, "%-8s %s: %s\n", strings.ToUpper(f.Severity.String()), f.Subject, f.Message)
+		if f.Remediation != "" {
+			fmt.Fprintf(w, "         fix: %s\n", f.Remediation)
+		}
+	}
+}
+
+// analyzeCmd looks for the usual reasons a VM does not boot or cannot be
+// reached, and says what to do about them.
+func analyzeCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	asJSON := f.Bool("json", false, "Write the findings as JSON, as in the report.")
+	var names stringList
+	f.Var(&names, "a", "Analyzer to run: "+strings.Join(analyzerNames(), ", ")+". Can be repeated or comma separated; all run by default.")
+	return func(s *session, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		d, err := s.disk()
+		if err != nil {
+			return err
+		}
+		var selected []string
+		for _, n := range names {
+			selected = append(selected, strings.Split(n, ",")...)
+		}
+		findings, err := analyze(d, selected)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			b, err := json.MarshalIndent(findings, "", "  ")
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+				return err
+			}
+		} else {
+			printFindings(os.Stdout, findings)
+		}
+		errors := 0
+		for _, f := range findings {
+			if f.Severity == severityError {
+				errors++
+			}
+		}
+		if errors > 0 {
+			return fmt.Errorf("%d errors found", errors)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+const rescueRemediation = "Attach the OS disk to a rescue VM (e.g. with az vm repair create) and "
+
+// analyzeBoot checks what the VM needs to get to a login prompt: that grub
+// finds its default kernel and initramfs and passes it a root it can find,
+// that every filesystem in /etc/fstab is there or may be missing, and that
+// the filesystems are not damaged.
+func analyzeBoot(a *analysis) {
+	if a.root == nil {
+		a.add(severityError, "filesystem", "disk", "Check that this is the OS disk of the VM; filesystems other than ext2/3/4 cannot be read yet.",
+			"no ext2/3/4 filesystem found")
+		return
+	}
+	checkFstab(a)
+	checkGrub(a)
+	for _, v := range a.d.volumes {
+		checkFilesystemState(a, v)
+	}
+}
+
+// otherFilesystems reports whether the disk has partitions that hold no
+// ext2/3/4 filesystem, like XFS, LVM or an EFI system partition. Devices
+// that are not found may be on those.
+func (a *analysis) otherFilesystems() bool {
+	for i, p := range a.d.partitions {
+		if p.Type == 0 {
+			continue
+		}
+		found := false
+		for _, v := range a.d.volumes {
+			found = found || v.num == i && v.lv == nil
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// findVolume returns the filesystem an fstab style device spec refers to.
+func (a *analysis) findVolume(spec string) *volume {
+	for _, v := range a.d.volumes {
+		if v.matches(spec) {
+			return v
+		}
+	}
+	return nil
+}
+
+// data disk and resource disk devices, whose names change between boots
+var dataDiskDevice = regexp.MustCompile(`^/dev/(?:(?:s|h|v|xv)d[b-z]|nvme[1-9]n1p?)[0-9]*$`)
+
+func checkFstab(a *analysis) {
+	b, err := fs.ReadFile(a.fsys, "etc/fstab")
+	if err != nil {
+		a.add(severityError, "fstab", "/etc/fstab", "Restore /etc/fstab, with at least the root filesystem in it.",
+			"cannot read /etc/fstab: %v", err)
+		return
+	}
+	rootFound := false
+	for _, e := range parseFstab(b) {
+		subject := fmt.Sprintf("/etc/fstab line %d", e.Line)
+		if e.File == "/" {
+			rootFound = true
+		}
+		if !e.isBlockDevice() {
+			continue
+		}
+		optional := e.hasOption("nofail") || e.hasOption("noauto")
+		v := a.findVolume(e.Spec)
+		switch {
+		case v == nil && e.VfsType != "auto" && !strings.HasPrefix(e.VfsType, "ext") && a.otherFilesystems():
+			a.add(severityInfo, "fstab-device", subject, "",
+				"%s (%s on %s) may be one of the partitions that are not ext2/3/4, which cannot be checked", e.Spec, e.VfsType, e.File)
+		case v == nil && optional:
+			a.add(severityInfo, "fstab-device", subject, "",
+				"no filesystem %s on this disk; %s is skipped if it is not attached, as the line has nofail or noauto", e.Spec, e.File)
+		case v == nil:
+			a.add(severityError, "fstab-device", subject,
+				fmt.Sprintf(rescueRemediation+"add nofail to the options of line %d, or correct the device or remove the line if the disk is gone.", e.Line),
+				"no filesystem %s on this disk; unless a data disk has it, mounting %s fails and boot drops to emergency mode", e.Spec, e.File)
+		case e.VfsType != "auto" && !strings.HasPrefix(e.VfsType, "ext"):
+			a.add(severityError, "fstab-type", subject,
+				fmt.Sprintf(rescueRemediation+"set the type on line %d to %s.", e.Line, fsType(v.r.SuperBlock())),
+				"%s is mounted as %s, but is %s", e.File, e.VfsType, fsType(v.r.SuperBlock()))
+		}
+		if dataDiskDevice.MatchString(e.Spec) {
+			fix := fmt.Sprintf("Refer to the filesystem by UUID= on line %d, as shown by blkid.", e.Line)
+			if !optional {
+				fix = fmt.Sprintf("Refer to the filesystem by UUID= on line %d, as shown by blkid, and add nofail.", e.Line)
+			}
+			a.add(severityWarning, "fstab-device-name", subject, fix,
+				"%s is mounted by device name %s, which can change between boots as disks are attached in another order", e.File, e.Spec)
+		}
+	}
+	if !rootFound {
+		a.add(severityWarning, "fstab-root", "/etc/fstab", "Add a line for / with its UUID, as shown by blkid.",
+			"no entry for the root filesystem /, it stays mounted read-only if the initramfs mounts it so")
+	}
+}
+
+// grubEntry is a menuentry of grub.cfg, or a Boot Loader Specification
+// entry that grub reads with blscfg.
+type grubEntry struct {
+	Title  string
+	ID     string // --id of the menuentry, or the file name of the BLS entry.
+	Pos    string // Position in the menu, like "0", or "1>2" in a submenu.
+	Linux  string // Kernel image, relative to the filesystem grub reads it from.
+	Args   []string
+	Initrd []string
+}
+
+// grubConfig is what grub.cfg and the grubenv next to it say.
+type grubConfig struct {
+	Path    string
+	Entries []*grubEntry
+	Default string            // Entry to boot, by position, ID or title.
+	Env     map[string]string // From grubenv.
+}
+
+// grub.cfg locations of Red Hat, SUSE and Debian style distributions
+var grubConfigs = []string{"boot/grub2/grub.cfg", "boot/grub/grub.cfg"}
+
+func checkGrub(a *analysis) {
+	var cfg *grubConfig
+	for _, p := range grubConfigs {
+		b, err := fs.ReadFile(a.fsys, p)
+		if err != nil {
+			continue
+		}
+		env, err := fs.ReadFile(a.fsys, path.Join(path.Dir(p), "grubenv"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			a.add(severityWarning, "grubenv", "/"+path.Join(path.Dir(p), "grubenv"), "",
+				"cannot read grubenv, the saved default entry is not known: %v", err)
+		}
+		cfg = parseGrubConfig(a.fsys, "/"+p, b, env)
+		break
+	}
+	if cfg == nil {
+		a.add(severityWarning, "grub", "/boot", "If the VM uses UEFI, check grub.cfg on the EFI system partition by hand.",
+			"no grub.cfg found in /boot/grub2 or /boot/grub, the boot configuration cannot be checked")
+		return
+	}
+	if len(cfg.Entries) == 0 {
+		a.add(severityError, "grub-entries", cfg.Path, rescueRemediation+"regenerate it with grub2-mkconfig -o "+cfg.Path+" (update-grub on Debian and Ubuntu).",
+			"no boot entries in %s", cfg.Path)
+		return
+	}
+	if saved := cfg.Env["saved_entry"]; saved != "" && cfg.find(saved) == nil {
+		a.add(severityWarning, "grub-saved-entry", cfg.Path, "Set the default with grub2-set-default (grub-set-default on Debian and Ubuntu) to an entry that exists.",
+			"grubenv saves entry %q, which is not in the menu, so grub boots the first entry", saved)
+	}
+	e := cfg.find(cfg.Default)
+	if e == nil {
+		a.add(severityWarning, "grub-default", cfg.Path, "Set the default with grub2-set-default (grub-set-default on Debian and Ubuntu) to an entry that exists.",
+			"default entry %q is not in the menu, grub boots the first entry", cfg.Default)
+		e = cfg.Entries[0]
+	}
+	subject := fmt.Sprintf("%s entry %q", cfg.Path, e.Title)
+	a.add(severityInfo, "grub-default", subject, "", "boots %s %s", e.Linux, strings.Join(e.Args, " "))
+
+	if e.Linux == "" {
+		a.add(severityError, "grub-kernel", subject, rescueRemediation+"regenerate grub.cfg with grub2-mkconfig (update-grub on Debian and Ubuntu).",
+			"the default entry loads no kernel")
+	} else if _, err := fs.Stat(a.fsys, bootPath(a.fsys, e.Linux)); err != nil {
+		a.add(severityError, "grub-kernel", subject, rescueRemediation+"reinstall the kernel package, or make an installed kernel the default and regenerate grub.cfg.",
+			"kernel %s is missing: %v", e.Linux, err)
+	} else if version := strings.TrimPrefix(path.Base(e.Linux), "vmlinuz-"); version != path.Base(e.Linux) {
+		if _, err := fs.Stat(a.fsys, "lib/modules/"+version); err != nil {
+			a.add(severityWarning, "kernel-modules", subject, rescueRemediation+"reinstall the kernel package of version "+version+".",
+				"there are no modules for kernel %s in /lib/modules, so drivers for disks and network may be missing", version)
+		}
+	}
+	if len(e.Initrd) == 0 {
+		a.add(severityWarning, "grub-initrd", subject, "",
+			"the default entry loads no initramfs, the kernel needs built-in drivers for the disk and root filesystem")
+	}
+	for _, initrd := range e.Initrd {
+		if _, err := fs.Stat(a.fsys, bootPath(a.fsys, initrd)); err != nil {
+			a.add(severityError, "grub-initrd", subject, rescueRemediation+"rebuild it in a chroot with dracut -f (update-initramfs -u on Debian and Ubuntu) for the kernel of the entry.",
+				"initramfs %s is missing: %v", initrd, err)
+		}
+	}
+	checkRootArg(a, subject, e)
+}
+
+// checkRootArg checks that the root= of the kernel command line of e is a
+// filesystem on the disk.
+func checkRootArg(a *analysis, subject string, e *grubEntry) {
+	root := ""
+	for _, arg := range e.Args {
+		if strings.HasPrefix(arg, "root=") {
+			root = strings.TrimPrefix(arg, "root=")
+		}
+	}
+	switch {
+	case root == "":
+		a.add(severityWarning, "grub-root", subject, "Add root=UUID=<uuid of the root filesystem> to GRUB_CMDLINE_LINUX and regenerate grub.cfg.",
+			"the kernel command line has no root=, the initramfs has to know the root filesystem by itself")
+	case strings.HasPrefix(root, "/dev/mapper/") && a.findVolume(root) == nil || strings.HasPrefix(root, "ZFS=") || strings.HasPrefix(root, "/dev/md"):
+		a.add(severityInfo, "grub-root", subject, "", "root=%s is on LVM, RAID or ZFS, which cannot be checked", root)
+	default:
+		v := a.findVolume(root)
+		switch {
+		case v == nil && a.otherFilesystems():
+			a.add(severityWarning, "grub-root", subject, "Check that root= refers to the root filesystem, as shown by blkid.",
+				"no ext2/3/4 filesystem %s on this disk, it may be on one of the partitions that cannot be read", root)
+		case v == nil:
+			a.add(severityError, "grub-root", subject,
+				rescueRemediation+"set root= to UUID="+a.root.r.SuperBlock().UUID.String()+" in GRUB_CMDLINE_LINUX and regenerate grub.cfg.",
+				"no filesystem %s on this disk, the kernel cannot mount its root and the boot ends in an initramfs shell", root)
+		case v != a.root:
+			a.add(severityWarning, "grub-root", subject, "Check that root= refers to the filesystem with /etc/fstab on it.",
+				"root=%s is %v, not the filesystem with /etc/fstab (%v)", root, v, a.root)
+		}
+	}
+}
+
+// bootPath returns where a kernel or initramfs path of grub.cfg is in the
+// tree: relative to / if /boot is not a filesystem of its own, else to /boot.
+func bootPath(fsys fs.FS, p string) string {
+	// drop a device like (hd0,msdos1) or ($root)
+	if strings.HasPrefix(p, "(") {
+		if i := strings.Index(p, ")"); i > 0 {
+			p = p[i+1:]
+		}
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if _, err := fs.Stat(fsys, p); err == nil || strings.HasPrefix(p, "boot/") {
+		return p
+	}
+	return "boot/" + p
+}
+
+// find returns the entry spec refers to, by position, ID or title, with
+// submenus separated by ">".
+func (c *grubConfig) find(spec string) *grubEntry {
+	if spec == "" {
+		spec = "0"
+	}
+	for _, e := range c.Entries {
+		if e.Pos == spec || e.ID == spec || e.Title == spec {
+			return e
+		}
+	}
+	// a title or ID within a submenu
+	if i := strings.LastIndex(spec, ">"); i >= 0 {
+		last := spec[i+1:]
+		for _, e := range c.Entries {
+			if strings.Contains(e.Pos, ">") && (e.ID == last || e.Title == last) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// parseGrubConfig reads the menu entries of grub.cfg b and the default
+// entry, from grubenv env if grub.cfg says so, like grub2 on Red Hat and SUSE
+// does. Entries of /boot/loader/entries are added where blscfg is called.
+func parseGrubConfig(fsys fs.FS, p string, b, env []byte) *grubConfig {
+	c := &grubConfig{Path: p, Env: parseGrubenv(env)}
+	vars := map[string]string{}
+	for k, v := range c.Env {
+		vars[k] = v
+	}
+	expand := func(s string) string {
+		return grubVariable.ReplaceAllStringFunc(s, func(v string) string {
+			return vars[strings.Trim(v, "${}")]
+		})
+	}
+
+	type block struct {
+		kind  string // "menuentry", "submenu" or "other", for braces of functions and the like.
+		entry *grubEntry
+		pos   string // Of a submenu.
+		next  int    // Position of the next entry in a submenu.
+	}
+	stack := []*block{{kind: "submenu"}}
+	menu := func() *block {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].kind == "submenu" {
+				return stack[i]
+			}
+		}
+		return stack[0]
+	}
+	var defaults []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		words := grubWords(s.Text())
+		if len(words) == 0 {
+			continue
+		}
+		top := stack[len(stack)-1]
+		switch cmd := words[0]; {
+		case cmd == "}":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case cmd == "menuentry" || cmd == "submenu":
+			m := menu()
+			pos := strconv.Itoa(m.next)
+			if m.pos != "" {
+				pos = m.pos + ">" + pos
+			}
+			m.next++
+			bl := &block{kind: cmd, pos: pos}
+			if cmd == "menuentry" && len(words) > 1 {
+				bl.entry = &grubEntry{Title: words[1], Pos: pos}
+				for i, w := range words {
+					if (w == "--id" || w == "$menuentry_id_option") && i+1 < len(words) {
+						bl.entry.ID = words[i+1]
+					}
+				}
+				c.Entries = append(c.Entries, bl.entry)
+			}
+			if words[len(words)-1] == "{" {
+				stack = append(stack, bl)
+			}
+		case cmd == "linux" || cmd == "linux16" || cmd == "linuxefi":
+			if top.entry != nil && len(words) > 1 {
+				top.entry.Linux = expand(words[1])
+				top.entry.Args = strings.Fields(expand(strings.Join(words[2:], " ")))
+			}
+		case cmd == "initrd" || cmd == "initrd16" || cmd == "initrdefi":
+			if top.entry != nil {
+				top.entry.Initrd = append(top.entry.Initrd, strings.Fields(expand(strings.Join(words[1:], " ")))...)
+			}
+		case cmd == "set" && len(words) > 1:
+			if i := strings.Index(words[1], "="); i > 0 && top.entry == nil {
+				name, value := words[1][:i], words[1][i+1:]
+				if name == "default" {
+					defaults = append(defaults, value)
+				} else {
+					vars[name] = expand(value)
+				}
+			}
+		case cmd == "blscfg":
+			m := menu()
+			for _, e := range readBLSEntries(fsys) {
+				e.Pos = strconv.Itoa(m.next)
+				m.next++
+				e.Linux = expand(e.Linux)
+				e.Args = strings.Fields(expand(strings.Join(e.Args, " ")))
+				e.Initrd = strings.Fields(expand(strings.Join(e.Initrd, " ")))
+				c.Entries = append(c.Entries, e)
+			}
+		default:
+			if words[len(words)-1] == "{" {
+				stack = append(stack, &block{kind: "other", entry: top.entry})
+			}
+		}
+	}
+
+	// grub.cfg of grub2-mkconfig boots next_entry once, else saved_entry
+	for _, d := range defaults {
+		switch {
+		case strings.Contains(d, "next_entry") && c.Env["next_entry"] != "":
+			c.Default = c.Env["next_entry"]
+			return c
+		case strings.Contains(d, "saved_entry") || d == "saved":
+			c.Default = c.Env["saved_entry"]
+		case !strings.Contains(d, "$"):
+			c.Default = d
+		}
+	}
+	return c
+}
+
+var grubVariable = regexp.MustCompile(`\$\{?[A-Za-z_][A-Za-z0-9_]*\}?`)
+
+// grubWords splits a line of grub.cfg into words as grub does, without
+// expanding variables, and drops comments.
+func grubWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, quote := false, byte(0)
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(ch)
+		case ch == '\'' || ch == '"':
+			quote, inWord = ch, true
+		case ch == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case ch == '#' && !inWord:
+			i = len(line)
+		case ch == ' ' || ch == '\t' || ch == ';':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseGrubenv reads the NAME=VALUE lines of a grubenv block.
+func parseGrubenv(b []byte) map[string]string {
+	env := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.Index(line, "="); i > 0 && !strings.HasPrefix(line, "#") {
+			env[line[:i]] = line[i+1:]
+		}
+	}
+	return env
+}
+
+// readBLSEntries reads the Boot Loader Specification entries of Red Hat 8
+// and later, sorted newest first as grub's blscfg does.
+func readBLSEntries(fsys fs.FS) []*grubEntry {
+	dir := "boot/loader/entries"
+	names, err := fs.Glob(fsys, dir+"/*.conf")
+	if err != nil {
+		return nil
+	}
+	var entries []*grubEntry
+	versions := map[*grubEntry]string{}
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			continue
+		}
+		e := &grubEntry{ID: strings.TrimSuffix(path.Base(name), ".conf")}
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			value := strings.Join(fields[1:], " ")
+			switch fields[0] {
+			case "title":
+				e.Title = value
+			case "version":
+				versions[e] = value
+			case "linux":
+				e.Linux = value
+			case "initrd":
+				e.Initrd = append(e.Initrd, fields[1:]...)
+			case "options":
+				e.Args = append(e.Args, fields[1:]...)
+			}
+		}
+		entries = append(entries, e)
+	}
+	key := func(e *grubEntry) string { return versions[e] + " " + e.ID }
+	sort.SliceStable(entries, func(i, j int) bool { return compareVersions(key(entries[i]), key(entries[j])) > 0 })
+	return entries
+}
+
+var versionPart = regexp.MustCompile(`[0-9]+|[A-Za-z]+`)
+
+// compareVersions compares version strings like rpm does, number by number
+// and word by word.
+func compareVersions(a, b string) int {
+	as, bs := versionPart.FindAllString(a, -1), versionPart.FindAllString(b, -1)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, xerr := strconv.ParseUint(as[i], 10, 64)
+		y, yerr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case xerr == nil && yerr == nil && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		case xerr == nil && yerr != nil:
+			return 1
+		case xerr != nil && yerr == nil:
+			return -1
+		case as[i] != bs[i] && (xerr != nil || yerr != nil):
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// checkFilesystemState reports what the superblock of v says about damage,
+// and checks that will slow down the next boot.
+func checkFilesystemState(a *analysis, v *volume) {
+	sb := v.r.SuperBlock()
+	subject := "filesystem on " + v.device()
+	if v.mountedOn != "" {
+		subject += " (" + v.mountedOn + ")"
+	}
+	fsck := fmt.Sprintf(rescueRemediation+"run e2fsck -f on the partition (like /dev/sdc%d there) while it is not mounted.", v.num+1)
+	if v.lv != nil {
+		fsck = fmt.Sprintf(rescueRemediation+"run e2fsck -f on the logical volume (/dev/%v there, after vgchange -ay) while it is not mounted.", v.lv)
+	}
+	if sb.State&ext4.FSStateFlagError != 0 || sb.ErrorCount > 0 {
+		msg := fmt.Sprintf("the kernel found errors (%d recorded)", sb.ErrorCount)
+		if e := sb.FirstError(); e != "" {
+			msg += ", first: " + e
+		}
+		if e := sb.LastError(); e != "" {
+			msg += ", last: " + e
+		}
+		if sb.Errors == ext4.ErrorsContinue {
+			a.add(severityWarning, "fs-errors", subject, fsck, "%s; with errors=%s it stays writable, but data may be damaged", msg, sb.Errors)
+		} else {
+			a.add(severityError, "fs-errors", subject, fsck, "%s; with errors=%s it may go read-only or panic when mounted", msg, sb.Errors)
+		}
+	}
+	if sb.State&ext4.FSStateFlagOrphans != 0 {
+		a.add(severityInfo, "fs-orphans", subject, "", "orphaned inodes are being recovered, which the next mount finishes")
+	}
+	if sb.State&ext4.FSStateFlagClean == 0 {
+		if sb.FeatureIncompat&ext4.FeatureIncompatFlagRecover != 0 {
+			a.add(severityInfo, "fs-state", subject, "", "the filesystem was not cleanly unmounted, the journal is replayed at the next mount")
+		} else {
+			a.add(severityWarning, "fs-state", subject, fsck, "the filesystem was not cleanly unmounted, fsck checks it at boot")
+		}
+	}
+	if sb.MaxMountCount > 0 && int(sb.MountCount) >= int(sb.MaxMountCount) {
+		a.add(severityInfo, "fs-check-due", subject, "Disable the check with tune2fs -c -1 if it slows down the boot too much.",
+			"mounted %d times, the maximum before a check is %d, fsck checks it at the next boot", sb.MountCount, sb.MaxMountCount)
+	}
+}
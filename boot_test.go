@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGrubWords(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"", nil},
+		{"   # comment", nil},
+		{"set default=0", []string{"set", "default=0"}},
+		{"menuentry 'CentOS Linux (3.10.0) 7 (Core)' --class centos {", []string{"menuentry", "CentOS Linux (3.10.0) 7 (Core)", "--class", "centos", "{"}},
+		{`set default="${saved_entry}"`, []string{"set", "default=${saved_entry}"}},
+		{`if [ "${next_entry}" ] ; then`, []string{"if", "[", "${next_entry}", "]", "then"}},
+		{`linux /vmlinuz root=UUID=abc # console=tty0`, []string{"linux", "/vmlinuz", "root=UUID=abc"}},
+		{`echo a#b 'c # d' e\ f`, []string{"echo", "a#b", "c # d", "e f"}},
+		{`set x=""`, []string{"set", "x="}},
+	}
+	for _, test := range tests {
+		if words := grubWords(test.line); !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("grubWords(%q) = %q, expected %q", test.line, words, test.expected)
+		}
+	}
+}
+
+func TestParseGrubenv(t *testing.T) {
+	env := "# GRUB Environment Block\nsaved_entry=1>0\nkernelopts=root=UUID=abc ro\nnext_entry=\n##########"
+	expected := map[string]string{"saved_entry": "1>0", "kernelopts": "root=UUID=abc ro", "next_entry": ""}
+	if rv := parseGrubenv([]byte(env)); !reflect.DeepEqual(rv, expected) {
+		t.Errorf("parseGrubenv() = %q, expected %q", rv, expected)
+	}
+}
+
+const testGrubCfg = `# generated by grub2-mkconfig
+set rootdev=/dev/sda1
+if [ "${next_entry}" ] ; then
+   set default="${next_entry}"
+   set next_entry=
+   save_env next_entry
+else
+   set default="${saved_entry}"
+fi
+function load_video {
+  insmod all_video
+}
+menuentry 'CentOS Linux (3.10.0-1062.el7.x86_64) 7 (Core)' --class centos $menuentry_id_option 'gnulinux-1062' {
+	load_video
+	set gfxpayload=keep
+	if [ x$feature_platform_search_hint = xy ]; then
+	  search --no-floppy --fs-uuid --set=root abc
+	fi
+	linux16 /vmlinuz-3.10.0-1062.el7.x86_64 root=UUID=abc ro console=ttyS0
+	initrd16 /initramfs-3.10.0-1062.el7.x86_64.img
+}
+submenu 'Advanced options' $menuentry_id_option 'advanced' {
+	menuentry 'Ubuntu, with Linux 5.4' --id 'gnulinux-5.4' {
+		linux /vmlinuz-5.4 root=$rootdev ro
+		initrd /microcode.cpio /initrd.img-5.4
+	}
+	menuentry 'Ubuntu, with Linux 5.4 (recovery mode)' {
+		linux /vmlinuz-5.4 root=${rootdev} single
+	}
+}
+menuentry 'Windows' {
+}
+`
+
+func TestParseGrubConfig(t *testing.T) {
+	expected := []*grubEntry{
+		{Title: "CentOS Linux (3.10.0-1062.el7.x86_64) 7 (Core)", ID: "gnulinux-1062", Pos: "0",
+			Linux: "/vmlinuz-3.10.0-1062.el7.x86_64", Args: []string{"root=UUID=abc", "ro", "console=ttyS0"},
+			Initrd: []string{"/initramfs-3.10.0-1062.el7.x86_64.img"}},
+		{Title: "Ubuntu, with Linux 5.4", ID: "gnulinux-5.4", Pos: "1>0",
+			Linux: "/vmlinuz-5.4", Args: []string{"root=/dev/sda1", "ro"},
+			Initrd: []string{"/microcode.cpio", "/initrd.img-5.4"}},
+		{Title: "Ubuntu, with Linux 5.4 (recovery mode)", Pos: "1>1",
+			Linux: "/vmlinuz-5.4", Args: []string{"root=/dev/sda1", "single"}},
+		{Title: "Windows", Pos: "2"},
+	}
+	tests := []struct {
+		env      string
+		expected string // Default entry.
+	}{
+		{"", ""},
+		{"saved_entry=gnulinux-5.4\n", "gnulinux-5.4"},
+		{"saved_entry=gnulinux-5.4\nnext_entry=2\n", "2"},
+		{"saved_entry=1>0\nnext_entry=\n", "1>0"},
+	}
+	for _, test := range tests {
+		c := parseGrubConfig(fstest.MapFS{}, "boot/grub2/grub.cfg", []byte(testGrubCfg), []byte(test.env))
+		if !reflect.DeepEqual(c.Entries, expected) {
+			t.Errorf("parseGrubConfig() entries = %+v, expected %+v", c.Entries, expected)
+		}
+		if c.Default != test.expected {
+			t.Errorf("parseGrubConfig() with grubenv %q: default = %q, expected %q", test.env, c.Default, test.expected)
+		}
+	}
+
+	c := parseGrubConfig(fstest.MapFS{}, "boot/grub/grub.cfg", []byte(testGrubCfg+"set default=\"1>1\"\n"), nil)
+	if c.Default != "1>1" {
+		t.Errorf("parseGrubConfig() default = %q, expected %q", c.Default, "1>1")
+	}
+}
+
+func TestGrubConfigFind(t *testing.T) {
+	c := parseGrubConfig(fstest.MapFS{}, "boot/grub2/grub.cfg", []byte(testGrubCfg), nil)
+	tests := []struct {
+		spec     string
+		expected string // Pos of the entry found, "" for none.
+	}{
+		{"", "0"},
+		{"0", "0"},
+		{"1>1", "1>1"},
+		{"gnulinux-1062", "0"},
+		{"Windows", "2"},
+		{"advanced>gnulinux-5.4", "1>0"},
+		{"Advanced options>Ubuntu, with Linux 5.4 (recovery mode)", "1>1"},
+		{"5", ""},
+		{"CentOS>gnulinux-1062", ""},
+	}
+	for _, test := range tests {
+		pos := ""
+		if e := c.find(test.spec); e != nil {
+			pos = e.Pos
+		}
+		if pos != test.expected {
+			t.Errorf("find(%q) = %q, expected %q", test.spec, pos, test.expected)
+		}
+	}
+}
+
+func TestParseGrubConfigBLS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"boot/loader/entries/abc-4.18.0-80.el8.x86_64.conf": {Data: []byte(
+			"title Red Hat Enterprise Linux (4.18.0-80.el8.x86_64) 8.0 (Ootpa)\nversion 4.18.0-80.el8.x86_64\n" +
+				"linux /vmlinuz-4.18.0-80.el8.x86_64\ninitrd /initramfs-4.18.0-80.el8.x86_64.img $tuned_initrd\n" +
+				"options $kernelopts $tuned_params\nid rhel-20190313153131-4.18.0-80.el8.x86_64\n")},
+		"boot/loader/entries/abc-4.18.0-147.el8.x86_64.conf": {Data: []byte(
+			"title Red Hat Enterprise Linux (4.18.0-147.el8.x86_64) 8.1 (Ootpa)\nversion 4.18.0-147.el8.x86_64\n" +
+				"linux /vmlinuz-4.18.0-147.el8.x86_64\ninitrd /initramfs-4.18.0-147.el8.x86_64.img\n" +
+				"options root=UUID=def ro\n")},
+		"boot/loader/entries/README": {Data: []byte("not an entry")},
+	}
+	cfg := "set default=\"${saved_entry}\"\nmenuentry 'Other' {\n}\ninsmod blscfg\nblscfg\n"
+	c := parseGrubConfig(fsys, "boot/grub2/grub.cfg", []byte(cfg), []byte("saved_entry=abc-4.18.0-80.el8.x86_64\nkernelopts=root=UUID=abc ro crashkernel=auto\n"))
+	expected := []*grubEntry{
+		{Title: "Other", Pos: "0"},
+		{Title: "Red Hat Enterprise Linux (4.18.0-147.el8.x86_64) 8.1 (Ootpa)", ID: "abc-4.18.0-147.el8.x86_64", Pos: "1",
+			Linux: "/vmlinuz-4.18.0-147.el8.x86_64", Args: []string{"root=UUID=def", "ro"},
+			Initrd: []string{"/initramfs-4.18.0-147.el8.x86_64.img"}},
+		{Title: "Red Hat Enterprise Linux (4.18.0-80.el8.x86_64) 8.0 (Ootpa)", ID: "abc-4.18.0-80.el8.x86_64", Pos: "2",
+			Linux: "/vmlinuz-4.18.0-80.el8.x86_64", Args: []string{"root=UUID=abc", "ro", "crashkernel=auto"},
+			Initrd: []string{"/initramfs-4.18.0-80.el8.x86_64.img"}},
+	}
+	if !reflect.DeepEqual(c.Entries, expected) {
+		t.Errorf("parseGrubConfig() entries = %+v, expected %+v", c.Entries, expected)
+	}
+	if e := c.find(c.Default); e == nil || e.Pos != "2" {
+		t.Errorf("parseGrubConfig() default %q is %+v, expected entry 2", c.Default, e)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"4.18.0-147.el8.x86_64", "4.18.0-80.el8.x86_64", 1},
+		{"4.18.0-80.el8.x86_64", "4.18.0-147.el8.x86_64", -1},
+		{"3.10.0-1062.el7", "3.10.0-1062.el7", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"1.a", "1.1", -1},
+		{"1.b", "1.a", 1},
+		{"5.4.0-1020-azure", "5.4.0-1020-generic", -1},
+	}
+	for _, test := range tests {
+		rv := compareVersions(test.a, test.b)
+		if (rv < 0) != (test.expected < 0) || (rv > 0) != (test.expected > 0) {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", test.a, test.b, rv, test.expected)
+		}
+	}
+}
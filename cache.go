@@ -0,0 +1,149 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+)
+
+// blocks are cached in chunks of this size, which also makes for some
+// read-ahead of the small metadata reads
+const cacheChunkSize = 256 * 1024
+
+// reads larger than this are mostly file content and bypass the cache
+const cacheBypassSize = 4 * cacheChunkSize
+
+// blockCache is a ReadSeeker that keeps the most recently read chunks of
+// the one it wraps in memory, so that directories, inodes and extent trees
+// that are read again do not need another request.
+type blockCache struct {
+	s      io.ReadSeeker
+	offset int64
+	max    int                     // Number of chunks to keep.
+	chunks map[int64]*list.Element // Holding a *cacheChunk, by index.
+	lru    *list.List              // Most recently used first.
+}
+
+type cacheChunk struct {
+	index int64
+	data  []byte // Short for the last chunk of the blob.
+}
+
+// newBlockCache returns s with a cache of size bytes in front of it, or s
+// itself if size is 0.
+func newBlockCache(s io.ReadSeeker, size int64) io.ReadSeeker {
+	if size <= 0 {
+		return s
+	}
+	max := int(size / cacheChunkSize)
+	if max < 1 {
+		max = 1
+	}
+	return &blockCache{
+		s:      s,
+		max:    max,
+		chunks: map[int64]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+func (c *blockCache) Read(p []byte) (int, error) {
+	if len(p) >= cacheBypassSize {
+		if _, err := c.s.Seek(c.offset, 0); err != nil {
+			return 0, err
+		}
+		n, err := c.s.Read(p)
+		c.offset += int64(n)
+		return n, err
+	}
+
+	n := 0
+	for n < len(p) {
+		index := c.offset / cacheChunkSize
+		chunk, err := c.chunk(index, (c.offset+int64(len(p)-n)-1)/cacheChunkSize)
+		if err != nil {
+			return n, err
+		}
+		off := int(c.offset - index*cacheChunkSize)
+		if off >= len(chunk.data) {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+		m := copy(p[n:], chunk.data[off:])
+		n += m
+		c.offset += int64(m)
+	}
+	return n, nil
+}
+
+// chunk returns chunk index from the cache, reading it if needed together
+// with the missing ones following it up to last, in a single request.
+func (c *blockCache) chunk(index, last int64) (*cacheChunk, error) {
+	if e, ok := c.chunks[index]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheChunk), nil
+	}
+
+	end := index + 1
+	for end <= last {
+		if _, ok := c.chunks[end]; ok {
+			break
+		}
+		end++
+	}
+	if _, err := c.s.Seek(index*cacheChunkSize, 0); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, (end-index)*cacheChunkSize)
+	n, err := io.ReadFull(c.s, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	var first *cacheChunk
+	for i := index; i < end; i++ {
+		from := (i - index) * cacheChunkSize
+		if from > int64(len(buf)) {
+			break
+		}
+		to := from + cacheChunkSize
+		if to > int64(len(buf)) {
+			to = int64(len(buf))
+		}
+		chunk := &cacheChunk{index: i, data: buf[from:to:to]}
+		c.chunks[i] = c.lru.PushFront(chunk)
+		if first == nil {
+			first = chunk
+		}
+	}
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		delete(c.chunks, e.Value.(*cacheChunk).index)
+		c.lru.Remove(e)
+	}
+	return first, nil
+}
+
+func (c *blockCache) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += c.offset
+	case 2:
+		size, err := c.s.Seek(0, 2)
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, fmt.Errorf("Illegal value for parameter whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Cannot seek with negative offset: %d", offset)
+	}
+	c.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// errUsage is returned by commands given the wrong arguments.
+var errUsage = fmt.Errorf("wrong arguments")
+
+// session is what commands work on: the disk, which is only opened once,
+// and the current directory, which only the shell changes.
+type session struct {
+	uri string
+	src io.ReadSeeker
+	d   *disk
+	cwd *ext4.Directory // Nil until first used.
+}
+
+// disk returns the disk, opening it on first use.
+func (s *session) disk() (*disk, error) {
+	if s.d == nil {
+		d, err := openDisk(s.src, false)
+		if err != nil {
+			return nil, err
+		}
+		s.d = d
+	}
+	return s.d, nil
+}
+
+// dir returns the directory relative paths start from: the root of the
+// filesystem sel refers to if it is set, otherwise the current directory,
+// which is initially the root of the default filesystem.
+func (s *session) dir(sel string) (ext4.Directory, error) {
+	if sel == "" && s.cwd != nil {
+		return *s.cwd, nil
+	}
+	d, err := s.disk()
+	if err != nil {
+		return ext4.Directory{}, err
+	}
+	root, err := d.selectRoot(sel)
+	if err != nil {
+		return ext4.Directory{}, err
+	}
+	if sel == "" {
+		s.cwd = &root
+	}
+	return root, nil
+}
+
+// command is a subcommand of the tool.
+type command struct {
+	name    string
+	args    string // Arguments after the flags, and the URI if run on its own.
+	help    string
+	setup   func(f *flag.FlagSet) func(s *session, args []string) error // Declares the flags of the command and returns what runs it.
+	noShell bool                                                        // Only available on the command line.
+	local   bool                                                        // Works on local files, without a disk URI.
+}
+
+var commands []command
+
+// set up in init, the shell refers to commands itself
+func init() {
+	commands = []command{
+		{"collect", "", "Download the usual log and configuration files (the default).", collectCmd, true, false},
+		{"shell", "", "Explore the disk interactively.", shellCmd, true, false},
+		{"partitions", "", "List the partitions and the filesystems on them.", partitionsCmd, false, false},
+		{"info", "", "Show superblock, features and usage of the filesystems.", infoCmd, false, false},
+		{"ls", "[PATH...]", "List directories.", lsCmd, false, false},
+		{"cat", "PATH...", "Write files to stdout.", catCmd, false, false},
+		{"stat", "PATH...", "Show inode details and extents.", statCmd, false, false},
+		{"find", "[PATH...]", "Search for files by name, size, modification time and type.", findCmd, false, false},
+		{"tree", "[PATH]", "Show a directory tree.", treeCmd, false, false},
+		{"get", "[PATTERN[;OPTION=VALUE]...]", "Download files matching patterns, with the options of -file, or profiles.", getCmd, false, false},
+		{"analyze", "", "Look for the usual reasons a VM does not boot, and say how to fix them.", analyzeCmd, false, false},
+		{"verify", "PATH", "Check an output directory or archive, - for a tar on stdin, against its manifest.", verifyCmd, false, true},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: ./inspect-remote-vhd [flags] <command> [command flags] <vhd-read-uri> [args]\n")
+	fmt.Fprintf(os.Stderr, "       ./inspect-remote-vhd [flags] <vhd-read-uri>\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s%s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nProfiles:\n")
+	for _, p := range builtinProfiles {
+		fmt.Fprintf(os.Stderr, "  %-14s%s\n", p.Name, p.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun a command with -help for its flags. Flags:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand runs c on the disk whose URI follows the command's flags.
+func runCommand(c *command, args []string) error {
+	f := flag.NewFlagSet(c.name, flag.ExitOnError)
+	f.Usage = func() {
+		if c.local {
+			fmt.Fprintf(os.Stderr, "Usage: ./inspect-remote-vhd [flags] %s [command flags] %s\n", c.name, c.args)
+		} else {
+			fmt.Fprintf(os.Stderr, "Usage: ./inspect-remote-vhd [flags] %s [command flags] <vhd-read-uri> %s\n", c.name, c.args)
+		}
+		f.PrintDefaults()
+	}
+	run := c.setup(f)
+	f.Parse(args)
+	if c.local {
+		err := run(&session{}, f.Args())
+		if err == errUsage {
+			f.Usage()
+			os.Exit(2)
+		}
+		return err
+	}
+	if f.NArg() < 1 {
+		f.Usage()
+		os.Exit(2)
+	}
+
+	s := &session{uri: f.Arg(0), src: newBlockCache(SasPageBlobAccessor(f.Arg(0)), cacheSize)}
+	err := run(s, f.Args()[1:])
+	if err == errUsage {
+		f.Usage()
+		os.Exit(2)
+	}
+	return err
+}
+
+// partitionFlag adds the -p flag that selects the filesystem to work on.
+func partitionFlag(f *flag.FlagSet) *string {
+	return f.String("p", "", "Filesystem to use, by partition index, label, UUID or LVM logical volume (VG/LV or LV). By default the root filesystem, with the others mounted as its /etc/fstab says.")
+}
+
+func partitionsCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	return func(s *session, args []string) error {
+		d, err := s.disk()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PART\tBOOT\tTYPE\tSTART\tSECTORS\tSIZE\tFILESYSTEM\n")
+		for i, p := range d.partitions {
+			if p.Type == 0 {
+				continue
+			}
+			boot := ""
+			if p.Active == 0x80 {
+				boot = "*"
+			}
+			fs := ""
+			for _, pv := range d.pvs {
+				if pv.part == i {
+					fs = "LVM physical volume"
+				}
+			}
+			for _, v := range d.volumes {
+				if v.num != i || v.lv != nil {
+					continue
+				}
+				sb := v.r.SuperBlock()
+				fs = fmt.Sprintf("ext4 UUID=%v LABEL=%q PARTUUID=%s", sb.UUID, sb.Label(), v.partUUID)
+				if v.mountedOn != "" {
+					fs += " on " + v.mountedOn
+				}
+			}
+			fmt.Fprintf(w, "%d\t%s\t0x%02x\t%d\t%d\t%s\t%s\n", i, boot, p.Type, p.LBAfirst, p.Sectors, formatSize(int64(p.Sectors)*512), fs)
+		}
+		// logical volumes have no type or start of their own
+		for _, lv := range d.lvs {
+			fs := ""
+			for _, v := range d.volumes {
+				if v.lv != lv {
+					continue
+				}
+				sb := v.r.SuperBlock()
+				fs = fmt.Sprintf("ext4 UUID=%v LABEL=%q", sb.UUID, sb.Label())
+				if v.mountedOn != "" {
+					fs += " on " + v.mountedOn
+				}
+			}
+			fmt.Fprintf(w, "%v\t\tlvm\t\t%d\t%s\t%s\n", lv, lv.size/512, formatSize(lv.size), fs)
+		}
+		return w.Flush()
+	}
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v, u := float64(n), 0
+	for v >= 1024 && u < len(units)-1 {
+		v /= 1024
+		u++
+	}
+	if u == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[u])
+}
+
+func infoCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	return func(s *session, args []string) error {
+		d, err := s.disk()
+		if err != nil {
+			return err
+		}
+
+		vols := d.volumes
+		if *sel != "" {
+			v, err := d.selectVolume(*sel)
+			if err != nil {
+				return err
+			}
+			vols = []*volume{v}
+		}
+		for i, v := range vols {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%v", v)
+			if v.mountedOn != "" {
+				fmt.Printf(" on %s", v.mountedOn)
+			}
+			fmt.Println()
+			describeFilesystem(os.Stdout, v.r)
+		}
+		if *sel == "" {
+			root, err := d.selectRoot("")
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+			printDistro(os.Stdout, detectDistro(root))
+		}
+		return nil
+	}
+}
+
+// openFile returns a reader for the content of the regular file p.
+func openFile(dir ext4.Directory, p string) (ext4.InodeReader, error) {
+	e, inode, err := dir.Lookup(p, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	if inode.Mode.FileType() != ext4.FileTypeFile {
+		return nil, fmt.Errorf("%s: not a regular file", p)
+	}
+	ir, err := e.Reader().GetInodeReader(inode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	return ir, nil
+}
+
+func catCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	return func(s *session, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		dir, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		for _, p := range args {
+			ir, err := openFile(dir, p)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(os.Stdout, ir); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+		}
+		return nil
+	}
+}
+
+func getCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	out := f.String("o", ouputPath, "Directory to download to, unless -archive is set.")
+	var names stringList
+	f.Var(&names, "profile", "Profile of files to download as well, by name or file, or auto for the one of the distribution. Can be repeated or comma separated.")
+	return func(s *session, args []string) error {
+		if len(args) == 0 && len(names) == 0 {
+			return errUsage
+		}
+		var rules []collectRule
+		for _, p := range args {
+			rule, err := parseRule(p)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+		dir, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			pr, err := profileRules(names, detectDistro(dir))
+			if err != nil {
+				return err
+			}
+			rules = append(rules, pr...)
+		}
+		o, err := openOutput(*out, s.uri)
+		if err != nil {
+			return err
+		}
+		c, err := newCollection(s, o)
+		if err != nil {
+			o.Close()
+			return err
+		}
+		err = collectFiles(c, dir, "", rules)
+		if cerr := c.close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// diag receives progress messages and warnings. Commands that write file
+// content to stdout send them to stderr instead.
+var diag io.Writer = os.Stdout
+
+// warnings are those given with warnf, for the report.
+var warnings []string
+
+// warnf prints a warning to diag and keeps it for the report, without the
+// signatures of URLs in it.
+func warnf(format string, args ...interface{}) {
+	msg := redactURLs(fmt.Sprintf(format, args...))
+	warnings = append(warnings, msg)
+	fmt.Fprintf(diag, "WARN: %s\n", msg)
+}
+
+// disk is a VHD with the ext4 filesystems found on it.
+type disk struct {
+	partitions []partitionEntry
+	pvs        []*physicalVolume // LVM physical volumes, on partitions.
+	lvs        []*logicalVolume  // LVM logical volumes, whatever is on them.
+	volumes    []*volume
+	ns         *namespace // Nil if no filesystem has an /etc/fstab.
+}
+
+// openDisk reads the partition table of the VHD and opens the ext4
+// filesystem on each Linux partition and LVM logical volume, then mounts
+// them as the root's /etc/fstab says. If verbose is set, it describes each
+// filesystem as it goes.
+func openDisk(s io.ReadSeeker, verbose bool) (*disk, error) {
+	if verbose {
+		fmt.Fprintf(diag, "Reading partition table...\n")
+	}
+	// location of MBR partition table http://en.wikipedia.org/wiki/Master_boot_record#Sector_layout
+	partitions, err := readPartitionTable(s)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := readDiskSignature(s)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &disk{partitions: partitions}
+	for partitionNum, p := range partitions {
+		if verbose {
+			fmt.Fprintf(diag, "Inspecting filesystem on partition %d...\n", partitionNum)
+		}
+		if p.Type == 0x83 || p.Type == 0x8e {
+			pv, err := readPhysicalVolume(s, partitionNum, p)
+			if err != nil {
+				warnf("partition %d: %v", partitionNum, err)
+			}
+			if pv != nil {
+				if verbose {
+					fmt.Fprintf(diag, "LVM physical volume, reading its logical volumes later\n")
+				}
+				d.pvs = append(d.pvs, pv)
+				continue
+			}
+		}
+		if p.Type != 0x83 {
+			if verbose {
+				fmt.Fprintf(diag, "Not a linux partition (%d), skipping!\n", p.Type)
+			}
+			continue
+		}
+
+		r, err := openFilesystem(s, p)
+		if err == ext4.ErrNotExt4 {
+			if verbose {
+				fmt.Fprintf(diag, "Filesystem is not ext4 compatible, skipping!\n")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %v", partitionNum, err)
+		}
+		if verbose {
+			describeFilesystem(diag, &r)
+		}
+
+		d.volumes = append(d.volumes, &volume{
+			num:      partitionNum,
+			partUUID: mbrPartUUID(signature, partitionNum),
+			r:        &r,
+		})
+	}
+
+	d.lvs = findLogicalVolumes(d.pvs)
+	for _, lv := range d.lvs {
+		if verbose {
+			fmt.Fprintf(diag, "Inspecting filesystem on logical volume %v...\n", lv)
+		}
+		sectors := lv.size / 512
+		if sectors > math.MaxUint32 {
+			sectors = math.MaxUint32
+		}
+		r, err := openFilesystem(&lvReader{s: s, lv: lv}, partitionEntry{Sectors: uint32(sectors)})
+		if err == ext4.ErrNotExt4 {
+			if verbose {
+				fmt.Fprintf(diag, "Filesystem is not ext4 compatible, skipping!\n")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("logical volume %v: %v", lv, err)
+		}
+		if verbose {
+			describeFilesystem(diag, &r)
+		}
+
+		d.volumes = append(d.volumes, &volume{num: lv.part, lv: lv, r: &r})
+	}
+	d.ns = buildNamespace(d.volumes)
+	return d, nil
+}
+
+// describeFilesystem prints the superblock and usage of the filesystem, and
+// warns about superblock backups that differ from it.
+func describeFilesystem(w io.Writer, r *ext4.Reader) {
+	fmt.Fprint(w, r.SuperBlock())
+	if usage, err := r.Usage(); err != nil {
+		warnf("could not compute usage: %v", err)
+	} else {
+		fmt.Fprintf(w, "Usage:           %v\n", usage)
+	}
+
+	divergences, err := r.CompareBackups()
+	if err != nil {
+		warnf("could not compare superblock backups: %v", err)
+	}
+	for _, d := range divergences {
+		warnf("superblock backup differs in %v", d)
+	}
+}
+
+// selectVolume returns the filesystem sel refers to, by partition index,
+// label, UUID, LVM logical volume (as VG/LV, or LV if that is unique) or an
+// fstab style spec like "PARTUUID=..." or "/dev/mapper/VG-LV". An empty sel
+// selects the root filesystem, with the others mounted on it.
+func (d *disk) selectVolume(sel string) (*volume, error) {
+	if len(d.volumes) == 0 {
+		return nil, fmt.Errorf("no ext4 filesystem found on the disk")
+	}
+	if sel == "" {
+		if d.ns != nil {
+			return d.ns.root, nil
+		}
+		return d.volumes[0], nil
+	}
+	if n, err := strconv.Atoi(sel); err == nil {
+		for _, v := range d.volumes {
+			if v.num == n && v.lv == nil {
+				return v, nil
+			}
+		}
+		for _, pv := range d.pvs {
+			if pv.part == n {
+				return nil, fmt.Errorf("partition %d is an LVM physical volume, select one of its logical volumes by name", n)
+			}
+		}
+		return nil, fmt.Errorf("no ext4 filesystem on partition %d", n)
+	}
+	var byLVName []*volume
+	for _, v := range d.volumes {
+		if v.matches(sel) || v.matches("UUID="+sel) || v.matches("LABEL="+sel) || v.lv != nil && v.lv.String() == sel {
+			return v, nil
+		}
+		if v.lv != nil && v.lv.name == sel {
+			byLVName = append(byLVName, v)
+		}
+	}
+	if len(byLVName) == 1 {
+		return byLVName[0], nil
+	}
+	if len(byLVName) > 1 {
+		return nil, fmt.Errorf("more than one volume group has a logical volume %s, select it as VG/%s", sel, sel)
+	}
+	for _, lv := range d.lvs {
+		if lv.String() == sel || lv.name == sel {
+			return nil, fmt.Errorf("logical volume %v holds no ext4 filesystem", lv)
+		}
+	}
+	return nil, fmt.Errorf("no filesystem %q found, select one by partition index, label, UUID or logical volume name", sel)
+}
+
+// selectRoot returns the root directory of the filesystem sel refers to.
+func (d *disk) selectRoot(sel string) (ext4.Directory, error) {
+	v, err := d.selectVolume(sel)
+	if err != nil {
+		return ext4.Directory{}, err
+	}
+	return v.r.Root()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// distro is the Linux distribution installed on a root filesystem.
+type distro struct {
+	ID      string   `json:"id"`      // e.g. "centos" or "ubuntu", lower case.
+	Name    string   `json:"name"`    // e.g. "CentOS Linux 7 (Core)".
+	Version string   `json:"version"` // e.g. "7" or "18.04".
+	Family  string   `json:"family"`  // "redhat", "debian" or "suse", or empty if not known.
+	Source  string   `json:"source"`  // The file the distribution was read from.
+	Kernels []string `json:"kernels"` // Versions of the kernels in /boot.
+	Modules []string `json:"modules"` // Versions of the kernel modules in /lib/modules.
+}
+
+func (d distro) String() string {
+	s := d.Name
+	if d.Version != "" && !strings.Contains(s, d.Version) {
+		s += " " + d.Version
+	}
+	if d.Family != "" {
+		s += ", " + d.Family + " family"
+	}
+	return s
+}
+
+// profile returns the built-in profile for the distribution's family, or the
+// default one.
+func (d *distro) profile() string {
+	if d == nil || d.Family == "" {
+		return defaultProfile
+	}
+	return d.Family
+}
+
+// distroFamilies maps the IDs of os-release to the family their files are
+// laid out like.
+var distroFamilies = map[string]string{
+	"rhel":      "redhat",
+	"centos":    "redhat",
+	"fedora":    "redhat",
+	"ol":        "redhat",
+	"almalinux": "redhat",
+	"rocky":     "redhat",
+	"amzn":      "redhat",
+	"debian":    "debian",
+	"ubuntu":    "debian",
+	"sles":      "suse",
+	"sles_sap":  "suse",
+	"suse":      "suse",
+	"opensuse":  "suse",
+}
+
+var releaseVersion = regexp.MustCompile(`release ([0-9][0-9.]*)`)
+
+// detectDistro works out the distribution from the release files below
+// root, trying /etc/os-release first. It returns nil if there are none.
+func detectDistro(root ext4.Directory) *distro {
+	var d *distro
+	if b, p := readReleaseFile(root, "/etc/os-release", "/usr/lib/os-release"); b != nil {
+		d = parseOSRelease(b)
+		d.Source = p
+	} else if b, p := readReleaseFile(root, "/etc/redhat-release", "/etc/centos-release", "/etc/oracle-release"); b != nil {
+		line := firstLine(b)
+		d = &distro{ID: "rhel", Name: line, Family: "redhat", Source: p}
+		if m := releaseVersion.FindStringSubmatch(line); m != nil {
+			d.Version = m[1]
+		}
+	} else if b, p := readReleaseFile(root, "/etc/SuSE-release"); b != nil {
+		d = &distro{ID: "suse", Name: firstLine(b), Family: "suse", Source: p}
+		kv := parseKeyValues(b)
+		d.Version = kv["VERSION"]
+		if pl := kv["PATCHLEVEL"]; pl != "" && pl != "0" {
+			d.Version += "." + pl
+		}
+	} else if b, p := readReleaseFile(root, "/etc/debian_version"); b != nil {
+		d = &distro{ID: "debian", Name: "Debian", Version: firstLine(b), Family: "debian", Source: p}
+	} else {
+		return nil
+	}
+
+	for _, name := range dirNames(root, "/boot") {
+		if strings.HasPrefix(name, "vmlinuz-") {
+			d.Kernels = append(d.Kernels, strings.TrimPrefix(name, "vmlinuz-"))
+		}
+	}
+	d.Modules = dirNames(root, "/lib/modules")
+	return d
+}
+
+// parseOSRelease parses the KEY="value" lines of os-release(5).
+func parseOSRelease(b []byte) *distro {
+	kv := parseKeyValues(b)
+	d := &distro{
+		ID:      strings.ToLower(kv["ID"]),
+		Name:    kv["PRETTY_NAME"],
+		Version: kv["VERSION_ID"],
+	}
+	if d.Name == "" {
+		d.Name = kv["NAME"]
+	}
+	// opensuse-leap and the like are matched by their prefix
+	for _, id := range append([]string{d.ID}, strings.Fields(kv["ID_LIKE"])...) {
+		if f, ok := distroFamilies[strings.SplitN(id, "-", 2)[0]]; ok {
+			d.Family = f
+			break
+		}
+	}
+	return d
+}
+
+// parseKeyValues parses lines of KEY=VALUE or KEY = VALUE, with the value
+// optionally in quotes.
+func parseKeyValues(b []byte) map[string]string {
+	kv := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), "=", 2)
+		if len(parts) != 2 || strings.HasPrefix(strings.TrimSpace(parts[0]), "#") {
+			continue
+		}
+		v := strings.TrimSpace(parts[1])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		kv[strings.TrimSpace(parts[0])] = v
+	}
+	return kv
+}
+
+func firstLine(b []byte) string {
+	return strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+}
+
+// readReleaseFile returns the content of the first of paths that is a
+// readable file below root, and which one it was.
+func readReleaseFile(root ext4.Directory, paths ...string) ([]byte, string) {
+	for _, p := range paths {
+		ir, err := openFile(root, p)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(ir, 64*1024))
+		if err != nil {
+			continue
+		}
+		return b, p
+	}
+	return nil, ""
+}
+
+// dirNames returns the sorted names in directory p below root, if there is
+// one.
+func dirNames(root ext4.Directory, p string) []string {
+	dir, err := root.ChangeDir(p)
+	if err != nil {
+		return nil
+	}
+	entries, err := dir.Entries()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if n := e.Name.String(); n != "." && n != ".." {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printDistro describes d, or that there is none, to w.
+func printDistro(w io.Writer, d *distro) {
+	if d == nil {
+		fmt.Fprintf(w, "Distribution:    unknown, no release file found\n")
+		return
+	}
+	fmt.Fprintf(w, "Distribution:    %v (from %s)\n", d, d.Source)
+	fmt.Fprintf(w, "Boot kernels:    %s\n", listOrNone(d.Kernels))
+	fmt.Fprintf(w, "Kernel modules:  %s\n", listOrNone(d.Modules))
+}
+
+func listOrNone(s []string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, ", ")
+}
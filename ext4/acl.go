@@ -0,0 +1,167 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	XattrPosixACLAccess  = "system.posix_acl_access"
+	XattrPosixACLDefault = "system.posix_acl_default"
+	XattrSELinux         = "security.selinux"
+)
+
+type ACLTag uint16
+
+const (
+	ACLUserObj  ACLTag = 0x01 // Owner of the file (ACL_USER_OBJ).
+	ACLUser     ACLTag = 0x02 // Named user (ACL_USER).
+	ACLGroupObj ACLTag = 0x04 // Owning group of the file (ACL_GROUP_OBJ).
+	ACLGroup    ACLTag = 0x08 // Named group (ACL_GROUP).
+	ACLMask     ACLTag = 0x10 // Maximum permissions for named entries and the owning group (ACL_MASK).
+	ACLOther    ACLTag = 0x20 // Everyone else (ACL_OTHER).
+)
+
+type ACLEntry struct {
+	Tag  ACLTag
+	Perm uint16 // rwx bits, 0x4 read, 0x2 write, 0x1 execute.
+	ID   uint32 // Uid or gid for ACLUser and ACLGroup entries.
+}
+
+func (e ACLEntry) String() string {
+	perm := []byte("---")
+	if e.Perm&4 > 0 {
+		perm[0] = 'r'
+	}
+	if e.Perm&2 > 0 {
+		perm[1] = 'w'
+	}
+	if e.Perm&1 > 0 {
+		perm[2] = 'x'
+	}
+	switch e.Tag {
+	case ACLUserObj:
+		return "user::" + string(perm)
+	case ACLUser:
+		return fmt.Sprintf("user:%d:%s", e.ID, perm)
+	case ACLGroupObj:
+		return "group::" + string(perm)
+	case ACLGroup:
+		return fmt.Sprintf("group:%d:%s", e.ID, perm)
+	case ACLMask:
+		return "mask::" + string(perm)
+	case ACLOther:
+		return "other::" + string(perm)
+	default:
+		return fmt.Sprintf("ACLTag(0x%x):%d:%s", uint16(e.Tag), e.ID, perm)
+	}
+}
+
+// ACL is a POSIX access control list.
+type ACL struct {
+	Default bool // Default ACL of a directory, inherited by new entries.
+	Entries []ACLEntry
+}
+
+// String formats the ACL like getfacl -n does, one entry per line.
+func (a ACL) String() string {
+	lines := make([]string, len(a.Entries))
+	for i, e := range a.Entries {
+		lines[i] = e.String()
+		if a.Default {
+			lines[i] = "default:" + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseACL decodes a system.posix_acl_access or system.posix_acl_default
+// attribute value. ext4 stores ACLs in its own compact format (version 1)
+// where the entries without an id are only 4 bytes long; the generic xattr
+// format (version 2) used by the VFS is accepted as well.
+func ParseACL(b []byte, dflt bool) (ACL, error) {
+	acl := ACL{Default: dflt}
+	if len(b) < 4 {
+		return acl, fmt.Errorf("ACL too short (%d bytes)", len(b))
+	}
+	version := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return acl, fmt.Errorf("truncated ACL entry")
+		}
+		e := ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(b)),
+			Perm: binary.LittleEndian.Uint16(b[2:]),
+		}
+		size := 8
+		if version == 1 && e.Tag != ACLUser && e.Tag != ACLGroup {
+			size = 4
+		} else if version != 1 && version != 2 {
+			return acl, fmt.Errorf("unsupported ACL version %d", version)
+		}
+		if len(b) < size {
+			return acl, fmt.Errorf("truncated ACL entry")
+		}
+		if size == 8 {
+			e.ID = binary.LittleEndian.Uint32(b[4:])
+		}
+		acl.Entries = append(acl.Entries, e)
+		b = b[size:]
+	}
+	return acl, nil
+}
+
+// BaseACL returns the minimal ACL equivalent to the permission bits of the
+// inode's mode, which is what getfacl shows for files without an ACL.
+func (inode Inode) BaseACL() ACL {
+	return ACL{Entries: []ACLEntry{
+		{Tag: ACLUserObj, Perm: uint16(inode.Mode>>6) & 7},
+		{Tag: ACLGroupObj, Perm: uint16(inode.Mode>>3) & 7},
+		{Tag: ACLOther, Perm: uint16(inode.Mode) & 7},
+	}}
+}
+
+// GetACLs returns the access ACL of inode n (falling back to the one
+// implied by its mode) and its default ACL, if any.
+func (er *Reader) GetACLs(n uint32) (access ACL, dflt *ACL, err error) {
+	inode, err := er.GetInode(n)
+	if err != nil {
+		return
+	}
+	attrs, err := er.Xattrs(n)
+	if err != nil {
+		return
+	}
+	access = inode.BaseACL()
+	for _, a := range attrs {
+		switch a.Name {
+		case XattrPosixACLAccess:
+			if access, err = ParseACL(a.Value, false); err != nil {
+				return
+			}
+		case XattrPosixACLDefault:
+			d, perr := ParseACL(a.Value, true)
+			if perr != nil {
+				err = perr
+				return
+			}
+			dflt = &d
+		}
+	}
+	return
+}
+
+// SELinuxContext returns the SELinux label of inode n, or "" if the inode
+// is not labeled.
+func (er *Reader) SELinuxContext(n uint32) (string, error) {
+	v, err := er.GetXattr(n, XattrSELinux)
+	if err == ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cstring(v), nil
+}
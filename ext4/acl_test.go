@@ -0,0 +1,95 @@
+package ext4
+
+import (
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        []byte
+		dflt     bool
+		expected string
+		err      bool
+	}{
+		{
+			// as ext4 stores it
+			name: "version 1",
+			b: []byte{1, 0, 0, 0,
+				0x01, 0, 6, 0,
+				0x02, 0, 6, 0, 0xe8, 3, 0, 0,
+				0x04, 0, 4, 0,
+				0x10, 0, 6, 0,
+				0x20, 0, 4, 0},
+			expected: "user::rw-\nuser:1000:rw-\ngroup::r--\nmask::rw-\nother::r--",
+		},
+		{
+			// as the VFS passes it
+			name: "version 2",
+			b: []byte{2, 0, 0, 0,
+				0x01, 0, 7, 0, 0xff, 0xff, 0xff, 0xff,
+				0x08, 0, 5, 0, 0x32, 0, 0, 0,
+				0x20, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+			dflt:     true,
+			expected: "default:user::rwx\ndefault:group:50:r-x\ndefault:other::---",
+		},
+		{name: "short", b: []byte{1, 0}, err: true},
+		{name: "truncated", b: []byte{1, 0, 0, 0, 0x02, 0, 6, 0, 0xe8, 3}, err: true},
+		{name: "unknown version", b: []byte{3, 0, 0, 0, 0x01, 0, 6, 0}, err: true},
+	}
+	for _, test := range tests {
+		acl, err := ParseACL(test.b, test.dflt)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: ParseACL() = %q, expected an error", test.name, acl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseACL(): %v", test.name, err)
+			continue
+		}
+		if acl.String() != test.expected {
+			t.Errorf("%s: ParseACL() = %q, expected %q", test.name, acl, test.expected)
+		}
+	}
+}
+
+func TestGetACLs(t *testing.T) {
+	tests := []struct {
+		path   string
+		access string
+		dflt   string
+	}{
+		{"etc/fstab", "user::rw-\nuser:1000:rw-\ngroup::r--\ngroup:1000:r--\nmask::rw-\nother::r--", ""},
+		{"var/log", "user::rwx\ngroup::r-x\nother::r-x",
+			"default:user::rwx\ndefault:user:1000:rwx\ndefault:group::r-x\ndefault:mask::rwx\ndefault:other::r-x"},
+		{"etc/os-release", "user::rw-\ngroup::r--\nother::r--", ""},
+	}
+	r := openImage(t, readImage(t, "ext4"))
+	root, err := r.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		e, _, err := root.Lookup(test.path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		access, dflt, err := r.GetACLs(e.Inode)
+		if err != nil {
+			t.Errorf("GetACLs(%s): %v", test.path, err)
+			continue
+		}
+		if access.String() != test.access {
+			t.Errorf("GetACLs(%s) access ACL = %q, expected %q", test.path, access, test.access)
+		}
+		d := ""
+		if dflt != nil {
+			d = dflt.String()
+		}
+		if d != test.dflt {
+			t.Errorf("GetACLs(%s) default ACL = %q, expected %q", test.path, d, test.dflt)
+		}
+	}
+}
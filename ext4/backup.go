@@ -0,0 +1,246 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// SuperBlockLocation describes where a copy of the superblock lives, in the
+// same terms as e2fsck -b <block> -B <blocksize>.
+type SuperBlockLocation struct {
+	Group     uint32 // Block group holding this copy.
+	Block     int64  // Block number of the copy, in units of BlockSize.
+	BlockSize int64  // Filesystem block size in bytes.
+}
+
+func (l SuperBlockLocation) offset() int64 {
+	if l.Block == 0 {
+		// the primary superblock always lives at byte 1024
+		return 1024
+	}
+	return l.Block * l.BlockSize
+}
+
+// SuperBlockDivergence is a field that differs between the superblock in use
+// and one of its copies.
+type SuperBlockDivergence struct {
+	Group     uint32 // Block group of the diverging copy.
+	Field     string // SuperBlock field name, or "SuperBlock" if the copy could not be read.
+	Reference string // Value in the superblock in use.
+	Copy      string // Value in the copy.
+}
+
+func (d SuperBlockDivergence) String() string {
+	return fmt.Sprintf("group %d: %s is %s, expected %s", d.Group, d.Field, d.Copy, d.Reference)
+}
+
+// fields that e2fsck expects to be identical between the primary superblock
+// and its backups; everything else (free counts, mount times, error info,
+// ...) is only maintained in the primary.
+var stableSuperBlockFields = []string{
+	"Magic", "RevisionLevel", "MinorRevLevel", "CreatorOS",
+	"InodesCount", "BlocksCountLo", "BlocksCountHi", "FirstDataBlock",
+	"LogBlockSize", "LogClusterSize", "BlocksPerGroup", "ClustersPerGroup", "InodesPerGroup",
+	"FirstIno", "InodeSize", "FeatureCompat", "FeatureIncompat", "FeatureROCompat",
+	"UUID", "VolumeName", "ReservedGdtBlocks", "DescSize", "FirstMetaBg",
+	"JournalUUID", "JournalInum", "HashSeed", "DefHashVersion",
+	"LogGroupsPerFlex", "ChecksumType", "ChecksumSeed", "BackupBgs", "Encoding",
+}
+
+// feature bits the kernel sets in the primary superblock only, while the
+// filesystem is mounted or as it first uses a feature; e2fsck ignores them
+// when comparing backups
+const (
+	runtimeIncompatFeatures = FeatureIncompatFlagRecover | FeatureIncompatFlagExtents
+	runtimeROCompatFeatures = FeatureROCompatFlagLargeFile | FeatureROCompatFlagDirNlink | FeatureROCompatFlagOrphanPresent
+)
+
+func (s SuperBlock) withoutRuntimeFeatures() SuperBlock {
+	s.FeatureIncompat &^= runtimeIncompatFeatures
+	s.FeatureROCompat &^= runtimeROCompatFeatures
+	return s
+}
+
+func (s SuperBlock) groupFirstBlock(group uint32) int64 {
+	return int64(group)*int64(s.BlocksPerGroup) + int64(s.FirstDataBlock)
+}
+
+// GroupCount returns the number of block groups in the filesystem.
+func (s SuperBlock) GroupCount() uint32 {
+	if s.BlocksPerGroup == 0 {
+		return 0
+	}
+	blocks := s.BlocksCount() - uint64(s.FirstDataBlock)
+	return uint32((blocks + uint64(s.BlocksPerGroup) - 1) / uint64(s.BlocksPerGroup))
+}
+
+// HasSuperBlock reports whether the given block group holds a copy of the
+// superblock and group descriptor table.
+func (s SuperBlock) HasSuperBlock(group uint32) bool {
+	if group == 0 {
+		return true
+	}
+	if group >= s.GroupCount() {
+		return false
+	}
+	if s.FeatureCompat&FeatureCompatFlagSparseSuper2 > 0 {
+		return group == s.BackupBgs[0] || group == s.BackupBgs[1]
+	}
+	if s.FeatureROCompat&FeatureROCompatFlagSparseSuper == 0 || group == 1 {
+		return true
+	}
+	return isPowerOf(group, 3) || isPowerOf(group, 5) || isPowerOf(group, 7)
+}
+
+func isPowerOf(n, base uint32) bool {
+	for n > 1 && n%base == 0 {
+		n /= base
+	}
+	return n == 1
+}
+
+// BackupLocations lists the locations of all superblock backups, i.e.
+// groups 0, 1 and powers of 3, 5 and 7 with sparse_super, only the groups in
+// s_backup_bgs with sparse_super2, and every group otherwise.
+func (s SuperBlock) BackupLocations() []SuperBlockLocation {
+	var rv []SuperBlockLocation
+	for g := uint32(1); g < s.GroupCount(); g++ {
+		if s.HasSuperBlock(g) {
+			rv = append(rv, SuperBlockLocation{
+				Group:     g,
+				Block:     s.groupFirstBlock(g),
+				BlockSize: s.blockSize(),
+			})
+		}
+	}
+	return rv
+}
+
+// NewReaderFromBackup opens the filesystem using the superblock and group
+// descriptors stored at the given location instead of the primary ones. If
+// loc.BlockSize is 0, all valid block sizes are tried.
+func NewReaderFromBackup(s io.ReadSeeker, startBlock, blockCount uint32, loc SuperBlockLocation) (r Reader, err error) {
+	if loc.BlockSize == 0 {
+		err = ErrNotExt4
+		for bs := int64(1024); bs <= 65536 && err != nil; bs *= 2 {
+			loc.BlockSize = bs
+			r, err = NewReaderFromBackup(s, startBlock, blockCount, loc)
+		}
+		return
+	}
+
+	r = Reader{
+		s:     s,
+		start: int64(startBlock) * 512,
+		size:  int64(blockCount) * 512,
+	}
+	r.super, err = readSuperBlock(s, r.start+loc.offset())
+	if err != nil {
+		return
+	}
+	if r.super.blockSize() != loc.BlockSize {
+		err = fmt.Errorf("Superblock at block %d has block size %d, expected %d", loc.Block, r.super.blockSize(), loc.BlockSize)
+		return
+	}
+	if r.super.groupFirstBlock(uint32(r.super.BlockGroupNr)) != loc.Block && loc.Block != 0 {
+		err = fmt.Errorf("Superblock at block %d claims to be in group %d", loc.Block, r.super.BlockGroupNr)
+		return
+	}
+	r.sbGroup = uint32(r.super.BlockGroupNr)
+	err = r.init()
+	return
+}
+
+// mke2fs never makes groups larger than this, so that group free counts fit
+// in 16 bits (EXT2_MAX_BLOCKS_PER_GROUP)
+const maxBlocksPerGroup = 1<<16 - 8
+
+// ProbeBackupSuperBlocks looks for superblock backups at the places mke2fs
+// puts them for each possible block size, much like e2fsck does when the
+// primary superblock is unusable. Only the first few candidate groups are
+// tried, so this works without knowing anything about the filesystem.
+func ProbeBackupSuperBlocks(s io.ReadSeeker, startBlock, blockCount uint32) []SuperBlockLocation {
+	var rv []SuperBlockLocation
+	start := int64(startBlock) * 512
+	size := int64(blockCount) * 512
+	for bs := int64(1024); bs <= 65536; bs *= 2 {
+		blocksPerGroup := bs * 8
+		if blocksPerGroup > maxBlocksPerGroup {
+			blocksPerGroup = maxBlocksPerGroup
+		}
+		firstDataBlock := int64(0)
+		if bs == 1024 {
+			firstDataBlock = 1
+		}
+		for _, g := range []uint32{1, 3, 5, 7, 9} {
+			loc := SuperBlockLocation{
+				Group:     g,
+				Block:     int64(g)*blocksPerGroup + firstDataBlock,
+				BlockSize: bs,
+			}
+			if loc.offset()+superBlockSize > size {
+				break
+			}
+			sb, err := readSuperBlock(s, start+loc.offset())
+			if err != nil ||
+				sb.blockSize() != bs ||
+				uint32(sb.BlockGroupNr) != g ||
+				int64(sb.BlocksPerGroup) != blocksPerGroup {
+				continue
+			}
+			rv = append(rv, loc)
+		}
+	}
+	return rv
+}
+
+// CompareBackups reads all copies of the superblock other than the one in
+// use and reports the fields that differ from it.
+func (r Reader) CompareBackups() ([]SuperBlockDivergence, error) {
+	locs := append([]SuperBlockLocation{{BlockSize: r.super.blockSize()}}, r.super.BackupLocations()...)
+	ref := reflect.ValueOf(r.super.withoutRuntimeFeatures())
+
+	var rv []SuperBlockDivergence
+	for _, loc := range locs {
+		if loc.Group == r.sbGroup {
+			continue
+		}
+		sb, err := readSuperBlock(r.s, r.start+loc.offset())
+		if err != nil && err != ErrSuperBlockChecksum {
+			if err == ErrNotExt4 {
+				rv = append(rv, SuperBlockDivergence{
+					Group:     loc.Group,
+					Field:     "Magic",
+					Reference: fmt.Sprintf("%v", r.super.Magic),
+					Copy:      fmt.Sprintf("%v", sb.Magic),
+				})
+				continue
+			}
+			return rv, err
+		}
+		if err == ErrSuperBlockChecksum {
+			rv = append(rv, SuperBlockDivergence{
+				Group:     loc.Group,
+				Field:     "Checksum",
+				Reference: "valid",
+				Copy:      fmt.Sprintf("invalid (0x%08x)", sb.Checksum),
+			})
+		}
+
+		cp := reflect.ValueOf(sb.withoutRuntimeFeatures())
+		for _, name := range stableSuperBlockFields {
+			a := ref.FieldByName(name).Interface()
+			b := cp.FieldByName(name).Interface()
+			if !reflect.DeepEqual(a, b) {
+				rv = append(rv, SuperBlockDivergence{
+					Group:     loc.Group,
+					Field:     name,
+					Reference: fmt.Sprintf("%v", a),
+					Copy:      fmt.Sprintf("%v", b),
+				})
+			}
+		}
+	}
+	return rv, nil
+}
@@ -0,0 +1,103 @@
+package ext4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/fs"
+	"testing"
+)
+
+// patchSuperBlock changes the primary superblock of the image in b and
+// fixes its checksum.
+func patchSuperBlock(b []byte, patch func(raw []byte)) {
+	raw := b[1024 : 1024+superBlockSize]
+	patch(raw)
+	binary.LittleEndian.PutUint32(raw[superBlockSize-4:], ^crc32.Checksum(raw[:superBlockSize-4], crc32c))
+}
+
+func TestCompareBackups(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  func(raw []byte)
+		fields []string
+	}{
+		{"unchanged", func(raw []byte) {}, nil},
+		{"mounted", func(raw []byte) {
+			// needs_recovery, orphan_present, and the times and counts of a
+			// mounted filesystem
+			binary.LittleEndian.PutUint32(raw[0x60:], binary.LittleEndian.Uint32(raw[0x60:])|uint32(FeatureIncompatFlagRecover))
+			binary.LittleEndian.PutUint32(raw[0x64:], binary.LittleEndian.Uint32(raw[0x64:])|uint32(FeatureROCompatFlagOrphanPresent))
+			binary.LittleEndian.PutUint32(raw[0x2c:], 1500000000)
+			binary.LittleEndian.PutUint16(raw[0x34:], 7)
+		}, nil},
+		{"relabeled", func(raw []byte) {
+			copy(raw[0x78:0x88], "other\x00")
+		}, []string{"VolumeName", "VolumeName"}},
+		{"resized", func(raw []byte) {
+			binary.LittleEndian.PutUint32(raw[0x04:], binary.LittleEndian.Uint32(raw[0x04:])+512)
+		}, []string{"BlocksCountLo", "BlocksCountLo"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := readImage(t, "ext4")
+			patchSuperBlock(b, test.patch)
+			r := openImage(t, b)
+			divergences, err := r.CompareBackups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields []string
+			for _, d := range divergences {
+				fields = append(fields, d.Field)
+			}
+			if len(fields) != len(test.fields) {
+				t.Fatalf("CompareBackups() = %v, expected differences in %v", divergences, test.fields)
+			}
+			for i := range fields {
+				if fields[i] != test.fields[i] {
+					t.Errorf("CompareBackups() = %v, expected differences in %v", divergences, test.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupLocations(t *testing.T) {
+	r := openImage(t, readImage(t, "ext4"))
+	expected := []SuperBlockLocation{{1, 512, 4096}, {3, 1536, 4096}}
+	locs := r.SuperBlock().BackupLocations()
+	if len(locs) != len(expected) {
+		t.Fatalf("BackupLocations() = %v, expected %v", locs, expected)
+	}
+	for i := range locs {
+		if locs[i] != expected[i] {
+			t.Errorf("BackupLocations() = %v, expected %v", locs, expected)
+		}
+	}
+}
+
+func TestProbeBackupSuperBlocks(t *testing.T) {
+	b := readImage(t, "ext2")
+	copy(b[1024:1024+superBlockSize], make([]byte, superBlockSize))
+	if _, err := NewReader(bytes.NewReader(b), 0, uint32(len(b)/512)); err != ErrNotExt4 {
+		t.Fatalf("NewReader() without a superblock: %v, expected %v", err, ErrNotExt4)
+	}
+
+	locs := ProbeBackupSuperBlocks(bytes.NewReader(b), 0, uint32(len(b)/512))
+	expected := SuperBlockLocation{Group: 1, Block: 8193, BlockSize: 1024}
+	if len(locs) != 1 || locs[0] != expected {
+		t.Fatalf("ProbeBackupSuperBlocks() = %v, expected [%v]", locs, expected)
+	}
+	r, err := NewReaderFromBackup(bytes.NewReader(b), 0, uint32(len(b)/512), SuperBlockLocation{Block: 8193})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(r.FS(), "var/log/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, messages()) {
+		t.Errorf("var/log/messages read through the backup differs from what was written")
+	}
+}
@@ -0,0 +1,195 @@
+package ext4
+
+import (
+	"fmt"
+)
+
+// Bitmap is the block or inode allocation bitmap of a block group. With
+// bigalloc, block bitmaps have one bit per cluster rather than per block.
+type Bitmap []byte
+
+func (b Bitmap) IsSet(n uint32) bool {
+	if int(n/8) >= len(b) {
+		return false
+	}
+	return b[n/8]&(1<<(n%8)) > 0
+}
+
+func (b Bitmap) set(n uint32) {
+	if int(n/8) < len(b) {
+		b[n/8] |= 1 << (n % 8)
+	}
+}
+
+// Count returns the number of bits set among the first n bits.
+func (b Bitmap) Count(n uint32) uint32 {
+	var rv uint32
+	for i := uint32(0); i < n; i++ {
+		if b.IsSet(i) {
+			rv++
+		}
+	}
+	return rv
+}
+
+// GetBlockBitmap returns the cluster allocation bitmap of a block group.
+// Groups flagged BLOCK_UNINIT have no bitmap on disk, for those it is made
+// up from the metadata in the group, as the kernel does.
+func (er *Reader) GetBlockBitmap(group uint32) (Bitmap, error) {
+	gd, err := er.GetGroupDescriptor(group)
+	if err != nil {
+		return nil, err
+	}
+	if gd.Flags&GroupFlagBlockUninit > 0 {
+		return er.initBlockBitmap(group, gd), nil
+	}
+	return er.readBitmap(gd.BlockBitmapBlock())
+}
+
+// initBlockBitmap returns the block bitmap of a BLOCK_UNINIT group like
+// ext4_init_block_bitmap: the superblock backup and group descriptors at
+// the start of the group are in use, as are the bitmaps and inode table if
+// they are in the group, and the bits past the end of the filesystem.
+func (er *Reader) initBlockBitmap(group uint32, gd GroupDescriptor) Bitmap {
+	b := make(Bitmap, er.super.blockSize())
+	ratio := er.super.ClusterRatio()
+	for i := int64(0); i < er.baseMetaBlocks(group); i++ {
+		b.set(uint32(i / ratio))
+	}
+	itable := int64(er.super.InodesPerGroup) * int64(er.super.InodeSize) / er.super.blockSize()
+	blocks := []int64{gd.BlockBitmapBlock(), gd.InodeBitmapBlock()}
+	for i := int64(0); i < itable; i++ {
+		blocks = append(blocks, gd.InodeTableBlock()+i)
+	}
+	for _, block := range blocks {
+		if g, bit := er.blockGroup(block); g == group {
+			b.set(bit)
+		}
+	}
+	end := er.super.groupFirstBlock(group+1) - int64(er.super.BlocksCount())
+	if end < 0 {
+		end = 0
+	}
+	first := uint32((int64(er.super.BlocksPerGroup) - end + ratio - 1) / ratio)
+	for n := first; n < uint32(len(b))*8; n++ {
+		b.set(n)
+	}
+	return b
+}
+
+// baseMetaBlocks returns the number of blocks at the start of a group that
+// hold the superblock backup, the group descriptors and the reserved GDT
+// blocks, as ext4_num_base_meta_blocks counts them.
+func (er *Reader) baseMetaBlocks(group uint32) int64 {
+	var n int64
+	if er.super.HasSuperBlock(group) {
+		n = 1
+	}
+	descPerBlock := uint32(er.super.blockSize()) / er.super.gdSize()
+	if er.super.FeatureIncompat&FeatureIncompatFlagMetaBG == 0 {
+		if n > 0 {
+			gdBlocks := (er.super.GroupCount() + descPerBlock - 1) / descPerBlock
+			n += int64(gdBlocks) + int64(er.super.ReservedGdtBlocks)
+		}
+		return n
+	}
+	if group < er.super.FirstMetaBg*descPerBlock {
+		if n > 0 {
+			n += int64(er.super.FirstMetaBg) + int64(er.super.ReservedGdtBlocks)
+		}
+		return n
+	}
+	// a meta group has its descriptors in its first, second and last group
+	if i := group % descPerBlock; i == 0 || i == 1 || i == descPerBlock-1 {
+		n++
+	}
+	return n
+}
+
+// GetInodeBitmap returns the inode allocation bitmap of a block group.
+// Groups flagged INODE_UNINIT have no bitmap on disk (and no inodes in
+// use), for those an empty bitmap is returned.
+func (er *Reader) GetInodeBitmap(group uint32) (Bitmap, error) {
+	gd, err := er.GetGroupDescriptor(group)
+	if err != nil {
+		return nil, err
+	}
+	if gd.Flags&GroupFlagInodeUninit > 0 {
+		return make(Bitmap, er.super.blockSize()), nil
+	}
+	return er.readBitmap(gd.InodeBitmapBlock())
+}
+
+func (er *Reader) readBitmap(block int64) (Bitmap, error) {
+	b := make(Bitmap, er.super.blockSize())
+	_, err := er.readAt(b, er.blockOffset(block))
+	return b, err
+}
+
+// blockGroup maps a block number to its block group and the bit in that
+// group's block bitmap, which addresses clusters with bigalloc.
+func (er *Reader) blockGroup(block int64) (group, bit uint32) {
+	rel := block - int64(er.super.FirstDataBlock)
+	group = uint32(rel / int64(er.super.BlocksPerGroup))
+	bit = uint32((rel % int64(er.super.BlocksPerGroup)) / er.super.ClusterRatio())
+	return
+}
+
+// IsBlockAllocated reports whether the cluster holding the given block is
+// marked in use in its group's block bitmap.
+func (er *Reader) IsBlockAllocated(block int64) (bool, error) {
+	if block < int64(er.super.FirstDataBlock) || uint64(block) >= er.super.BlocksCount() {
+		return false, fmt.Errorf("Block %d out of range", block)
+	}
+	group, bit := er.blockGroup(block)
+	bm, err := er.GetBlockBitmap(group)
+	if err != nil {
+		return false, err
+	}
+	return bm.IsSet(bit), nil
+}
+
+// Usage describes the space and inode usage of the filesystem, as summed up
+// from the group descriptors. The counts in the superblock are only updated
+// lazily by the kernel, so these are usually more accurate.
+type Usage struct {
+	Blocks     uint64 // Total blocks.
+	FreeBlocks uint64 // Free blocks (free clusters times the cluster ratio).
+	Inodes     uint64 // Total inodes.
+	FreeInodes uint64 // Free inodes.
+	Dirs       uint64 // Directories.
+	BlockSize  int64  // Block size in bytes.
+}
+
+func (u Usage) String() string {
+	var pct float64
+	if u.Blocks > 0 {
+		pct = 100 * float64(u.Blocks-u.FreeBlocks) / float64(u.Blocks)
+	}
+	return fmt.Sprintf("%.1f of %.1f MiB used (%.1f%%), %d of %d inodes used, %d directories",
+		float64((u.Blocks-u.FreeBlocks)*uint64(u.BlockSize))/1024/1024,
+		float64(u.Blocks*uint64(u.BlockSize))/1024/1024,
+		pct, u.Inodes-u.FreeInodes, u.Inodes, u.Dirs)
+}
+
+func (er *Reader) Usage() (Usage, error) {
+	u := Usage{
+		Blocks:    er.super.BlocksCount(),
+		Inodes:    uint64(er.super.InodesCount),
+		BlockSize: er.super.blockSize(),
+	}
+	// a block of descriptors at a time, as each read may be a request
+	descPerBlock := uint32(er.super.blockSize()) / er.super.gdSize()
+	for nr := uint32(0); nr*descPerBlock < er.super.GroupCount(); nr++ {
+		gds, err := er.groupDescriptorBlock(nr)
+		if err != nil {
+			return u, err
+		}
+		for _, gd := range gds {
+			u.FreeBlocks += uint64(gd.FreeClustersCount()) * uint64(er.super.ClusterRatio())
+			u.FreeInodes += uint64(gd.FreeInodesCount())
+			u.Dirs += uint64(gd.UsedDirsCount())
+		}
+	}
+	return u, nil
+}
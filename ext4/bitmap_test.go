@@ -0,0 +1,93 @@
+package ext4
+
+import (
+	"bytes"
+	"testing"
+)
+
+// countingReader counts the reads made, which are requests on a blob.
+type countingReader struct {
+	*bytes.Reader
+	reads int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	c.reads++
+	return c.Reader.Read(b)
+}
+
+func TestUsage(t *testing.T) {
+	for _, name := range []string{"ext4", "bigalloc1k", "ext2"} {
+		t.Run(name, func(t *testing.T) {
+			b := readImage(t, name)
+			cr := &countingReader{Reader: bytes.NewReader(b)}
+			r, err := NewReader(cr, 0, uint32(len(b)/512))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expected Usage
+			expected.Blocks, expected.Inodes, expected.BlockSize = r.super.BlocksCount(), uint64(r.super.InodesCount), r.super.blockSize()
+			for g := uint32(0); g < r.super.GroupCount(); g++ {
+				gd, err := r.GetGroupDescriptor(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected.FreeBlocks += uint64(gd.FreeClustersCount()) * uint64(r.super.ClusterRatio())
+				expected.FreeInodes += uint64(gd.FreeInodesCount())
+				expected.Dirs += uint64(gd.UsedDirsCount())
+			}
+
+			cr.reads = 0
+			u, err := r.Usage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u != expected {
+				t.Errorf("Usage() = %+v, expected %+v", u, expected)
+			}
+			// all descriptors of the test images fit in one block
+			if cr.reads != 1 {
+				t.Errorf("Usage() read %d times, expected once", cr.reads)
+			}
+			if u.FreeBlocks != uint64(r.super.FreeBlocksCount()) || u.FreeInodes != uint64(r.super.FreeInodesCount) {
+				t.Errorf("Usage() = %+v, but the superblock of the unmounted filesystem has %d free blocks and %d free inodes",
+					u, r.super.FreeBlocksCount(), r.super.FreeInodesCount)
+			}
+		})
+	}
+}
+
+func TestBlockUninit(t *testing.T) {
+	for _, name := range []string{"ext4", "metabg"} {
+		t.Run(name, func(t *testing.T) {
+			r := openImage(t, readImage(t, name))
+			uninit := 0
+			for g := uint32(0); g < r.super.GroupCount(); g++ {
+				gd, err := r.GetGroupDescriptor(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if gd.Flags&GroupFlagBlockUninit == 0 {
+					continue
+				}
+				uninit++
+				bm, err := r.GetBlockBitmap(g)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// mke2fs counts the free clusters of the group the same way
+				clusters := uint32(r.super.BlocksCount()-uint64(r.super.groupFirstBlock(g))+uint64(r.super.ClusterRatio())-1) / uint32(r.super.ClusterRatio())
+				if clusters > r.super.ClustersPerGroup {
+					clusters = r.super.ClustersPerGroup
+				}
+				if used := bm.Count(clusters); used != clusters-gd.FreeClustersCount() {
+					t.Errorf("group %d: %d clusters in use, the descriptor says %d", g, used, clusters-gd.FreeClustersCount())
+				}
+			}
+			if uninit == 0 {
+				t.Fatal("no BLOCK_UNINIT groups; the image is not what the test expects")
+			}
+		})
+	}
+}
@@ -5,8 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"path"
+	"io/fs"
 	"regexp"
 	"strings"
 )
@@ -19,6 +18,7 @@ func (er Reader) Root() (Directory, error) {
 	return Directory{
 		r:     er,
 		inode: inode,
+		ino:   rootInode,
 		path:  "/",
 	}, nil
 }
@@ -26,9 +26,18 @@ func (er Reader) Root() (Directory, error) {
 type Directory struct {
 	r     Reader
 	inode Inode
+	ino   uint32
 	path  string
 }
 
+// Path returns the absolute path of the directory.
+func (d Directory) Path() string {
+	if d.path == "/" {
+		return d.path
+	}
+	return strings.TrimSuffix(d.path, "/")
+}
+
 func (d Directory) Entries() ([]DirEntry, error) {
 	b, err := d.r.GetInodeContent(d.inode)
 	if err != nil {
@@ -45,6 +54,10 @@ func (d Directory) Entries() ([]DirEntry, error) {
 		if err != nil {
 			return []DirEntry{}, err
 		}
+		if de.Inode == 0 {
+			// unused entry, htree node or checksum tail
+			continue
+		}
 		de.d = &d
 		entries = append(entries, de)
 	}
@@ -64,28 +77,6 @@ func (d Directory) findEntry(name string) (DirEntry, error) {
 	return DirEntry{}, ErrNotFound
 }
 
-func (d Directory) findEntries(glob string) ([]DirEntry, error) {
-	entries, err := d.Entries()
-	if err != nil {
-		return []DirEntry{}, err
-	}
-	matches := []DirEntry{}
-	for _, e := range entries {
-		if matched, err := path.Match(glob, e.Name.String()); err != nil {
-			return []DirEntry{}, err
-		} else if matched {
-			if e.Name.String() == "." && glob != "." {
-				continue
-			}
-			if e.Name.String() == ".." && glob != ".." {
-				continue
-			}
-			matches = append(matches, e)
-		}
-	}
-	return matches, nil
-}
-
 var slashes = regexp.MustCompile("/+")
 
 func normalizePath(path string) string {
@@ -103,66 +94,26 @@ func splitPath(path string) []string {
 }
 
 func (d Directory) ChangeDir(path string) (Directory, error) {
-	s := splitPath(path)
-	if len(s) == 0 {
+	if path == "" {
 		return Directory{}, fmt.Errorf("invalid path")
 	}
-	e, err := d.findEntry(s[0])
+	e, inode, err := d.Lookup(path, true)
 	if err != nil {
 		return Directory{}, err
 	}
-	for e.FileType == FileTypeSymlink {
-		e, err = e.ResolveSymlink()
-		if err != nil {
-			return Directory{}, err
-		}
-	}
-	if e.FileType == FileTypeDir {
-		inode, err := d.r.GetInode(e.Inode)
-		if err != nil {
-			return Directory{}, err
-		}
-		dir := Directory{
-			r:     d.r,
-			inode: inode,
-			path:  d.path + s[0] + "/",
-		}
-		if len(s) == 1 {
-			return dir, nil
-		}
-		return dir.ChangeDir(strings.Join(s[1:], "/"))
-	}
-	return Directory{}, fmt.Errorf("Not a directory or symlink: ", d.path+s[0])
-}
-
-func (d Directory) Match(glob string) ([]DirEntry, error) {
-	s := splitPath(glob)
-	if len(s) == 0 {
-		return []DirEntry{}, nil
-	}
-
-	matches, err := d.findEntries(s[0])
-	if err != nil {
-		return []DirEntry{}, err
-	}
-	if len(s) == 1 {
-		return matches, nil
+	if e.FileType != FileTypeDir {
+		return Directory{}, fmt.Errorf("Not a directory: %s", e.Fullname())
 	}
-	entries := []DirEntry{}
-	for _, m := range matches {
-		if m.FileType == FileTypeDir {
-			c, err := d.ChangeDir(m.Name.String())
-			if err != nil {
-				return []DirEntry{}, err
-			}
-			children, err := c.Match(strings.Join(s[1:], "/"))
-			if err != nil {
-				return []DirEntry{}, err
-			}
-			entries = append(entries, children...)
-		}
+	p := e.Fullname()
+	if p != "/" {
+		p += "/"
 	}
-	return entries, nil
+	return Directory{
+		r:     e.d.r,
+		inode: inode,
+		ino:   e.Inode,
+		path:  p,
+	}, nil
 }
 
 func readDirEntry(r io.Reader) (entry DirEntry, err error) {
@@ -188,24 +139,22 @@ func (e DirEntry) ReadSymlink() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	link, err := ioutil.ReadAll(inode.GetDataReader())
-	return string(link), err
+	return e.d.r.ReadLink(inode)
 }
 
+// ResolveSymlink follows the symlink, and any symlinks it points to, the way
+// the kernel would. The returned entry is never a symlink; its Fullname is
+// the path of the target.
 func (e DirEntry) ResolveSymlink() (DirEntry, error) {
-	link, err := e.ReadSymlink()
-	if err != nil {
-		return DirEntry{}, err
+	if e.FileType != FileTypeSymlink {
+		return DirEntry{}, fmt.Errorf("Not a symlink")
 	}
-
-	m, err := e.d.Match(string(link))
+	target, _, err := e.d.Lookup(e.Name.String(), true)
 	if err != nil {
-		return DirEntry{}, err
-	}
-	if len(m) == 0 {
-		return DirEntry{}, fmt.Errorf("DirEntry not found: %s", string(link))
+		link, _ := e.ReadSymlink()
+		return DirEntry{}, fmt.Errorf("%s -> %s: %v", e.Fullname(), link, err)
 	}
-	return m[0], nil
+	return target, nil
 }
 
 type DirEntry struct {
@@ -218,6 +167,12 @@ func (e DirEntry) Fullname() string {
 	return e.d.path + e.Name.String()
 }
 
+// Reader returns the reader of the filesystem holding the entry's inode,
+// which differs from the one the lookup started on after crossing a mount.
+func (e DirEntry) Reader() *Reader {
+	return &e.d.r
+}
+
 type charArray []byte
 
 func (c charArray) String() string {
@@ -245,6 +200,28 @@ const (
 	FileTypeSymlink  FileType = 0x7 // Symbolic link.
 )
 
+// fileMode returns the io/fs type bits for the file type.
+func (t FileType) fileMode() fs.FileMode {
+	switch t {
+	case FileTypeFile:
+		return 0
+	case FileTypeDir:
+		return fs.ModeDir
+	case FileTypeChardev:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case FileTypeBlockdev:
+		return fs.ModeDevice
+	case FileTypeFIFO:
+		return fs.ModeNamedPipe
+	case FileTypeSocket:
+		return fs.ModeSocket
+	case FileTypeSymlink:
+		return fs.ModeSymlink
+	default:
+		return fs.ModeIrregular
+	}
+}
+
 func (t FileType) String() string {
 	switch t {
 	case FileTypeUnknown:
@@ -264,6 +241,6 @@ func (t FileType) String() string {
 	case FileTypeSymlink:
 		return "Symlink"
 	default:
-		return fmt.Sprintf("FileType(0x%x)", t)
+		return fmt.Sprintf("FileType(0x%x)", byte(t))
 	}
 }
@@ -1,6 +1,7 @@
 package ext4
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -36,6 +37,22 @@ func (e Extent) Start() int64 {
 	return int64(e.StartHi)<<32 + int64(e.StartLo)
 }
 
+const maxInitializedExtentLen = 32768
+
+// Uninitialized reports whether the extent is preallocated but not yet
+// written, in which case it reads as zeros.
+func (e Extent) Uninitialized() bool {
+	return e.Len > maxInitializedExtentLen
+}
+
+// Length returns the number of blocks covered by the extent.
+func (e Extent) Length() uint16 {
+	if e.Uninitialized() {
+		return e.Len - maxInitializedExtentLen
+	}
+	return e.Len
+}
+
 func (er Reader) GetExtents(inode Inode) ([]Extent, error) {
 	if inode.Flags&InodeFlagExtents == 0 {
 		return nil, errNotImplemented
@@ -76,8 +93,11 @@ func (er Reader) readExtents(r io.Reader) ([]Extent, error) {
 
 		extents := []Extent{}
 		for _, idx := range extentIndexes {
-			er.s.Seek(er.blockOffset(idx.Leaf()), 0)
-			subextents, err := er.readExtents(er.s)
+			b := make([]byte, er.super.blockSize())
+			if _, err := er.readAt(b, er.blockOffset(idx.Leaf())); err != nil {
+				return nil, err
+			}
+			subextents, err := er.readExtents(bytes.NewReader(b))
 			if err != nil {
 				return nil, err
 			}
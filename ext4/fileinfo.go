@@ -0,0 +1,40 @@
+package ext4
+
+import (
+	"io/fs"
+	"path"
+	"time"
+)
+
+// FileInfo describes an inode and implements fs.FileInfo (and thus
+// os.FileInfo).
+type FileInfo struct {
+	name  string
+	Ino   uint32 // Inode number.
+	Inode Inode
+}
+
+// InodeInfo returns a FileInfo for inode n, to be reported under name.
+func (er *Reader) InodeInfo(n uint32, name string) (FileInfo, error) {
+	inode, err := er.GetInode(n)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		name:  path.Base(name),
+		Ino:   n,
+		Inode: inode,
+	}, nil
+}
+
+// Info returns the FileInfo of the inode the entry points to.
+func (e DirEntry) Info() (FileInfo, error) {
+	return e.d.r.InodeInfo(e.Inode, e.Name.String())
+}
+
+func (fi FileInfo) Name() string       { return fi.name }
+func (fi FileInfo) Size() int64        { return int64(fi.Inode.Size()) }
+func (fi FileInfo) Mode() fs.FileMode  { return fi.Inode.Mode.FileMode() }
+func (fi FileInfo) ModTime() time.Time { return fi.Inode.ModTime() }
+func (fi FileInfo) IsDir() bool        { return fi.Inode.Mode.FileType() == FileTypeDir }
+func (fi FileInfo) Sys() interface{}   { return fi.Inode }
@@ -0,0 +1,216 @@
+package ext4
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// FS presents the filesystem through the io/fs interfaces, so it can be
+// used with fs.WalkDir, fs.Glob, http.FS, template.ParseFS and friends.
+// Besides fs.FS it implements fs.ReadDirFS, fs.StatFS, fs.ReadFileFS and
+// fs.ReadLinkFS.
+//
+// Paths follow io/fs rules: they are unrooted, slash-separated and may not
+// contain "." or ".." elements. Symlinks are followed, also when they point
+// outside of the directory they're in, and so are mounts set up with Mount.
+type FS struct {
+	r *Reader
+}
+
+// FS returns an io/fs view of the filesystem.
+func (er *Reader) FS() *FS {
+	return &FS{r: er}
+}
+
+// resolve looks up name and returns its FileInfo together with the reader of
+// the filesystem holding it.
+func (fsys *FS) resolve(op, name string, follow bool) (FileInfo, *Reader, error) {
+	if !fs.ValidPath(name) {
+		return FileInfo{}, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := fsys.r.Root()
+	if err != nil {
+		return FileInfo{}, nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	e, inode, err := root.Lookup(name, follow)
+	if err == ErrNotFound {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return FileInfo{}, nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return FileInfo{name: path.Base(name), Ino: e.Inode, Inode: inode}, e.Reader(), nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	fi, er, err := fsys.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &fsDir{r: er, path: name, info: fi}, nil
+	}
+	r, err := er.GetInodeReader(fi.Inode)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{InodeReader: r, info: fi}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	fi, _, err := fsys.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// Lstat is like Stat, but does not follow a symlink in the last element.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	fi, _, err := fsys.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (fsys *FS) ReadLink(name string) (string, error) {
+	fi, er, err := fsys.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if fi.Inode.Mode.FileType() != FileTypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := er.ReadLink(fi.Inode)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	fi, er, err := fsys.resolve("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDir}
+	}
+	b, err := er.GetInodeContent(fi.Inode)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fi, er, err := fsys.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDir}
+	}
+	entries, err := readDir(er, fi)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// readDir lists a directory sorted by name, without "." and "..".
+func readDir(er *Reader, fi FileInfo) ([]fs.DirEntry, error) {
+	entries, err := Directory{r: *er, inode: fi.Inode, ino: fi.Ino}.Entries()
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		if name := e.Name.String(); name == "." || name == ".." {
+			continue
+		}
+		rv = append(rv, fsDirEntry{e})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name() < rv[j].Name() })
+	return rv, nil
+}
+
+// fsFile is an open regular file (or other non-directory).
+type fsFile struct {
+	InodeReader
+	info FileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is an open directory, implementing fs.ReadDirFile.
+type fsDir struct {
+	r       *Reader
+	path    string
+	info    FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: ErrIsDir}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := readDir(d.r, d.info)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		rv := d.entries
+		d.entries = nil
+		return rv, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	rv := d.entries[:n]
+	d.entries = d.entries[n:]
+	return rv, nil
+}
+
+// fsDirEntry adapts DirEntry to fs.DirEntry.
+type fsDirEntry struct {
+	e DirEntry
+}
+
+func (de fsDirEntry) Name() string { return de.e.Name.String() }
+func (de fsDirEntry) IsDir() bool  { return de.Type().IsDir() }
+
+func (de fsDirEntry) Type() fs.FileMode {
+	if de.e.FileType != FileTypeUnknown {
+		return de.e.FileType.fileMode()
+	}
+	// no filetype feature, go to the inode
+	fi, err := de.Info()
+	if err != nil {
+		return fs.ModeIrregular
+	}
+	return fi.Mode().Type()
+}
+
+func (de fsDirEntry) Info() (fs.FileInfo, error) {
+	fi, err := de.e.Info()
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
@@ -0,0 +1,50 @@
+package ext4
+
+import (
+	"bytes"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	for _, name := range []string{"ext4", "bigalloc1k", "ext2", "metabg"} {
+		t.Run(name, func(t *testing.T) {
+			r := openImage(t, readImage(t, name))
+			if err := fstest.TestFS(r.FS(), "etc/os-release", "etc/fstab", "etc/messages", "os-release",
+				"var/log/messages", "var/log/sparse", "var/log/empty", "home/user/.ssh/authorized_keys"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFSConcurrent(t *testing.T) {
+	r := openImage(t, readImage(t, "ext4"))
+	fsys := r.FS()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				b, err := fs.ReadFile(fsys, "var/log/messages")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(b, messages()) {
+					t.Errorf("var/log/messages has %d bytes that differ from what was written", len(b))
+				}
+				if _, err := fs.Stat(fsys, "home/user/.ssh/authorized_keys"); err != nil {
+					t.Error(err)
+				}
+				if _, err := r.Usage(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -1,19 +1,52 @@
 package ext4
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 func (er *Reader) GetGroupDescriptor(n uint32) (gd GroupDescriptor, err error) {
-	var gdblock int64 = 1
-	if er.super.blockSize() == 1024 {
-		gdblock = 2
-	}
-	_, err = er.s.Seek(er.blockOffset(gdblock)+int64(n)*int64(er.super.gdSize()), 0)
-	if err != nil {
+	descPerBlock := uint32(er.super.blockSize()) / er.super.gdSize()
+	gdblock := er.gdBlock(n / descPerBlock)
+	raw := make([]byte, er.super.gdSize())
+	if _, err = er.readAt(raw, er.blockOffset(gdblock)+int64(n%descPerBlock)*int64(er.super.gdSize())); err != nil {
 		return
 	}
-	err = binary.Read(er.s, binary.LittleEndian, &gd)
+	return er.decodeGroupDescriptor(raw)
+}
+
+// groupDescriptorBlock returns the group descriptors in the nth block of
+// the descriptor table, reading it at once. The last block may hold fewer
+// than fit in a block.
+func (er *Reader) groupDescriptorBlock(nr uint32) ([]GroupDescriptor, error) {
+	size := er.super.gdSize()
+	descPerBlock := uint32(er.super.blockSize()) / size
+	count := er.super.GroupCount() - nr*descPerBlock
+	if count > descPerBlock {
+		count = descPerBlock
+	}
+	raw := make([]byte, count*size)
+	if _, err := er.readAt(raw, er.blockOffset(er.gdBlock(nr))); err != nil {
+		return nil, err
+	}
+	gds := make([]GroupDescriptor, count)
+	for i := range gds {
+		gd, err := er.decodeGroupDescriptor(raw[uint32(i)*size : uint32(i+1)*size])
+		if err != nil {
+			return nil, err
+		}
+		gds[i] = gd
+	}
+	return gds, nil
+}
+
+// decodeGroupDescriptor decodes one entry of the descriptor table, which is
+// only 32 bytes long without the 64bit feature.
+func (er *Reader) decodeGroupDescriptor(raw []byte) (gd GroupDescriptor, err error) {
+	buf := make([]byte, binary.Size(gd))
+	copy(buf, raw)
+	err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &gd)
 
 	if er.super.FeatureIncompat&FeatureIncompatFlag64Bit == 0 {
 		gd.InodeTableHi = 0
@@ -31,6 +64,41 @@ func (er *Reader) GetGroupDescriptor(n uint32) (gd GroupDescriptor, err error) {
 	return
 }
 
+// gdBlock returns the location of the nth block of group descriptors.
+//
+// Without meta_bg, the group descriptor table directly follows the
+// superblock copy we're using. With meta_bg, the filesystem is split into
+// meta groups of as many groups as fit descriptors in one block, and each
+// meta group (from s_first_meta_bg onward) keeps its own block of
+// descriptors in its first group, with backups in the second and last group.
+func (er *Reader) gdBlock(nr uint32) int64 {
+	if er.super.FeatureIncompat&FeatureIncompatFlagMetaBG == 0 || nr < er.super.FirstMetaBg {
+		block := er.super.groupFirstBlock(er.sbGroup) + 1 + int64(nr)
+		if er.super.blockSize() == 1024 && er.sbGroup == 0 && er.super.FirstDataBlock == 0 {
+			// 1k blocks with bigalloc: the superblock fills block 1
+			block++
+		}
+		return block
+	}
+
+	descPerBlock := uint32(er.super.blockSize()) / er.super.gdSize()
+	group := nr * descPerBlock
+	if er.sbGroup != 0 && group+1 < er.super.GroupCount() {
+		// when running off a backup superblock, use the backup descriptors
+		// too, like e2fsck does
+		group++
+	}
+	var hasSuper int64
+	if er.super.HasSuperBlock(group) {
+		hasSuper = 1
+	}
+	if er.super.blockSize() == 1024 && group == 0 && er.super.FirstDataBlock == 0 {
+		// 1k blocks with bigalloc: group 0 GDT is at block 2, not 1
+		hasSuper++
+	}
+	return er.super.groupFirstBlock(group) + hasSuper
+}
+
 type GroupDescriptor struct {
 	BlockBitmapLo     uint32 // Lower 32-bits of location of block bitmap.
 	InodeBitmapLo     uint32 // Lower 32-bits of location of inode bitmap.
@@ -42,7 +110,7 @@ type GroupDescriptor struct {
 	//0x1	inode table and bitmap are not initialized (EXT4_BG_INODE_UNINIT).
 	//0x2	block bitmap is not initialized (EXT4_BG_BLOCK_UNINIT).
 	//0x4	inode table is zeroed (EXT4_BG_INODE_ZEROED).
-	Flags             uint16
+	Flags             GroupFlags
 	ExcludeBitmapLo   uint32 // Lower 32-bits of location of snapshot exclusion bitmap.
 	BlockBitmapCsumLo uint16 // Lower 16-bits of the block bitmap checksum.
 	InodeBitmapCsumLo uint16 // Lower 16-bits of the inode bitmap checksum.
@@ -65,3 +133,50 @@ type GroupDescriptor struct {
 func (gd GroupDescriptor) InodeTableBlock() int64 {
 	return int64(gd.InodeTableLo) + int64(gd.InodeTableHi)<<32
 }
+
+func (gd GroupDescriptor) BlockBitmapBlock() int64 {
+	return int64(gd.BlockBitmapLo) + int64(gd.BlockBitmapHi)<<32
+}
+
+func (gd GroupDescriptor) InodeBitmapBlock() int64 {
+	return int64(gd.InodeBitmapLo) + int64(gd.InodeBitmapHi)<<32
+}
+
+// FreeClustersCount returns the number of free clusters in the group. Note
+// that, unlike the superblock, group descriptors count clusters, not blocks.
+func (gd GroupDescriptor) FreeClustersCount() uint32 {
+	return uint32(gd.FreeBlocksCountLo) + uint32(gd.FreeBlocksCountHi)<<16
+}
+
+func (gd GroupDescriptor) FreeInodesCount() uint32 {
+	return uint32(gd.FreeInodesCountLo) + uint32(gd.FreeInodesCountHi)<<16
+}
+
+func (gd GroupDescriptor) UsedDirsCount() uint32 {
+	return uint32(gd.UsedDirsCountLo) + uint32(gd.UsedDirsCountHi)<<16
+}
+
+type GroupFlags uint16
+
+const (
+	GroupFlagInodeUninit GroupFlags = 0x1 // inode table and bitmap are not initialized (EXT4_BG_INODE_UNINIT).
+	GroupFlagBlockUninit GroupFlags = 0x2 // block bitmap is not initialized (EXT4_BG_BLOCK_UNINIT).
+	GroupFlagInodeZeroed GroupFlags = 0x4 // inode table is zeroed (EXT4_BG_INODE_ZEROED).
+)
+
+func (f GroupFlags) String() string {
+	flags := ""
+	if f&GroupFlagInodeUninit > 0 {
+		flags += "InodeUninit|"
+	}
+	if f&GroupFlagBlockUninit > 0 {
+		flags += "BlockUninit|"
+	}
+	if f&GroupFlagInodeZeroed > 0 {
+		flags += "InodeZeroed|"
+	}
+	if flags != "" {
+		flags = flags[:len(flags)-1]
+	}
+	return fmt.Sprintf("%s(0x%04x)", flags, uint16(f))
+}
@@ -6,11 +6,57 @@ import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"io"
+	"io/fs"
+	"sort"
+	"time"
 )
 
 func (er *Reader) GetInode(n uint32) (inode Inode, err error) {
+	offset, err := er.inodeOffset(n)
+	if err != nil {
+		return
+	}
+	b := make([]byte, binary.Size(inode))
+	if _, err = er.readAt(b, offset); err != nil {
+		return
+	}
+	if err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &inode); err != nil {
+		return
+	}
+	if er.super.InodeSize <= 128 {
+		// old-style inodes have no extra fields, what we read belongs to
+		// the next inode
+		inode.clearExtra()
+	}
+	return
+}
+
+func (inode *Inode) clearExtra() {
+	inode.ExtraIsize = 0
+	inode.ChecksumHi = 0
+	inode.CtimeExtra = 0
+	inode.MtimeExtra = 0
+	inode.AtimeExtra = 0
+	inode.Crtime = 0
+	inode.CrtimeExtra = 0
+	inode.VersionHi = 0
+}
+
+// readInodeBytes returns the full on-disk inode record, including the
+// extended attribute space after the fields decoded into Inode.
+func (er *Reader) readInodeBytes(n uint32) ([]byte, error) {
+	offset, err := er.inodeOffset(n)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, er.super.InodeSize)
+	_, err = er.readAt(b, offset)
+	return b, err
+}
+
+func (er *Reader) inodeOffset(n uint32) (int64, error) {
 	if n < 1 {
-		err = fmt.Errorf("inode number (n) should be 1-based and positive")
+		return 0, fmt.Errorf("inode number (n) should be 1-based and positive")
 	}
 	n--
 	bg := n / er.super.InodesPerGroup
@@ -18,124 +64,149 @@ func (er *Reader) GetInode(n uint32) (inode Inode, err error) {
 
 	gd, err := er.GetGroupDescriptor(bg)
 	if err != nil {
-		return
+		return 0, err
 	}
-	if _, err = er.s.Seek(er.blockOffset(gd.InodeTableBlock())+int64(er.super.InodeSize)*int64(index), 0); err != nil {
-		return
-	}
-	err = binary.Read(er.s, binary.LittleEndian, &inode)
-	return
+	return er.blockOffset(gd.InodeTableBlock()) + int64(er.super.InodeSize)*int64(index), nil
 }
 
 var log = logrus.New()
 
-func (er *Reader) GetInodeReader(inode Inode) (io.Reader, error) {
-	indr := inodeDataReader{
-		er:     er,
-		length: int64(inode.Size()),
+// InodeReader reads the contents of an inode. Holes and uninitialized
+// extents read as zeros.
+type InodeReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.WriterTo
+	Size() int64
+}
+
+func (er *Reader) GetInodeReader(inode Inode) (InodeReader, error) {
+	extents, err := er.DataExtents(inode)
+	if err != nil {
+		return nil, err
 	}
-	if inode.Flags&InodeFlagExtents > 0 {
-		extents, err := er.GetExtents(inode)
-		if err != nil {
-			return nil, err
-		}
-		indr.extents = extents
-		return &indr, nil
-	} else {
-		log.Infoln("Trying to read block map")
+	return &inodeDataReader{
+		er:      er,
+		length:  int64(inode.Size()),
+		extents: extents,
+	}, nil
+}
 
-		blocks, err := er.readBlockMap(inode)
-		if err != nil {
-			return nil, err
+// DataExtents returns the extents holding the data of the inode. For inodes
+// with a block map, runs of consecutive blocks are merged into extents.
+func (er *Reader) DataExtents(inode Inode) ([]Extent, error) {
+	if inode.Flags&InodeFlagExtents > 0 {
+		return er.GetExtents(inode)
+	}
+	log.Infoln("Trying to read block map")
+	blocks, err := er.readBlockMap(inode)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("  %d block pointers", len(blocks))
+	var extents []Extent
+	for i, p := range blocks {
+		if p == 0 { // hole
+			continue
 		}
-		log.Infof("  block pointers: %+v", blocks)
-		for i, p := range blocks {
-			indr.extents = append(indr.extents, Extent{
-				Block:   uint32(i),
-				Len:     1,
-				StartLo: p,
-				StartHi: 0,
-			})
+		// merge runs of consecutive blocks into a single extent
+		if n := len(extents); n > 0 {
+			last := &extents[n-1]
+			if last.Block+uint32(last.Len) == uint32(i) &&
+				last.Start()+int64(last.Len) == int64(p) &&
+				last.Len < maxInitializedExtentLen {
+				last.Len++
+				continue
+			}
 		}
-		return &indr, nil
-	}
+		extents = append(extents, Extent{
+			Block:   uint32(i),
+			Len:     1,
+			StartLo: p,
+			StartHi: 0,
+		})
+	}
+	return extents, nil
 }
 
 func (er *Reader) readBlockMap(inode Inode) ([]uint32, error) {
 	// the inode block map is layed out as 12 direct pointers to
 	// blocks, followed by three indirect pointers with increasing
-	// levels of indirection. The first 0-pointer encountered walking
-	// the structure indicates the end of the pointer array.
+	// levels of indirection. A 0-pointer is a hole in the file; the
+	// file size determines where the map ends.
 	// See https://en.wikipedia.org/wiki/Inode_pointer_structure
 
-	nodeData := inode.GetDataReader()
-	blocks := make([]uint32, 12)
-	err := binary.Read(nodeData, binary.LittleEndian, &blocks)
-	if err != nil {
+	blockSize := uint64(er.super.blockSize())
+	nblocks := int((inode.Size() + blockSize - 1) / blockSize)
+
+	pointers := make([]uint32, 15)
+	if err := binary.Read(inode.GetDataReader(), binary.LittleEndian, &pointers); err != nil {
 		return nil, err
 	}
-	log.Infof("  direct block pointers: %+v", blocks)
-	for i, b := range blocks {
-		if b == 0 {
-			return blocks[:i], nil
+
+	blocks := make([]uint32, 0, nblocks)
+	for _, p := range pointers[:12] {
+		if len(blocks) == nblocks {
+			return blocks, nil
 		}
+		blocks = append(blocks, p)
 	}
 
-	// if no 0-pointer encountered, then dig into indirect pointers
-	pointers := make([]uint32, 3)
-	if err := binary.Read(nodeData, binary.LittleEndian, &pointers); err != nil {
-		return nil, err
-	}
-	for i, p := range pointers {
-		if p == 0 {
+	// then dig into indirect pointers
+	var err error
+	for i, p := range pointers[12:] {
+		if len(blocks) == nblocks {
 			break
 		}
-		indirectblocks, err, done := er.readIndirectBlockMap(p, i+1)
+		blocks, err = er.readIndirectBlockMap(blocks, p, i+1, nblocks)
 		if err != nil {
 			return nil, err
 		}
-		blocks = append(blocks, indirectblocks...)
-		if done {
-			break
-		}
-	}
-
-	for i, b := range blocks {
-		if b == 0 {
-			return blocks[:i], nil
-		}
 	}
 	return blocks, nil
 }
 
-func (er *Reader) readIndirectBlockMap(pointer uint32, level int) ([]uint32, error, bool) {
-	if level == 0 {
-		if pointer == 0 {
-			return []uint32{}, nil, true
+// readIndirectBlockMap appends the block pointers reachable through an
+// indirect block at the given level of indirection, up to nblocks in total.
+func (er *Reader) readIndirectBlockMap(blocks []uint32, pointer uint32, level int, nblocks int) ([]uint32, error) {
+	perBlock := int(er.super.blockSize() / 4)
+	if pointer == 0 {
+		// the whole range covered by this pointer is a hole
+		span := 1
+		for i := 0; i < level; i++ {
+			span *= perBlock
 		}
-		return []uint32{pointer}, nil, false
+		for i := 0; i < span && len(blocks) < nblocks; i++ {
+			blocks = append(blocks, 0)
+		}
+		return blocks, nil
 	}
+
 	log.Infof("  reading one block of indirect block pointers with indirection level %d", level)
-	pointers := make([]uint32, er.super.blockSize())
-	if _, err := er.s.Seek(er.blockOffset(int64(pointer)), 0); err != nil {
-		return nil, err, false
+	pointers := make([]uint32, perBlock)
+	raw := make([]byte, 4*perBlock)
+	if _, err := er.readAt(raw, er.blockOffset(int64(pointer))); err != nil {
+		return nil, err
 	}
-	if err := binary.Read(er.s, binary.LittleEndian, &pointers); err != nil {
-		return nil, err, false
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &pointers); err != nil {
+		return nil, err
 	}
-	var blocks []uint32
-	for i, p := range pointers {
-		indirectblocks, err, done := er.readIndirectBlockMap(p, level-1)
-		if err != nil {
-			return nil, err, false
+	var err error
+	for _, p := range pointers {
+		if len(blocks) == nblocks {
+			break
 		}
-		blocks = append(blocks, indirectblocks...)
-		if done {
-			log.Infof("  end of block map found after following %d pointers at level %d", i, level)
-			return blocks, nil, done
+		if level == 1 {
+			blocks = append(blocks, p)
+			continue
+		}
+		blocks, err = er.readIndirectBlockMap(blocks, p, level-1, nblocks)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return blocks, nil, false
+	return blocks, nil
 }
 
 func (er *Reader) GetInodeContent(inode Inode) ([]byte, error) {
@@ -144,114 +215,133 @@ func (er *Reader) GetInodeContent(inode Inode) ([]byte, error) {
 		return nil, err
 	}
 	b := make([]byte, inode.Size())
-	_, err = r.Read(b)
+	_, err = io.ReadFull(r, b)
 	return b, err
 }
 
 type inodeDataReader struct {
 	er      *Reader
-	extents []Extent
+	extents []Extent // sorted by logical block
 	offset  int64
 	length  int64
 }
 
-func (iodr *inodeDataReader) Read(b []byte) (n int, err error) {
-	l := len(b)
-	blockSize := iodr.er.super.blockSize()
+func (iodr *inodeDataReader) Size() int64 {
+	return iodr.length
+}
 
-	for _, extent := range iodr.extents {
-		if n == l { // satisfied request
-			return
-		}
-		if iodr.offset >= iodr.length { // file exhausted
-			err = io.EOF
-			return
-		}
+func (iodr *inodeDataReader) Read(b []byte) (n int, err error) {
+	n, err = iodr.ReadAt(b, iodr.offset)
+	iodr.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return
+}
 
-		extentStartOffset := int64(extent.Block) * blockSize
-		extentEndOffset := int64(extent.Block+uint32(extent.Len)) * blockSize
-		if iodr.offset < extentStartOffset ||
-			iodr.offset >= extentEndOffset {
-			continue
-		}
+// ReadAt reads len(b) bytes of file content at offset off, returning zeros
+// for parts of the file not backed by initialized extents.
+func (iodr *inodeDataReader) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot read at negative offset: %d", off)
+	}
+	if off >= iodr.length {
+		return 0, io.EOF
+	}
+	if left := iodr.length - off; int64(len(b)) > left {
+		b = b[:left]
+		defer func() {
+			if err == nil {
+				err = io.EOF
+			}
+		}()
+	}
 
-		chunkSize := l - n
-		leftInExtent := extentEndOffset - iodr.offset
-		leftInNode := iodr.length - iodr.offset
-		if int64(chunkSize) > leftInExtent {
-			chunkSize = int(leftInExtent)
-		}
-		if int64(chunkSize) > leftInNode {
-			chunkSize = int(leftInNode)
+	blockSize := iodr.er.super.blockSize()
+	for n < len(b) {
+		pos := off + int64(n)
+		chunk := b[n:]
+
+		// find the extent that covers pos, or the next one after it
+		i := sort.Search(len(iodr.extents), func(i int) bool {
+			return int64(iodr.extents[i].Block)*blockSize > pos
+		})
+		if i > 0 {
+			extent := iodr.extents[i-1]
+			extentStartOffset := int64(extent.Block) * blockSize
+			extentEndOffset := extentStartOffset + int64(extent.Length())*blockSize
+			if pos < extentEndOffset {
+				if int64(len(chunk)) > extentEndOffset-pos {
+					chunk = chunk[:extentEndOffset-pos]
+				}
+				if extent.Uninitialized() {
+					n += zero(chunk)
+					continue
+				}
+				nn, rerr := iodr.er.readAt(chunk, iodr.er.blockOffset(extent.Start())+pos-extentStartOffset)
+				n += nn
+				if rerr != nil {
+					return n, rerr
+				}
+				continue
+			}
 		}
 
-		iodr.er.s.Seek(iodr.er.blockOffset(extent.Start())+
-			iodr.offset-extentStartOffset, 0)
-
-		nn, err := iodr.er.s.Read(b[n : n+chunkSize])
-		iodr.offset += int64(nn)
-		n += nn
-		if err != nil {
-			return n, err
+		// pos is in a hole, which extends up to the next extent
+		if i < len(iodr.extents) {
+			if next := int64(iodr.extents[i].Block)*blockSize - pos; int64(len(chunk)) > next {
+				chunk = chunk[:next]
+			}
 		}
+		n += zero(chunk)
 	}
 	return
 }
 
-func (iodr *inodeDataReader) WriteTo(w io.Writer) (n int64, err error) {
-	blockSize := iodr.er.super.blockSize()
-
-	for _, extent := range iodr.extents {
-		//fmt.Printf("extent: %+v\n", extent)
-		if iodr.offset >= iodr.length { // file exhausted, should never happen
-			err = io.EOF
-			return
-		}
+func zero(b []byte) int {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b)
+}
 
-		extentStartOffset := int64(extent.Block) * blockSize
-		extentEndOffset := int64(extent.Block+uint32(extent.Len)) * blockSize
-		if iodr.offset < extentStartOffset ||
-			iodr.offset >= extentEndOffset {
-			continue
-		}
+func (iodr *inodeDataReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += iodr.offset
+	case 2:
+		offset += iodr.length
+	default:
+		return 0, fmt.Errorf("Illegal value for parameter whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Cannot seek to negative offset: %d", offset)
+	}
+	iodr.offset = offset
+	return offset, nil
+}
 
-		chunkSize := extentEndOffset - iodr.offset
-		leftInNode := iodr.length - iodr.offset
-		if int64(chunkSize) > leftInNode {
-			chunkSize = leftInNode
-		}
+// io.Copy* has a fixed buffer size of 32k, resulting in overly chatty HTTP
+const writeToChunkSize = 1024 * 1024 * 4
 
-		iodr.er.s.Seek(iodr.er.blockOffset(extent.Start())+
-			iodr.offset-extentStartOffset, 0)
-		// io.Copy* has a fixed buffer size of 32k, resulting in overly chatty HTTP
-		if chunkSize < 1024*1024*4 {
-			buf := make([]byte, chunkSize)
-			for bn := 0; bn < len(buf) && err == nil; {
-				nn, nerr := iodr.er.s.Read(buf[bn:])
-				err = nerr
-				bn += nn
-				iodr.offset += int64(nn)
-				n += int64(nn)
-				if err != nil {
-					return n, err
-				}
-			}
-			for bn := 0; bn < len(buf) && err == nil; {
-				nn, nerr := w.Write(buf)
-				err = nerr
-				bn += nn
-				if err != nil {
-					return n, err
-				}
-			}
-		} else {
-			nn, err := io.CopyN(w, iodr.er.s, chunkSize)
-			iodr.offset += int64(nn)
-			n += nn
-			if err != nil {
-				return n, err
+func (iodr *inodeDataReader) WriteTo(w io.Writer) (n int64, err error) {
+	buf := make([]byte, writeToChunkSize)
+	for iodr.offset < iodr.length {
+		nn, rerr := iodr.Read(buf)
+		if nn > 0 {
+			wn, werr := w.Write(buf[:nn])
+			n += int64(wn)
+			if werr != nil {
+				return n, werr
 			}
 		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return n, rerr
+		}
 	}
 	return n, nil
 }
@@ -411,6 +501,11 @@ func (f InodeFlags) String() string {
 type InodeMode uint16
 
 func (m InodeMode) String() string {
+	return fmt.Sprintf("%s(0x%04x)", m.Symbolic(), uint16(m))
+}
+
+// Symbolic returns the mode the way ls -l shows it, e.g. "drwxr-xr-x".
+func (m InodeMode) Symbolic() string {
 	rv := ""
 	switch m & 0xF000 {
 	case 0x1000:
@@ -494,13 +589,140 @@ func (m InodeMode) String() string {
 		rv += "-"
 	}
 
-	return fmt.Sprintf("%s(0x%04x)", rv, uint16(m))
+	return rv
+}
+
+// FileType returns the type of file encoded in the mode.
+func (m InodeMode) FileType() FileType {
+	switch m & 0xF000 {
+	case 0x1000:
+		return FileTypeFIFO
+	case 0x2000:
+		return FileTypeChardev
+	case 0x4000:
+		return FileTypeDir
+	case 0x6000:
+		return FileTypeBlockdev
+	case 0x8000:
+		return FileTypeFile
+	case 0xA000:
+		return FileTypeSymlink
+	case 0xC000:
+		return FileTypeSocket
+	default:
+		return FileTypeUnknown
+	}
+}
+
+// FileMode converts the mode to its io/fs equivalent.
+func (m InodeMode) FileMode() fs.FileMode {
+	rv := fs.FileMode(m&0777) | m.FileType().fileMode()
+	if m&0x800 > 0 {
+		rv |= fs.ModeSetuid
+	}
+	if m&0x400 > 0 {
+		rv |= fs.ModeSetgid
+	}
+	if m&0x200 > 0 {
+		rv |= fs.ModeSticky
+	}
+	return rv
 }
 
 func (inode Inode) Size() uint64 {
 	return uint64(inode.SizeLo) + uint64(inode.SizeHigh)<<32
 }
 
+// UID returns the full 32-bit owner id.
+func (inode Inode) UID() uint32 {
+	return uint32(inode.Uid) | uint32(inode.UidHigh)<<16
+}
+
+// GID returns the full 32-bit group id.
+func (inode Inode) GID() uint32 {
+	return uint32(inode.Gid) | uint32(inode.GidHigh)<<16
+}
+
+// Device returns the major and minor number of a character or block device,
+// which are kept in the first or, in the new encoding, second word of Data.
+func (inode Inode) Device() (major, minor uint32) {
+	if old := binary.LittleEndian.Uint32(inode.Data[0:]); old != 0 {
+		return (old >> 8) & 0xff, old & 0xff
+	}
+	dev := binary.LittleEndian.Uint32(inode.Data[4:])
+	return (dev & 0xfff00) >> 8, (dev & 0xff) | ((dev >> 12) & 0xfff00)
+}
+
+// on-disk offsets of the extra inode fields
+const (
+	offsetCtimeExtra  = 0x84
+	offsetMtimeExtra  = 0x88
+	offsetAtimeExtra  = 0x8C
+	offsetCrtime      = 0x90
+	offsetCrtimeExtra = 0x94
+)
+
+// hasExtra reports whether the 32-bit extra inode field at the given offset
+// is within i_extra_isize.
+func (inode Inode) hasExtra(offset int) bool {
+	return 128+int(inode.ExtraIsize) >= offset+4
+}
+
+// inodeTime decodes a timestamp with its extra field, which holds the
+// nanoseconds in the upper 30 bits and extends the epoch past 2038 with the
+// lower 2 bits.
+func inodeTime(seconds uint32, extra uint32, hasExtra bool) time.Time {
+	sec := int64(int32(seconds))
+	var nsec int64
+	if hasExtra {
+		sec += int64(extra&3) << 32
+		nsec = int64(extra >> 2)
+	}
+	return time.Unix(sec, nsec)
+}
+
+func (inode Inode) AccessTime() time.Time {
+	return inodeTime(inode.Atime, inode.AtimeExtra, inode.hasExtra(offsetAtimeExtra))
+}
+
+func (inode Inode) ModTime() time.Time {
+	return inodeTime(inode.Mtime, inode.MtimeExtra, inode.hasExtra(offsetMtimeExtra))
+}
+
+func (inode Inode) ChangeTime() time.Time {
+	return inodeTime(inode.Ctime, inode.CtimeExtra, inode.hasExtra(offsetCtimeExtra))
+}
+
+// BirthTime returns the creation time of the inode, or the zero time if
+// the inode is too small to record it.
+func (inode Inode) BirthTime() time.Time {
+	if !inode.hasExtra(offsetCrtime) {
+		return time.Time{}
+	}
+	return inodeTime(inode.Crtime, inode.CrtimeExtra, inode.hasExtra(offsetCrtimeExtra))
+}
+
+// DeletionTime returns when the inode was deleted, or the zero time.
+func (inode Inode) DeletionTime() time.Time {
+	if inode.Dtime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(inode.Dtime), 0)
+}
+
+// AllocatedSize returns the number of bytes allocated on disk for the
+// inode, including metadata blocks such as extent tree nodes.
+func (er *Reader) AllocatedSize(inode Inode) int64 {
+	if er.super.FeatureROCompat&FeatureROCompatFlagHugeFile == 0 {
+		return int64(inode.BlocksLo) * 512
+	}
+	blocks := int64(inode.BlocksLo) + int64(inode.BlocksHigh)<<32
+	if inode.Flags&InodeFlagHugeFile > 0 {
+		return blocks * er.super.blockSize()
+	}
+	return blocks * 512
+}
+
 func (inode Inode) GetDataReader() io.Reader {
 	return bytes.NewReader(inode.Data[:])
 }
@@ -0,0 +1,78 @@
+package ext4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestInodeReaderReadAtConcurrently(t *testing.T) {
+	for _, name := range []string{"ext4", "ext2"} {
+		t.Run(name, func(t *testing.T) {
+			r := openImage(t, readImage(t, name))
+			root, err := r.Root()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, inode, err := root.Lookup("var/log/messages", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir, err := r.GetInodeReader(inode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := messages()
+
+			var wg sync.WaitGroup
+			errs := make(chan string, 16)
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					off := int64(i * 997 % len(expected))
+					b, err := ioutil.ReadAll(io.NewSectionReader(ir, off, 1500))
+					if err != nil {
+						errs <- err.Error()
+						return
+					}
+					end := off + 1500
+					if end > int64(len(expected)) {
+						end = int64(len(expected))
+					}
+					if !bytes.Equal(b, expected[off:end]) {
+						errs <- fmt.Sprintf("content at offset %d differs", off)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestInodeReaderHoles(t *testing.T) {
+	r := openImage(t, readImage(t, "ext4"))
+	root, err := r.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, inode, err := root.Lookup("var/log/sparse", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.GetInodeContent(inode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(make([]byte, 1<<20), "tail\n"...)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("var/log/sparse is not 1 MiB of zeros followed by the tail")
+	}
+}
@@ -0,0 +1,264 @@
+package ext4
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrTooManyMatches = fmt.Errorf("Too many matching files")
+
+// MatchOptions controls MatchAll.
+type MatchOptions struct {
+	Exclude         []string // Patterns of paths to leave out. Matching directories are not descended into.
+	CaseInsensitive bool     // Compare names regardless of case.
+	MaxDepth        int      // Number of directory levels below the first wildcard of a pattern to descend into at most, 0 for no limit.
+	MaxFiles        int      // Number of matches to return at most, 0 for no limit.
+}
+
+// Match returns the entries matching glob. See MatchAll for the syntax.
+func (d Directory) Match(glob string) ([]DirEntry, error) {
+	return d.MatchAll([]string{glob}, MatchOptions{})
+}
+
+// MatchAll returns the entries matching any of the patterns, each path only
+// once, in the order of the patterns. Besides the path.Match syntax for each
+// element, a pattern may contain "**" elements, which match any number of
+// directories, and {a,b} alternatives like the shell's brace expansion.
+// Patterns starting with "!" exclude paths, like opts.Exclude does. Relative
+// patterns are relative to d.
+//
+// Symlinks to directories are not descended into. If more than
+// opts.MaxFiles entries match, the first ones are returned with
+// ErrTooManyMatches.
+func (d Directory) MatchAll(patterns []string, opts MatchOptions) ([]DirEntry, error) {
+	m := matcher{opts: opts, seen: map[string]bool{}}
+	var includes []string
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			m.exclude = append(m.exclude, m.absolute(d, p[1:])...)
+		} else {
+			includes = append(includes, expandBraces(p)...)
+		}
+	}
+	for _, p := range opts.Exclude {
+		m.exclude = append(m.exclude, m.absolute(d, p)...)
+	}
+
+	for _, patterns := range [][]string{includes, m.exclude} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+		}
+	}
+
+	for _, p := range includes {
+		start := d
+		if strings.HasPrefix(p, "/") {
+			root, err := d.r.nsRoot()
+			if err != nil {
+				return m.matches, err
+			}
+			start = root
+		}
+		if err := m.match(start, splitPath(p), 0); err != nil {
+			return m.matches, err
+		}
+	}
+	return m.matches, nil
+}
+
+type matcher struct {
+	opts    MatchOptions
+	exclude []string // absolute, brace-expanded
+	seen    map[string]bool
+	matches []DirEntry
+}
+
+// absolute expands the braces in an exclude pattern and makes it absolute.
+func (m *matcher) absolute(d Directory, pattern string) []string {
+	rv := expandBraces(pattern)
+	for i, p := range rv {
+		if !strings.HasPrefix(p, "/") {
+			rv[i] = d.path + p
+		}
+	}
+	return rv
+}
+
+func (m *matcher) match(d Directory, components []string, depth int) error {
+	for len(components) > 0 && components[0] == "" {
+		components = components[1:]
+	}
+	if len(components) == 0 {
+		return nil
+	}
+	c, rest := components[0], components[1:]
+
+	if c == "**" && len(rest) > 0 {
+		// zero directories
+		if err := m.match(d, rest, depth); err != nil {
+			return err
+		}
+	}
+
+	entries, err := d.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name.String()
+		if c == "**" {
+			if name == "." || name == ".." {
+				continue
+			}
+		} else if name == "." || name == ".." {
+			// only matched when spelled out
+			if c != name {
+				continue
+			}
+		} else if !matchName(c, name, m.opts.CaseInsensitive) {
+			continue
+		}
+		if m.excluded(e) {
+			continue
+		}
+
+		if len(rest) == 0 {
+			if err := m.add(e); err != nil {
+				return err
+			}
+			if c != "**" {
+				continue
+			}
+		}
+		if e.FileType != FileTypeDir && e.FileType != FileTypeUnknown {
+			continue
+		}
+		if m.opts.MaxDepth > 0 && depth >= m.opts.MaxDepth {
+			continue
+		}
+
+		var child Directory
+		if name == "." || name == ".." {
+			if child, err = d.ChangeDir(name); err != nil {
+				return err
+			}
+		} else {
+			inode, err := d.r.GetInode(e.Inode)
+			if err != nil {
+				return err
+			}
+			if inode.Mode.FileType() != FileTypeDir {
+				continue
+			}
+			child = d.subdir(e, inode)
+		}
+		next := rest
+		if c == "**" {
+			next = components
+		}
+		// the literal directories before the first wildcard do not count
+		nextDepth := depth
+		if depth > 0 || strings.ContainsAny(c, "*?[") {
+			nextDepth++
+		}
+		if err := m.match(child, next, nextDepth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *matcher) add(e DirEntry) error {
+	name := e.Fullname()
+	if m.seen[name] {
+		return nil
+	}
+	if m.opts.MaxFiles > 0 && len(m.matches) >= m.opts.MaxFiles {
+		return ErrTooManyMatches
+	}
+	m.seen[name] = true
+	m.matches = append(m.matches, e)
+	return nil
+}
+
+func (m *matcher) excluded(e DirEntry) bool {
+	name := splitPath(e.Fullname())
+	for _, p := range m.exclude {
+		if matchComponents(splitPath(p), name, m.opts.CaseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchComponents matches a split path against a split pattern that may
+// contain "**" elements.
+func matchComponents(pattern, name []string, fold bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchComponents(pattern[1:], name[i:], fold) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 || !matchName(pattern[0], name[0], fold) {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchName(pattern, name string, fold bool) bool {
+	if fold {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// expandBraces expands {a,b} alternatives the way the shell does, e.g.
+// "/var/log/{messages,syslog}*" becomes "/var/log/messages*" and
+// "/var/log/syslog*". Braces without a comma are left alone.
+func expandBraces(pattern string) []string {
+	start, depth := -1, 0
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+				commas = nil
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 || len(commas) == 0 {
+				continue
+			}
+			var rv []string
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			from := start + 1
+			for _, to := range append(commas, i) {
+				rv = append(rv, expandBraces(prefix+pattern[from:to]+suffix)...)
+				from = to + 1
+			}
+			return rv
+		}
+	}
+	return []string{pattern}
+}
@@ -0,0 +1,96 @@
+package ext4
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"/var/log/messages", []string{"/var/log/messages"}},
+		{"/var/log/{messages,syslog}*", []string{"/var/log/messages*", "/var/log/syslog*"}},
+		{"/etc/{a,b}/{c,d}", []string{"/etc/a/c", "/etc/a/d", "/etc/b/c", "/etc/b/d"}},
+		{"/etc/{a,{b,c}d}", []string{"/etc/a", "/etc/bd", "/etc/cd"}},
+		{"/etc/{a,}x", []string{"/etc/ax", "/etc/x"}},
+		{"/etc/{single}", []string{"/etc/{single}"}},
+		{"/etc/\\{a,b}", []string{"/etc/\\{a,b}"}},
+		{"/etc/{a,b", []string{"/etc/{a,b"}},
+	}
+	for _, test := range tests {
+		if rv := expandBraces(test.pattern); !reflect.DeepEqual(rv, test.expected) {
+			t.Errorf("expandBraces(%q) = %q, expected %q", test.pattern, rv, test.expected)
+		}
+	}
+}
+
+func TestMatchComponents(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		fold    bool
+		match   bool
+	}{
+		{"/var/log/*", "/var/log/messages", false, true},
+		{"/var/log/*", "/var/log/journal/x", false, false},
+		{"/var/**", "/var/log/journal/x", false, true},
+		{"/var/**", "/var", false, true},
+		{"/var/**/x", "/var/x", false, true},
+		{"/var/**/x", "/var/log/journal/x", false, true},
+		{"/var/**/x", "/var/log/journal/y", false, false},
+		{"/**/*.log", "/var/log/waagent.log", false, true},
+		{"/var/LOG/*", "/var/log/messages", false, false},
+		{"/var/LOG/*", "/var/log/messages", true, true},
+	}
+	for _, test := range tests {
+		if m := matchComponents(splitPath(test.pattern), splitPath(test.name), test.fold); m != test.match {
+			t.Errorf("matchComponents(%q, %q, %v) = %v, expected %v", test.pattern, test.name, test.fold, m, test.match)
+		}
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		opts     MatchOptions
+		expected string
+		err      error
+	}{
+		{[]string{"/etc/{fstab,os-release}"}, MatchOptions{}, "/etc/fstab /etc/os-release", nil},
+		{[]string{"/var/log/**"}, MatchOptions{}, "/var/log/empty /var/log/messages /var/log/sparse", nil},
+		{[]string{"/var/log/**", "!/var/log/s*"}, MatchOptions{}, "/var/log/empty /var/log/messages", nil},
+		{[]string{"/var/log/**"}, MatchOptions{Exclude: []string{"/var/log/empty"}}, "/var/log/messages /var/log/sparse", nil},
+		{[]string{"/**/authorized_keys"}, MatchOptions{}, "/home/user/.ssh/authorized_keys", nil},
+		{[]string{"/**/authorized_keys"}, MatchOptions{MaxDepth: 2}, "", nil},
+		{[]string{"/home/*/.ssh/authorized_keys"}, MatchOptions{MaxDepth: 2}, "/home/user/.ssh/authorized_keys", nil},
+		{[]string{"/home/user/.ssh/*"}, MatchOptions{MaxDepth: 1}, "/home/user/.ssh/authorized_keys", nil},
+		{[]string{"/ETC/FSTAB"}, MatchOptions{CaseInsensitive: true}, "/etc/fstab", nil},
+		{[]string{"/etc/*", "/etc/fstab"}, MatchOptions{}, "/etc/fstab /etc/messages /etc/os-release /etc/ssh", nil},
+		{[]string{"/var/log/*"}, MatchOptions{MaxFiles: 2}, "/var/log/empty /var/log/messages", ErrTooManyMatches},
+	}
+	r := openImage(t, readImage(t, "ext4"))
+	root, err := r.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		entries, err := root.MatchAll(test.patterns, test.opts)
+		if err != test.err {
+			t.Errorf("MatchAll(%q, %+v): %v, expected %v", test.patterns, test.opts, err, test.err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Fullname())
+		}
+		if strings.Join(names, " ") != test.expected {
+			t.Errorf("MatchAll(%q, %+v) = %q, expected %q", test.patterns, test.opts, names, test.expected)
+		}
+	}
+
+	if _, err := root.MatchAll([]string{"/etc/["}, MatchOptions{}); err == nil {
+		t.Errorf("MatchAll() with a bad pattern did not fail")
+	}
+}
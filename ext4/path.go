@@ -0,0 +1,227 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrNotDir = fmt.Errorf("Not a directory")
+
+var ErrIsDir = fmt.Errorf("Is a directory")
+
+var ErrTooManySymlinks = fmt.Errorf("Too many levels of symbolic links")
+
+const (
+	rootInode = 2
+
+	// same as the kernel's MAXSYMLINKS
+	maxSymlinkHops = 40
+)
+
+// ReadLink returns the target of a symlink. Targets shorter than 60 bytes
+// are stored in the inode itself (fast symlinks), longer ones in data
+// blocks like regular file content.
+func (er *Reader) ReadLink(inode Inode) (string, error) {
+	if inode.Mode.FileType() != FileTypeSymlink {
+		return "", fmt.Errorf("Not a symlink")
+	}
+	size := inode.Size()
+	if size < uint64(len(inode.Data)) {
+		return string(inode.Data[:size]), nil
+	}
+	r, err := er.GetInodeReader(inode)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Mount attaches the filesystem read by fs at the absolute path dir of this
+// one, so that lookups and symlinks that reach dir continue on fs, just like
+// they would on the running system with e.g. /var on its own logical volume.
+// Absolute symlinks on fs resolve from the root of this filesystem. Mounts
+// have to be set up before Root or FS are called.
+func (er *Reader) Mount(dir string, fs *Reader) {
+	p := mountPath(dir)
+	if p == "/" {
+		return
+	}
+	if er.mounts == nil {
+		er.mounts = map[string]*Reader{"/": er}
+	}
+	er.mounts[p] = fs
+}
+
+// mountPath formats an absolute directory path like Directory.path does.
+func mountPath(dir string) string {
+	s := splitPath(path.Clean("/" + dir))
+	if len(s) == 0 || s[0] == "" {
+		return "/"
+	}
+	return "/" + strings.Join(s, "/") + "/"
+}
+
+// nsRoot returns the root directory that absolute paths resolve from.
+func (er Reader) nsRoot() (Directory, error) {
+	if root, ok := er.mounts["/"]; ok {
+		return root.Root()
+	}
+	return er.Root()
+}
+
+// subdir returns the directory for the entry e of d, crossing into a mounted
+// filesystem if there is one at that path.
+func (d Directory) subdir(e DirEntry, inode Inode) Directory {
+	p := d.path + e.Name.String() + "/"
+	if m, ok := d.r.mounts[p]; ok {
+		r := *m
+		r.mounts = d.r.mounts
+		root, err := r.GetInode(rootInode)
+		if err == nil {
+			return Directory{r: r, inode: root, ino: rootInode, path: p}
+		}
+	}
+	return Directory{r: d.r, inode: inode, ino: e.Inode, path: p}
+}
+
+// entry returns a DirEntry describing d itself, named after the last
+// element of its path.
+func (d Directory) entry() DirEntry {
+	p := strings.TrimSuffix(d.path, "/")
+	parent := Directory{r: d.r, path: "/"}
+	if p == "" {
+		// the root, so that Fullname is "/"
+		return DirEntry{
+			DirEntryHeader: DirEntryHeader{Inode: d.ino, FileType: FileTypeDir},
+			d:              &parent,
+		}
+	}
+	if dir := path.Dir(p); dir != "/" {
+		parent.path = dir + "/"
+	}
+	return DirEntry{
+		DirEntryHeader: DirEntryHeader{Inode: d.ino, FileType: FileTypeDir},
+		Name:           charArray(path.Base(p)),
+		d:              &parent,
+	}
+}
+
+// Lookup resolves name relative to the directory d, or relative to the root
+// if name is absolute. Symlinks are followed in all but the last component,
+// and in the last one too if follow is set; more than 40 of them result in
+// ErrTooManySymlinks. ".." is resolved through the directory entries, so that
+// it behaves as it would on the mounted filesystem.
+//
+// The returned entry belongs to the filesystem holding the inode, which is
+// not d's if the path crosses a mount point. For directories, the entry is
+// named after the directory and Fullname gives its path without symlinks.
+func (d Directory) Lookup(name string, follow bool) (DirEntry, Inode, error) {
+	hops := 0
+	dir, e, inode, err := d.lookupHops(name, follow, &hops)
+	if err != nil {
+		return DirEntry{}, Inode{}, err
+	}
+	if e == nil {
+		return dir.entry(), dir.inode, nil
+	}
+	return *e, inode, nil
+}
+
+// lookupHops returns the directory the walk ended in and, if the last
+// component was not a directory, its entry and inode.
+func (d Directory) lookupHops(name string, follow bool, hops *int) (Directory, *DirEntry, Inode, error) {
+	if strings.HasPrefix(name, "/") {
+		root, err := d.r.nsRoot()
+		if err != nil {
+			return Directory{}, nil, Inode{}, err
+		}
+		d = root
+	}
+
+	components := splitPath(name)
+	for len(components) > 0 {
+		c := components[0]
+		components = components[1:]
+		if c == "" || c == "." {
+			continue
+		}
+
+		if c == ".." && d.ino == rootInode {
+			// the root of a mounted filesystem, go up in the parent;
+			// the path has no symlinks in it by construction
+			if d.path != "/" {
+				parent, _, _, err := d.lookupHops(path.Dir(strings.TrimSuffix(d.path, "/")), false, hops)
+				if err != nil {
+					return Directory{}, nil, Inode{}, err
+				}
+				d = parent
+			}
+			continue
+		}
+
+		e, err := d.findEntry(c)
+		if err != nil {
+			return Directory{}, nil, Inode{}, err
+		}
+		child, err := d.r.GetInode(e.Inode)
+		if err != nil {
+			return Directory{}, nil, Inode{}, err
+		}
+
+		switch child.Mode.FileType() {
+		case FileTypeDir:
+			if c == ".." {
+				// walk back up by name, to keep the path right
+				p := path.Dir(strings.TrimSuffix(d.path, "/"))
+				if p != "/" {
+					p += "/"
+				}
+				d = Directory{r: d.r, inode: child, ino: e.Inode, path: p}
+				continue
+			}
+			d = d.subdir(e, child)
+			continue
+
+		case FileTypeSymlink:
+			if len(components) == 0 && !follow {
+				break
+			}
+			*hops++
+			if *hops > maxSymlinkHops {
+				return Directory{}, nil, Inode{}, ErrTooManySymlinks
+			}
+			target, err := d.r.ReadLink(child)
+			if err != nil {
+				return Directory{}, nil, Inode{}, err
+			}
+			if target == "" {
+				return Directory{}, nil, Inode{}, ErrNotFound
+			}
+			// continue from the directory holding the link, or from the
+			// root for absolute links
+			if strings.HasPrefix(target, "/") {
+				if d, err = d.r.nsRoot(); err != nil {
+					return Directory{}, nil, Inode{}, err
+				}
+			}
+			components = append(splitPath(target), components...)
+			continue
+		}
+
+		if len(components) > 0 {
+			return Directory{}, nil, Inode{}, ErrNotDir
+		}
+		if e.FileType == FileTypeUnknown {
+			// no filetype feature
+			e.FileType = child.Mode.FileType()
+		}
+		return d, &e, child, nil
+	}
+	return d, nil, d.inode, nil
+}
@@ -1,64 +1,130 @@
 package ext4
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"sync"
 )
 
 var ErrNotExt4 = fmt.Errorf("This does not seem to be an ext2/3/4 partition!")
 
+var ErrSuperBlockChecksum = fmt.Errorf("Superblock checksum does not match")
+
+const superBlockSize = 1024
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
 func NewReader(s io.ReadSeeker, startBlock, blockCount uint32) (r Reader, err error) {
 	r = Reader{
 		s:     s,
-		start: int64(startBlock * 512),
-		size:  int64(blockCount * 512),
+		start: int64(startBlock) * 512,
+		size:  int64(blockCount) * 512,
 	}
 
-	_, err = s.Seek(r.start+1024, 0)
+	r.super, err = readSuperBlock(s, r.start+1024)
 	if err != nil {
 		return
 	}
-	err = binary.Read(s, binary.LittleEndian, &r.super)
-	if err != nil {
-		return
-	}
-
-	if r.super.Magic != 0xEF53 {
-		err = ErrNotExt4
-		return
-	}
+	err = r.init()
+	return
+}
 
+func (r *Reader) init() error {
+	r.mu = &sync.Mutex{}
 	unsupported := r.super.FeatureIncompat &
 		^(FeatureIncompatFlagFiletype |
 			FeatureIncompatFlagExtents |
 			FeatureIncompatFlag64Bit |
 			FeatureIncompatFlagFlexBG |
+			FeatureIncompatFlagMetaBG |
+			FeatureIncompatFlagEAInode |
 			FeatureIncompatFlagRecover)
 
 	if unsupported > 0 {
-		err = fmt.Errorf("Unsupported features: %s\n", unsupported)
-		return
+		return fmt.Errorf("Unsupported features: %s", unsupported)
 	}
 
 	// fixup for non-64 bit
-	if r.super.FeatureIncompat|FeatureIncompatFlag64Bit == 0 {
+	if r.super.FeatureIncompat&FeatureIncompatFlag64Bit == 0 {
 		r.super.BlocksCountHi = 0
+		r.super.RBlocksCountHi = 0
+		r.super.FreeBlocksCountHi = 0
 	}
 
-	fmt.Printf("%+v\n", r.super)
+	return nil
+}
 
+// readSuperBlock reads and validates the superblock at the given byte offset.
+func readSuperBlock(s io.ReadSeeker, offset int64) (sb SuperBlock, err error) {
+	if _, err = s.Seek(offset, 0); err != nil {
+		return
+	}
+	raw := make([]byte, superBlockSize)
+	if _, err = io.ReadFull(s, raw); err != nil {
+		return
+	}
+	if err = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &sb); err != nil {
+		return
+	}
+
+	if sb.Magic != 0xEF53 {
+		err = ErrNotExt4
+		return
+	}
+	if sb.FeatureROCompat&FeatureROCompatFlagMetadataCsum > 0 &&
+		sb.Checksum != ^crc32.Checksum(raw[:superBlockSize-4], crc32c) {
+		err = ErrSuperBlockChecksum
+	}
 	return
 }
 
+// SuperBlock returns the decoded superblock of the filesystem.
+func (r Reader) SuperBlock() SuperBlock {
+	return r.super
+}
+
+// DiskMapper is implemented by sources that are not the disk itself, like
+// LVM logical volumes, to tell where byte offset off of the source is on
+// the disk.
+type DiskMapper interface {
+	DiskOffset(off int64) int64
+}
+
+// DiskOffset returns where block blockNo of the filesystem is on the disk,
+// in bytes, mapped through the source if it is a DiskMapper.
+func (r Reader) DiskOffset(blockNo int64) int64 {
+	if m, ok := r.s.(DiskMapper); ok {
+		return m.DiskOffset(r.blockOffset(blockNo))
+	}
+	return r.blockOffset(blockNo)
+}
+
+// readAt reads len(b) bytes at byte offset off of the disk. As all reads go
+// through the one offset of s, the seek and the read are done under a lock,
+// so that concurrent ReadAt calls on files do not move it under each other.
+func (r Reader) readAt(b []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.s.Seek(off, 0); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.s, b)
+}
+
 func (r Reader) blockOffset(blockNo int64) int64 {
 	//fmt.Printf("[[ ?? block %d ?? ]]\n", blockNo)
 	return r.start + blockNo*r.super.blockSize()
 }
 
 type Reader struct {
-	s     io.ReadSeeker
-	start int64
-	size  int64
-	super SuperBlock
+	s       io.ReadSeeker
+	start   int64
+	size    int64
+	super   SuperBlock
+	sbGroup uint32             // block group of the superblock (and group descriptor table) in use
+	mu      *sync.Mutex        // serializes seeking and reading s in readAt, shared by copies of the Reader
+	mounts  map[string]*Reader // filesystems mounted below this one, by path, see Mount
 }
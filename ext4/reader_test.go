@@ -0,0 +1,103 @@
+package ext4
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readImage returns the contents of testdata/<name>.img.gz, which
+// testdata/mkimages.sh builds.
+func readImage(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name+".img.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func openImage(t *testing.T, b []byte) Reader {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(b), 0, uint32(len(b)/512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// messages is the content of /var/log/messages in the test images.
+func messages() []byte {
+	var b bytes.Buffer
+	for i := 1; i <= 3000; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.Bytes()
+}
+
+func TestBigalloc1k(t *testing.T) {
+	r := openImage(t, readImage(t, "bigalloc1k"))
+	sb := r.SuperBlock()
+	if sb.FirstDataBlock != 0 || sb.blockSize() != 1024 || sb.ClusterRatio() != 16 {
+		t.Fatalf("FirstDataBlock %d, block size %d, cluster ratio %d; the image is not what the test expects",
+			sb.FirstDataBlock, sb.blockSize(), sb.ClusterRatio())
+	}
+
+	u, err := r.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Blocks != 8192 || u.FreeBlocks == 0 || u.FreeBlocks >= u.Blocks || u.FreeInodes >= u.Inodes {
+		t.Errorf("Usage() = %+v", u)
+	}
+
+	b, err := fs.ReadFile(r.FS(), "var/log/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, messages()) {
+		t.Errorf("var/log/messages has %d bytes that differ from what was written", len(b))
+	}
+}
+
+func TestMetaBg(t *testing.T) {
+	r := openImage(t, readImage(t, "metabg"))
+	sb := r.SuperBlock()
+	descPerBlock := uint32(sb.blockSize()) / sb.gdSize()
+	if sb.FeatureIncompat&FeatureIncompatFlagMetaBG == 0 || sb.FirstMetaBg != 0 || sb.GroupCount() <= descPerBlock {
+		t.Fatalf("meta_bg %v, first meta group %d, %d groups; the image is not what the test expects",
+			sb.FeatureIncompat&FeatureIncompatFlagMetaBG != 0, sb.FirstMetaBg, sb.GroupCount())
+	}
+
+	// the descriptors of all meta groups add up to what the superblock says
+	u, err := r.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.FreeBlocks != sb.FreeBlocksCount() || u.FreeInodes != uint64(sb.FreeInodesCount) {
+		t.Errorf("Usage() = %+v, the superblock has %d free blocks and %d free inodes",
+			u, sb.FreeBlocksCount(), sb.FreeInodesCount)
+	}
+
+	b, err := fs.ReadFile(r.FS(), "var/log/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, messages()) {
+		t.Errorf("var/log/messages has %d bytes that differ from what was written", len(b))
+	}
+}
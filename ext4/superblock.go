@@ -1,7 +1,9 @@
 package ext4
 
 import (
+	"bytes"
 	"fmt"
+	"time"
 )
 
 func (s SuperBlock) gdSize() uint32 {
@@ -18,29 +20,45 @@ func (s SuperBlock) blockSize() int64 {
 	return 1 << (10 + s.LogBlockSize)
 }
 
-type SuperBlock struct {
-	InodesCount   uint32 // Total inode count.
-	BlocksCountLo uint32 // Total block count.
-
-	_ [16]byte
-
-	LogBlockSize     uint32 // Block size is 2 ^ (10 + s_log_block_size).
-	LogClusterSize   uint32 // Cluster size is (2 ^ s_log_cluster_size) blocks if bigalloc is enabled, zero otherwise.
-	BlocksPerGroup   uint32 // Blocks per group.
-	ClustersPerGroup uint32 // Clusters per group, if bigalloc is enabled.
-	InodesPerGroup   uint32 // Inodes per group.
-
-	_ [12]byte
-
-	Magic uint16       // Magic signature, 0xEF53
-	State FSStateFlags // File system state. Valid values are
-
-	_ [16]byte
-
-	RevisionLevel uint32 // Revision level. One of: 0 Original format, 1 v2 format w/ dynamic inode sizes
-
-	_ [8]byte
+// ClusterRatio returns the number of blocks per cluster, the unit in which
+// block bitmaps and group free counts are kept. This is 1 unless the
+// bigalloc feature is enabled.
+func (s SuperBlock) ClusterRatio() int64 {
+	if s.FeatureROCompat&FeatureROCompatFlagBigalloc == 0 || s.LogClusterSize < s.LogBlockSize {
+		return 1
+	}
+	return 1 << (s.LogClusterSize - s.LogBlockSize)
+}
 
+type SuperBlock struct {
+	InodesCount       uint32 // Total inode count.
+	BlocksCountLo     uint32 // Total block count.
+	RBlocksCountLo    uint32 // This number of blocks can only be allocated by the super-user.
+	FreeBlocksCountLo uint32 // Free block count.
+	FreeInodesCount   uint32 // Free inode count.
+	FirstDataBlock    uint32 // First data block. This must be at least 1 for 1k-block filesystems and is typically 0 for all other block sizes.
+	LogBlockSize      uint32 // Block size is 2 ^ (10 + s_log_block_size).
+	LogClusterSize    uint32 // Cluster size is (2 ^ s_log_cluster_size) blocks if bigalloc is enabled, zero otherwise.
+	BlocksPerGroup    uint32 // Blocks per group.
+	ClustersPerGroup  uint32 // Clusters per group, if bigalloc is enabled.
+	InodesPerGroup    uint32 // Inodes per group.
+	MountTimeLo       uint32 // Mount time, in seconds since the epoch.
+	WriteTimeLo       uint32 // Write time, in seconds since the epoch.
+	MountCount        uint16 // Number of mounts since the last fsck.
+	MaxMountCount     int16  // Number of mounts beyond which a fsck is needed.
+
+	Magic         uint16         // Magic signature, 0xEF53
+	State         FSStateFlags   // File system state. Valid values are
+	Errors        ErrorsBehavior // Behaviour when detecting errors.
+	MinorRevLevel uint16         // Minor revision level.
+	LastCheckLo   uint32         // Time of last check, in seconds since the epoch.
+	CheckInterval uint32         // Maximum time between checks, in seconds.
+	CreatorOS     CreatorOS      // OS that created the filesystem.
+	RevisionLevel uint32         // Revision level. One of: 0 Original format, 1 v2 format w/ dynamic inode sizes
+	DefResUid     uint16         // Default uid for reserved blocks.
+	DefResGid     uint16         // Default gid for reserved blocks.
+
+	FirstIno        uint32               // First non-reserved inode.
 	InodeSize       uint16               // Size of inode structure, in bytes.
 	BlockGroupNr    uint16               // Block group # of this superblock.
 	FeatureCompat   FeatureCompatFlags   // Compatible feature set flags. Kernel can still read/write this fs even if it doesn't understand a flag; fsck should not do that. Any of:
@@ -48,17 +66,82 @@ type SuperBlock struct {
 	FeatureROCompat FeatureROCompatFlags // Readonly-compatible feature set. If the kernel doesn't understand one of these bits, it can still mount read-only. Any of:
 	UUID            UUID                 // 128-bit UUID for volume.
 	VolumeName      [16]byte             // Volume label.
-
-	_ [118]byte
-
-	DescSize uint16 // Size of group descriptors, in bytes, if the 64bit incompat feature flag is set.
-
-	_ [80]byte
+	LastMounted     [64]byte             // Directory where filesystem was last mounted.
+	AlgorithmBitmap uint32               // For compression (Not used in e2fsprogs/Linux)
+
+	PreallocBlocks    byte   // Number of blocks to try to preallocate for files. (Not used in e2fsprogs/Linux)
+	PreallocDirBlocks byte   // Number of blocks to preallocate for directories. (Not used in e2fsprogs/Linux)
+	ReservedGdtBlocks uint16 // Number of reserved GDT entries for future filesystem expansion.
+
+	JournalUUID     UUID        // UUID of journal superblock
+	JournalInum     uint32      // Inode number of journal file.
+	JournalDev      uint32      // Device number of journal file, if the external journal feature flag is set.
+	LastOrphan      uint32      // Start of list of orphaned inodes to delete.
+	HashSeed        [4]uint32   // HTREE hash seed.
+	DefHashVersion  HashVersion // Default hash algorithm to use for directory hashes.
+	JnlBackupType   byte        // If this value is 0 or EXT3_JNL_BACKUP_BLOCKS (1), then the s_jnl_blocks field contains a duplicate copy of the inode's i_block[] array and i_size.
+	DescSize        uint16      // Size of group descriptors, in bytes, if the 64bit incompat feature flag is set.
+	DefaultMountOpt uint32      // Default mount options.
+	FirstMetaBg     uint32      // First metablock block group, if the meta_bg feature is enabled.
+	MkfsTimeLo      uint32      // When the filesystem was created, in seconds since the epoch.
+	JnlBlocks       [17]uint32  // Backup copy of the journal inode's i_block[] array in the first 15 elements and i_size_high and i_size in the 16th and 17th elements, respectively.
 
 	//64bit support valid if EXT4_FEATURE_COMPAT_64BIT
-	BlocksCountHi uint32 // High 32-bits of the block count.
-
-	_ [684]byte
+	BlocksCountHi     uint32    // High 32-bits of the block count.
+	RBlocksCountHi    uint32    // High 32-bits of the reserved block count.
+	FreeBlocksCountHi uint32    // High 32-bits of the free block count.
+	MinExtraIsize     uint16    // All inodes have at least # bytes.
+	WantExtraIsize    uint16    // New inodes should reserve # bytes.
+	Flags             uint32    // Miscellaneous flags. Any of: 0x1 Signed directory hash in use, 0x2 Unsigned directory hash in use, 0x4 To test development code.
+	RaidStride        uint16    // RAID stride. This is the number of logical blocks read from or written to the disk before moving to the next disk.
+	MmpInterval       uint16    // Number of seconds to wait in multi-mount prevention (MMP) checking.
+	MmpBlock          uint64    // Block # for multi-mount protection data.
+	RaidStripeWidth   uint32    // RAID stripe width. This is the number of logical blocks read from or written to the disk before coming back to the current disk.
+	LogGroupsPerFlex  byte      // Size of a flexible block group is 2 ^ s_log_groups_per_flex.
+	ChecksumType      byte      // Metadata checksum algorithm type. The only valid value is 1 (crc32c).
+	EncryptionLevel   byte      // Versioning level for encryption.
+	_                 byte      // Padding to next 32bits.
+	KbytesWritten     uint64    // Number of KiB written to this filesystem over its lifetime.
+	SnapshotInum      uint32    // Inode number of active snapshot. (Not used in e2fsprogs/Linux.)
+	SnapshotID        uint32    // Sequential ID of active snapshot. (Not used in e2fsprogs/Linux.)
+	SnapshotRBlocks   uint64    // Number of blocks reserved for active snapshot's future use. (Not used in e2fsprogs/Linux.)
+	SnapshotList      uint32    // Inode number of the head of the on-disk snapshot list. (Not used in e2fsprogs/Linux.)
+	ErrorCount        uint32    // Number of errors seen.
+	FirstErrorTimeLo  uint32    // First time an error happened, in seconds since the epoch.
+	FirstErrorIno     uint32    // Inode involved in first error.
+	FirstErrorBlock   uint64    // Number of block involved of first error.
+	FirstErrorFunc    [32]byte  // Name of function where the error happened.
+	FirstErrorLine    uint32    // Line number where error happened.
+	LastErrorTimeLo   uint32    // Time of most recent error, in seconds since the epoch.
+	LastErrorIno      uint32    // Inode involved in most recent error.
+	LastErrorLine     uint32    // Line number where most recent error happened.
+	LastErrorBlock    uint64    // Number of block involved in most recent error.
+	LastErrorFunc     [32]byte  // Name of function where the most recent error happened.
+	MountOpts         [64]byte  // ASCIIZ string of mount options.
+	UsrQuotaInum      uint32    // Inode number of user quota file.
+	GrpQuotaInum      uint32    // Inode number of group quota file.
+	OverheadBlocks    uint32    // Overhead blocks/clusters in fs. (Huh? This field is always zero, which means that the kernel calculates it dynamically.)
+	BackupBgs         [2]uint32 // Block groups containing superblock backups (if sparse_super2)
+	EncryptAlgos      [4]byte   // Encryption algorithms in use. There can be up to four algorithms in use at any time.
+	EncryptPwSalt     [16]byte  // Salt for the string2key algorithm for encryption.
+	LpfIno            uint32    // Inode number of lost+found
+	PrjQuotaInum      uint32    // Inode that tracks project quotas.
+	ChecksumSeed      uint32    // Checksum seed used for metadata_csum calculations. This value is crc32c(~0, $orig_fs_uuid).
+	WriteTimeHi       byte      // Upper 8 bits of the s_wtime field.
+	MountTimeHi       byte      // Upper 8 bits of the s_mtime field.
+	MkfsTimeHi        byte      // Upper 8 bits of the s_mkfs_time field.
+	LastCheckHi       byte      // Upper 8 bits of the s_lastcheck_time field.
+	FirstErrorTimeHi  byte      // Upper 8 bits of the s_first_error_time_hi field.
+	LastErrorTimeHi   byte      // Upper 8 bits of the s_last_error_time_hi field.
+	FirstErrorErrcode byte      // Error code of the first error.
+	LastErrorErrcode  byte      // Error code of the most recent error.
+	Encoding          uint16    // Filename charset encoding.
+	EncodingFlags     uint16    // Filename charset encoding flags.
+	OrphanFileInum    uint32    // Orphan file inode number.
+
+	_ [94]uint32
+
+	Checksum uint32 // Superblock checksum.
 }
 
 type FeatureCompatFlags uint32
@@ -190,19 +273,20 @@ func (f FeatureIncompatFlags) String() string {
 type FeatureROCompatFlags uint32
 
 const (
-	FeatureROCompatFlagSparseSuper  FeatureROCompatFlags = 0x1    // Sparse superblocks. See the earlier discussion of this feature (RO_COMPAT_SPARSE_SUPER).
-	FeatureROCompatFlagLargeFile    FeatureROCompatFlags = 0x2    // This filesystem has been used to store a file greater than 2GiB (RO_COMPAT_LARGE_FILE).
-	FeatureROCompatFlagBtreeDir     FeatureROCompatFlags = 0x4    // Not used in kernel or e2fsprogs (RO_COMPAT_BTREE_DIR).
-	FeatureROCompatFlagHugeFile     FeatureROCompatFlags = 0x8    // This filesystem has files whose sizes are represented in units of logical blocks, not 512-byte sectors. This implies a very large file indeed! (RO_COMPAT_HUGE_FILE)
-	FeatureROCompatFlagGDTCsum      FeatureROCompatFlags = 0x10   // Group descriptors have checksums. In addition to detecting corruption, this is useful for lazy formatting with uninitialized groups (RO_COMPAT_GDT_CSUM).
-	FeatureROCompatFlagDirNlink     FeatureROCompatFlags = 0x20   // Indicates that the old ext3 32,000 subdirectory limit no longer applies (RO_COMPAT_DIR_NLINK).
-	FeatureROCompatFlagExtraIsize   FeatureROCompatFlags = 0x40   // Indicates that large inodes exist on this filesystem (RO_COMPAT_EXTRA_ISIZE).
-	FeatureROCompatFlagHasSnapshot  FeatureROCompatFlags = 0x80   // This filesystem has a snapshot (RO_COMPAT_HAS_SNAPSHOT).
-	FeatureROCompatFlagQuota        FeatureROCompatFlags = 0x100  // Quota (RO_COMPAT_QUOTA).
-	FeatureROCompatFlagBigalloc     FeatureROCompatFlags = 0x200  // This filesystem supports "bigalloc", which means that file extents are tracked in units of clusters (of blocks) instead of blocks (RO_COMPAT_BIGALLOC).
-	FeatureROCompatFlagMetadataCsum FeatureROCompatFlags = 0x400  // This filesystem supports metadata checksumming. (RO_COMPAT_METADATA_CSUM; implies RO_COMPAT_GDT_CSUM, though GDT_CSUM must not be set)
-	FeatureROCompatFlagReplica      FeatureROCompatFlags = 0x800  // Filesystem supports replicas. This feature is neither in the kernel nor e2fsprogs. (RO_COMPAT_REPLICA)
-	FeatureROCompatFlagReadonly     FeatureROCompatFlags = 0x1000 // Read-only filesystem image; the kernel will not mount this image read-write and most tools will refuse to write to the image. (RO_COMPAT_READONLY)
+	FeatureROCompatFlagSparseSuper   FeatureROCompatFlags = 0x1     // Sparse superblocks. See the earlier discussion of this feature (RO_COMPAT_SPARSE_SUPER).
+	FeatureROCompatFlagLargeFile     FeatureROCompatFlags = 0x2     // This filesystem has been used to store a file greater than 2GiB (RO_COMPAT_LARGE_FILE).
+	FeatureROCompatFlagBtreeDir      FeatureROCompatFlags = 0x4     // Not used in kernel or e2fsprogs (RO_COMPAT_BTREE_DIR).
+	FeatureROCompatFlagHugeFile      FeatureROCompatFlags = 0x8     // This filesystem has files whose sizes are represented in units of logical blocks, not 512-byte sectors. This implies a very large file indeed! (RO_COMPAT_HUGE_FILE)
+	FeatureROCompatFlagGDTCsum       FeatureROCompatFlags = 0x10    // Group descriptors have checksums. In addition to detecting corruption, this is useful for lazy formatting with uninitialized groups (RO_COMPAT_GDT_CSUM).
+	FeatureROCompatFlagDirNlink      FeatureROCompatFlags = 0x20    // Indicates that the old ext3 32,000 subdirectory limit no longer applies (RO_COMPAT_DIR_NLINK).
+	FeatureROCompatFlagExtraIsize    FeatureROCompatFlags = 0x40    // Indicates that large inodes exist on this filesystem (RO_COMPAT_EXTRA_ISIZE).
+	FeatureROCompatFlagHasSnapshot   FeatureROCompatFlags = 0x80    // This filesystem has a snapshot (RO_COMPAT_HAS_SNAPSHOT).
+	FeatureROCompatFlagQuota         FeatureROCompatFlags = 0x100   // Quota (RO_COMPAT_QUOTA).
+	FeatureROCompatFlagBigalloc      FeatureROCompatFlags = 0x200   // This filesystem supports "bigalloc", which means that file extents are tracked in units of clusters (of blocks) instead of blocks (RO_COMPAT_BIGALLOC).
+	FeatureROCompatFlagMetadataCsum  FeatureROCompatFlags = 0x400   // This filesystem supports metadata checksumming. (RO_COMPAT_METADATA_CSUM; implies RO_COMPAT_GDT_CSUM, though GDT_CSUM must not be set)
+	FeatureROCompatFlagReplica       FeatureROCompatFlags = 0x800   // Filesystem supports replicas. This feature is neither in the kernel nor e2fsprogs. (RO_COMPAT_REPLICA)
+	FeatureROCompatFlagReadonly      FeatureROCompatFlags = 0x1000  // Read-only filesystem image; the kernel will not mount this image read-write and most tools will refuse to write to the image. (RO_COMPAT_READONLY)
+	FeatureROCompatFlagOrphanPresent FeatureROCompatFlags = 0x10000 // The orphan file may have entries; set while mounted, cleared on clean unmount (RO_COMPAT_ORPHAN_PRESENT).
 )
 
 func (f FeatureROCompatFlags) String() string {
@@ -246,6 +330,9 @@ func (f FeatureROCompatFlags) String() string {
 	if f&FeatureROCompatFlagReadonly > 0 {
 		flags += "Readonly|"
 	}
+	if f&FeatureROCompatFlagOrphanPresent > 0 {
+		flags += "OrphanPresent|"
+	}
 	if flags != "" {
 		flags = flags[:len(flags)-1]
 	}
@@ -260,31 +347,277 @@ const (
 	FSStateFlagOrphans                          // Orphans being recovered
 )
 
+func (f FSStateFlags) String() string {
+	flags := ""
+	if f&FSStateFlagClean > 0 {
+		flags += "Clean|"
+	}
+	if f&FSStateFlagError > 0 {
+		flags += "Error|"
+	}
+	if f&FSStateFlagOrphans > 0 {
+		flags += "Orphans|"
+	}
+	if flags != "" {
+		flags = flags[:len(flags)-1]
+	}
+	return fmt.Sprintf("%s(0x%04x)", flags, uint16(f))
+}
+
+type ErrorsBehavior uint16
+
+const (
+	ErrorsContinue ErrorsBehavior = 1 // Continue
+	ErrorsRemount  ErrorsBehavior = 2 // Remount read-only
+	ErrorsPanic    ErrorsBehavior = 3 // Panic
+)
+
+func (e ErrorsBehavior) String() string {
+	switch e {
+	case ErrorsContinue:
+		return "Continue"
+	case ErrorsRemount:
+		return "RemountRO"
+	case ErrorsPanic:
+		return "Panic"
+	default:
+		return fmt.Sprintf("ErrorsBehavior(%d)", uint16(e))
+	}
+}
+
+type CreatorOS uint32
+
+const (
+	CreatorOSLinux   CreatorOS = 0 // Linux
+	CreatorOSHurd    CreatorOS = 1 // Hurd
+	CreatorOSMasix   CreatorOS = 2 // Masix
+	CreatorOSFreeBSD CreatorOS = 3 // FreeBSD
+	CreatorOSLites   CreatorOS = 4 // Lites
+)
+
+func (o CreatorOS) String() string {
+	switch o {
+	case CreatorOSLinux:
+		return "Linux"
+	case CreatorOSHurd:
+		return "Hurd"
+	case CreatorOSMasix:
+		return "Masix"
+	case CreatorOSFreeBSD:
+		return "FreeBSD"
+	case CreatorOSLites:
+		return "Lites"
+	default:
+		return fmt.Sprintf("CreatorOS(%d)", uint32(o))
+	}
+}
+
+type HashVersion byte
+
+const (
+	HashVersionLegacy          HashVersion = 0x0 // Legacy.
+	HashVersionHalfMD4         HashVersion = 0x1 // Half MD4.
+	HashVersionTea             HashVersion = 0x2 // Tea.
+	HashVersionLegacyUnsigned  HashVersion = 0x3 // Legacy, unsigned.
+	HashVersionHalfMD4Unsigned HashVersion = 0x4 // Half MD4, unsigned.
+	HashVersionTeaUnsigned     HashVersion = 0x5 // Tea, unsigned.
+	HashVersionSiphash         HashVersion = 0x6 // Siphash.
+)
+
+func (h HashVersion) String() string {
+	switch h {
+	case HashVersionLegacy:
+		return "Legacy"
+	case HashVersionHalfMD4:
+		return "HalfMD4"
+	case HashVersionTea:
+		return "Tea"
+	case HashVersionLegacyUnsigned:
+		return "LegacyUnsigned"
+	case HashVersionHalfMD4Unsigned:
+		return "HalfMD4Unsigned"
+	case HashVersionTeaUnsigned:
+		return "TeaUnsigned"
+	case HashVersionSiphash:
+		return "Siphash"
+	default:
+		return fmt.Sprintf("HashVersion(0x%x)", byte(h))
+	}
+}
+
 func (s SuperBlock) BlocksCount() uint64 {
 	return uint64(s.BlocksCountLo) + uint64(s.BlocksCountHi)<<32
 }
 
+func (s SuperBlock) FreeBlocksCount() uint64 {
+	return uint64(s.FreeBlocksCountLo) + uint64(s.FreeBlocksCountHi)<<32
+}
+
+func (s SuperBlock) ReservedBlocksCount() uint64 {
+	return uint64(s.RBlocksCountLo) + uint64(s.RBlocksCountHi)<<32
+}
+
+// superblock timestamps are extended past 2038 with an extra high byte
+func sbTime(lo uint32, hi byte) time.Time {
+	if lo == 0 && hi == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(lo)+int64(hi)<<32, 0).UTC()
+}
+
+func (s SuperBlock) MountTime() time.Time      { return sbTime(s.MountTimeLo, s.MountTimeHi) }
+func (s SuperBlock) WriteTime() time.Time      { return sbTime(s.WriteTimeLo, s.WriteTimeHi) }
+func (s SuperBlock) LastCheck() time.Time      { return sbTime(s.LastCheckLo, s.LastCheckHi) }
+func (s SuperBlock) MkfsTime() time.Time       { return sbTime(s.MkfsTimeLo, s.MkfsTimeHi) }
+func (s SuperBlock) FirstErrorTime() time.Time { return sbTime(s.FirstErrorTimeLo, s.FirstErrorTimeHi) }
+func (s SuperBlock) LastErrorTime() time.Time  { return sbTime(s.LastErrorTimeLo, s.LastErrorTimeHi) }
+
+func (s SuperBlock) Label() string {
+	return cstring(s.VolumeName[:])
+}
+
+func (s SuperBlock) LastMountedDir() string {
+	return cstring(s.LastMounted[:])
+}
+
+func (s SuperBlock) FlexBGSize() uint32 {
+	if s.FeatureIncompat&FeatureIncompatFlagFlexBG == 0 {
+		return 0
+	}
+	return 1 << s.LogGroupsPerFlex
+}
+
+func (s SuperBlock) ChecksumTypeName() string {
+	switch s.ChecksumType {
+	case 0:
+		return "None"
+	case 1:
+		return "crc32c"
+	default:
+		return fmt.Sprintf("ChecksumType(%d)", s.ChecksumType)
+	}
+}
+
+func (s SuperBlock) EncodingName() string {
+	switch s.Encoding {
+	case 0:
+		return "None"
+	case 1:
+		return "utf8-12.1"
+	default:
+		return fmt.Sprintf("Encoding(%d)", s.Encoding)
+	}
+}
+
+// FirstError describes the first error recorded in the superblock, or
+// returns "" if no error was recorded.
+func (s SuperBlock) FirstError() string {
+	if s.ErrorCount == 0 || s.FirstErrorTimeLo == 0 {
+		return ""
+	}
+	return describeError(s.FirstErrorTime(), s.FirstErrorFunc[:], s.FirstErrorLine, s.FirstErrorIno, s.FirstErrorBlock, s.FirstErrorErrcode)
+}
+
+// LastError describes the most recent error recorded in the superblock,
+// or returns "" if no error was recorded.
+func (s SuperBlock) LastError() string {
+	if s.ErrorCount == 0 || s.LastErrorTimeLo == 0 {
+		return ""
+	}
+	return describeError(s.LastErrorTime(), s.LastErrorFunc[:], s.LastErrorLine, s.LastErrorIno, s.LastErrorBlock, s.LastErrorErrcode)
+}
+
+func describeError(t time.Time, function []byte, line, ino uint32, block uint64, errcode byte) string {
+	rv := fmt.Sprintf("%s at %s:%d", formatTime(t), cstring(function), line)
+	if ino != 0 {
+		rv += fmt.Sprintf(" inode %d", ino)
+	}
+	if block != 0 {
+		rv += fmt.Sprintf(" block %d", block)
+	}
+	if errcode != 0 {
+		rv += fmt.Sprintf(" errcode %d", errcode)
+	}
+	return rv
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "n/a"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
 func (s SuperBlock) String() string {
-	rv := fmt.Sprintf("Volume name:     %v\n", string(s.VolumeName[:]))
+	rv := fmt.Sprintf("Volume name:     %v\n", s.Label())
+	rv += fmt.Sprintf("UUID:            %v\n", s.UUID)
+	rv += fmt.Sprintf("Last mounted on: %v\n", s.LastMountedDir())
+	rv += fmt.Sprintf("Creator OS:      %v\n", s.CreatorOS)
+	rv += fmt.Sprintf("Revision:        %v.%v\n", s.RevisionLevel, s.MinorRevLevel)
 
 	rv += fmt.Sprintf("Inode count:     %v\n", s.InodesCount)
+	rv += fmt.Sprintf("Free inodes:     %v\n", s.FreeInodesCount)
 	rv += fmt.Sprintf("Block count:     %v\n", s.BlocksCount())
+	rv += fmt.Sprintf("Reserved blocks: %v\n", s.ReservedBlocksCount())
+	rv += fmt.Sprintf("Free blocks:     %v\n", s.FreeBlocksCount())
 
 	rv += fmt.Sprintf("Block size:      %v B\n", s.blockSize())
-	rv += fmt.Sprintf("Cluster size:    %v blocks\n", 1<<s.LogClusterSize)
+	rv += fmt.Sprintf("Cluster size:    %v blocks\n", s.ClusterRatio())
+	rv += fmt.Sprintf("Inode size:      %v B\n", s.InodeSize)
 
 	rv += fmt.Sprintf("Partition size:  %.1f MiB\n", float64(s.blockSize())*float64(s.BlocksCount())/1024/1024)
 
 	rv += fmt.Sprintf("Blocks/group:    %v\n", s.BlocksPerGroup)
 	rv += fmt.Sprintf("Clusters/group:  %v\n", s.ClustersPerGroup)
 	rv += fmt.Sprintf("Inode/group:     %v\n", s.InodesPerGroup)
+	rv += fmt.Sprintf("Flex BG size:    %v\n", s.FlexBGSize())
+	if s.BackupBgs != [2]uint32{} {
+		rv += fmt.Sprintf("Backup groups:   %v, %v\n", s.BackupBgs[0], s.BackupBgs[1])
+	}
 
 	rv += fmt.Sprintf("Magic:           %x\n", s.Magic)
 	rv += fmt.Sprintf("State:           %v\n", s.State)
+	rv += fmt.Sprintf("Errors behavior: %v\n", s.Errors)
+
+	rv += fmt.Sprintf("Created:         %v\n", formatTime(s.MkfsTime()))
+	rv += fmt.Sprintf("Last mount:      %v\n", formatTime(s.MountTime()))
+	rv += fmt.Sprintf("Last write:      %v\n", formatTime(s.WriteTime()))
+	rv += fmt.Sprintf("Last check:      %v\n", formatTime(s.LastCheck()))
+	rv += fmt.Sprintf("Mount count:     %v (max %v)\n", s.MountCount, s.MaxMountCount)
+	rv += fmt.Sprintf("Lifetime writes: %v KiB\n", s.KbytesWritten)
 
 	rv += fmt.Sprintf("FeatureCompat:   %v\n", s.FeatureCompat)
 	rv += fmt.Sprintf("FeatureIncompat: %v\n", s.FeatureIncompat)
 	rv += fmt.Sprintf("FeatureROCompat: %v\n", s.FeatureROCompat)
 
+	if s.FeatureCompat&FeatureCompatFlagHasJournal > 0 {
+		rv += fmt.Sprintf("Journal UUID:    %v\n", s.JournalUUID)
+		rv += fmt.Sprintf("Journal inode:   %v\n", s.JournalInum)
+	}
+	rv += fmt.Sprintf("Hash seed:       %08x-%08x-%08x-%08x\n", s.HashSeed[0], s.HashSeed[1], s.HashSeed[2], s.HashSeed[3])
+	rv += fmt.Sprintf("Default hash:    %v\n", s.DefHashVersion)
+	if s.FeatureROCompat&FeatureROCompatFlagMetadataCsum > 0 {
+		rv += fmt.Sprintf("Checksum type:   %v\n", s.ChecksumTypeName())
+		rv += fmt.Sprintf("Checksum seed:   0x%08x\n", s.ChecksumSeed)
+	}
+	if s.Encoding != 0 {
+		rv += fmt.Sprintf("Encoding:        %v (flags 0x%04x)\n", s.EncodingName(), s.EncodingFlags)
+	}
+
+	rv += fmt.Sprintf("Error count:     %v\n", s.ErrorCount)
+	if e := s.FirstError(); e != "" {
+		rv += fmt.Sprintf("First error:     %v\n", e)
+	}
+	if e := s.LastError(); e != "" {
+		rv += fmt.Sprintf("Last error:      %v\n", e)
+	}
+
 	return rv
 }
@@ -0,0 +1,46 @@
+#!/bin/sh
+# Builds the filesystem images the tests read. They are kept gzipped; the
+# tests unpack them in memory. Needs mke2fs 1.45 or later, python3, and a
+# filesystem with ACLs for the temporary directory.
+set -e
+cd "$(dirname "$0")"
+tree=$(mktemp -d)
+trap 'rm -rf "$tree"' EXIT
+
+mkdir -p "$tree/etc/ssh" "$tree/var/log/empty" "$tree/home/user/.ssh"
+printf 'NAME="Test Linux"\nID=test\n' >"$tree/etc/os-release"
+printf 'UUID=0 / ext4 defaults 0 1\n' >"$tree/etc/fstab"
+seq 1 3000 >"$tree/var/log/messages"
+ln -s ../var/log/messages "$tree/etc/messages"
+ln -s /etc/os-release "$tree/os-release"
+truncate -s 1M "$tree/var/log/sparse"
+printf 'tail\n' >>"$tree/var/log/sparse"
+printf 'ssh-ed25519 AAAA user\n' >"$tree/home/user/.ssh/authorized_keys"
+python3 - "$tree" <<'EOF'
+import os, struct, sys
+def acl(*entries):
+    return struct.pack('<I', 2) + b''.join(struct.pack('<HHI', *e) for e in entries)
+ANY = 0xffffffff
+root = sys.argv[1]
+# setfacl -m u:1000:rw,g:1000:r etc/fstab
+os.setxattr(root + '/etc/fstab', 'system.posix_acl_access',
+            acl((0x01, 6, ANY), (0x02, 6, 1000), (0x04, 4, ANY), (0x08, 4, 1000), (0x10, 6, ANY), (0x20, 4, ANY)))
+# setfacl -d -m u:1000:rwx var/log
+os.setxattr(root + '/var/log', 'system.posix_acl_default',
+            acl((0x01, 7, ANY), (0x02, 7, 1000), (0x04, 5, ANY), (0x10, 7, ANY), (0x20, 5, ANY)))
+os.setxattr(root + '/etc/os-release', 'user.comment', b'hello')
+# too large for the inode, so it goes to an attribute block
+os.setxattr(root + '/var/log/messages', 'user.big', b'x' * 300)
+EOF
+find "$tree" -exec touch -h -d 2016-01-02T15:04:05Z {} +
+
+# 4k blocks, in 4 groups with backups in groups 1 and 3
+mke2fs -q -F -t ext4 -b 4096 -g 512 -N 256 -U 6b0c2f5e-7c1e-4b7a-9f2d-1d2c3b4a5f60 -L test -d "$tree" ext4.img 8M
+# 1k blocks with bigalloc, where the first data block is 0
+mke2fs -q -F -t ext4 -b 1024 -O bigalloc -C 16384 -N 256 -d "$tree" bigalloc1k.img 8M
+# ext2, with indirect block maps instead of extents and a backup superblock
+# where e2fsck looks for it (-b 8193)
+mke2fs -q -F -t ext2 -b 1024 -N 256 -d "$tree" ext2.img 10M
+# meta_bg, with 32 groups of 16 descriptors per block, so 2 meta groups
+mke2fs -q -F -t ext4 -b 1024 -g 256 -N 256 -O meta_bg,^resize_inode -d "$tree" metabg.img 8M
+gzip -9 -n -f ext4.img bigalloc1k.img ext2.img metabg.img
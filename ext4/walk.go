@@ -0,0 +1,140 @@
+package ext4
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+var ErrDirLoop = fmt.Errorf("Directory already visited, filesystem is corrupt")
+
+// WalkEntry is an entry visited by Walk.
+type WalkEntry struct {
+	Path       string // Full path of the entry.
+	Entry      DirEntry
+	Inode      Inode  // Decoded inode, empty if it could not be read.
+	HardLinkOf string // For an inode with several links, the path it was first visited at, if it was visited before.
+}
+
+// WalkFunc is called by Walk for each entry. If the inode of the entry could
+// not be read, or a directory could not be listed, err is set; returning nil
+// then continues the walk with the next entry.
+//
+// Returning fs.SkipDir for a directory skips its contents, for any other
+// entry it skips the rest of the directory holding it. Returning fs.SkipAll
+// ends the walk without an error, any other error ends it with that error.
+type WalkFunc func(e WalkEntry, err error) error
+
+// inodeKey identifies an inode across mounted filesystems.
+type inodeKey struct {
+	uuid  UUID
+	start int64
+	ino   uint32
+}
+
+type walker struct {
+	fn    WalkFunc
+	links map[inodeKey]string
+	dirs  map[inodeKey]bool
+}
+
+// Walk visits d and everything below it depth-first, in lexical order within
+// each directory and crossing into filesystems mounted with Mount. Unlike
+// fs.WalkDir, directories are entered by inode number rather than looked up
+// by name again, and "." and ".." are never visited.
+func (d Directory) Walk(fn WalkFunc) error {
+	w := walker{
+		fn:    fn,
+		links: map[inodeKey]string{},
+		dirs:  map[inodeKey]bool{},
+	}
+	err := w.walkDir(d, d.entry())
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (d Directory) key(ino uint32) inodeKey {
+	return inodeKey{uuid: d.r.super.UUID, start: d.r.start, ino: ino}
+}
+
+func (w *walker) walkDir(d Directory, self DirEntry) error {
+	we := WalkEntry{
+		Path:  strings.TrimSuffix(d.path, "/"),
+		Entry: self,
+		Inode: d.inode,
+	}
+	if we.Path == "" {
+		we.Path = "/"
+	}
+
+	key := d.key(d.ino)
+	if w.dirs[key] {
+		return w.fn(we, ErrDirLoop)
+	}
+	w.dirs[key] = true
+
+	if err := w.fn(we, nil); err != nil {
+		return err
+	}
+
+	entries, err := d.Entries()
+	if err != nil {
+		if err := w.fn(we, err); err != nil && err != fs.SkipDir {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name.String() < entries[j].Name.String() })
+
+	for _, e := range entries {
+		name := e.Name.String()
+		if name == "." || name == ".." {
+			continue
+		}
+		we := WalkEntry{Path: d.path + name, Entry: e}
+
+		inode, err := d.r.GetInode(e.Inode)
+		if err != nil {
+			if err := w.fn(we, err); err != nil {
+				if err == fs.SkipDir {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		if inode.Mode.FileType() == FileTypeDir {
+			child := d.subdir(e, inode)
+			self := e
+			if child.ino != e.Inode {
+				// another filesystem is mounted here
+				self = child.entry()
+			}
+			if err := w.walkDir(child, self); err != nil && err != fs.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		we.Inode = inode
+		if inode.LinksCount > 1 {
+			key := d.key(e.Inode)
+			if first, ok := w.links[key]; ok {
+				we.HardLinkOf = first
+			} else {
+				w.links[key] = we.Path
+			}
+		}
+		if err := w.fn(we, nil); err != nil {
+			if err == fs.SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
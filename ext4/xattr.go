@@ -0,0 +1,243 @@
+package ext4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const xattrMagic = 0xEA020000
+
+// Xattr is an extended attribute of an inode.
+type Xattr struct {
+	Name  string // Full name, including the namespace prefix (e.g. "security.selinux").
+	Value []byte
+	Inode uint32 // Inode holding the value, if it is too large to be stored inline (ea_inode feature).
+}
+
+// XattrHeader is the header of an extended attribute block.
+type XattrHeader struct {
+	Magic    uint32 // Magic number for identification, 0xEA020000.
+	Refcount uint32 // Reference count.
+	Blocks   uint32 // Number of disk blocks used. Must be 1.
+	Hash     uint32 // Hash value of all attributes.
+	Checksum uint32 // Checksum of the extended attribute block.
+	_        [12]byte
+}
+
+// XattrEntry describes one extended attribute. It is followed by the name,
+// padded to a multiple of 4 bytes.
+type XattrEntry struct {
+	NameLen   byte   // Length of name.
+	NameIndex byte   // Attribute name index, see xattrPrefixes.
+	ValueOffs uint16 // Location of this attribute's value on the disk block where it is stored.
+	ValueInum uint32 // The inode where the value is stored. Zero indicates the value is in the same block as this entry.
+	ValueSize uint32 // Length of attribute value.
+	Hash      uint32 // Hash value of attribute name and attribute value.
+}
+
+// name prefixes by attribute name index
+var xattrPrefixes = map[byte]string{
+	1: "user.",
+	2: "system.posix_acl_access",
+	3: "system.posix_acl_default",
+	4: "trusted.",
+	6: "security.",
+	7: "system.",
+	8: "system.richacl",
+}
+
+func xattrName(index byte, name []byte) string {
+	if prefix, ok := xattrPrefixes[index]; ok {
+		return prefix + string(name)
+	}
+	return fmt.Sprintf("unknown%d.%s", index, name)
+}
+
+// Xattrs returns all extended attributes of inode n, first those stored in
+// the inode itself, then those in the external attribute block.
+func (er *Reader) Xattrs(n uint32) ([]Xattr, error) {
+	inode, err := er.GetInode(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []Xattr
+	if er.super.InodeSize > 128 && inode.ExtraIsize > 0 {
+		raw, err := er.readInodeBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if ibody := 128 + int(inode.ExtraIsize); ibody+4 <= len(raw) &&
+			binary.LittleEndian.Uint32(raw[ibody:]) == xattrMagic {
+			// value offsets are relative to the first entry
+			attrs, err := er.readXattrEntries(raw[ibody+4:], false)
+			if err != nil {
+				return nil, fmt.Errorf("Inode %d: %v", n, err)
+			}
+			rv = append(rv, attrs...)
+		}
+	}
+
+	if block := er.fileAcl(inode); block != 0 {
+		attrs, err := er.readXattrBlock(block)
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, attrs...)
+	}
+	return rv, nil
+}
+
+// fileAcl returns the block holding the extended attributes of inode, or 0.
+// The high bits are only used on 64bit filesystems.
+func (er *Reader) fileAcl(inode Inode) int64 {
+	block := int64(inode.FileAclLo)
+	if er.super.FeatureIncompat&FeatureIncompatFlag64Bit != 0 {
+		block += int64(inode.FileAclHigh) << 32
+	}
+	return block
+}
+
+// GetXattr returns the value of the named extended attribute of inode n,
+// or ErrNotFound.
+func (er *Reader) GetXattr(n uint32, name string) ([]byte, error) {
+	attrs, err := er.Xattrs(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		if a.Name == name {
+			return a.Value, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (er *Reader) readXattrBlock(block int64) ([]Xattr, error) {
+	b := make([]byte, er.super.blockSize())
+	if _, err := er.readAt(b, er.blockOffset(block)); err != nil {
+		return nil, err
+	}
+
+	var h XattrHeader
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.Magic != xattrMagic {
+		return nil, fmt.Errorf("Extended attribute block %d: magic did not match 0x%X!=0x%X", block, h.Magic, xattrMagic)
+	}
+	if h.Blocks != 1 {
+		return nil, fmt.Errorf("Extended attribute block %d: spans %d blocks", block, h.Blocks)
+	}
+	if h.Refcount == 0 {
+		return nil, fmt.Errorf("Extended attribute block %d: refcount is 0", block)
+	}
+
+	attrs, err := er.readXattrEntries(b, true)
+	if err != nil {
+		return nil, fmt.Errorf("Extended attribute block %d: %v", block, err)
+	}
+	if hash := xattrBlockHash(b); h.Hash != 0 && hash != h.Hash {
+		return nil, fmt.Errorf("Extended attribute block %d: hash did not match 0x%X!=0x%X", block, hash, h.Hash)
+	}
+	return attrs, nil
+}
+
+// readXattrEntries decodes the entries in b. In a block, entries follow the
+// header and value offsets are relative to the start of the block; in the
+// inode, entries start right away.
+func (er *Reader) readXattrEntries(b []byte, block bool) ([]Xattr, error) {
+	var rv []Xattr
+	pos := 0
+	if block {
+		pos = binary.Size(XattrHeader{})
+	}
+	for pos+4 <= len(b) && binary.LittleEndian.Uint32(b[pos:]) != 0 {
+		var e XattrEntry
+		size := binary.Size(e)
+		if pos+size > len(b) {
+			return nil, fmt.Errorf("truncated xattr entry at %d", pos)
+		}
+		binary.Read(bytes.NewReader(b[pos:]), binary.LittleEndian, &e)
+		if pos+size+int(e.NameLen) > len(b) {
+			return nil, fmt.Errorf("truncated xattr name at %d", pos)
+		}
+		name := b[pos+size : pos+size+int(e.NameLen)]
+		pos += (size + int(e.NameLen) + 3) &^ 3
+
+		a := Xattr{Name: xattrName(e.NameIndex, name)}
+		if e.ValueInum != 0 {
+			a.Inode = e.ValueInum
+			value, err := er.readXattrInode(e.ValueInum, e.ValueSize)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", a.Name, err)
+			}
+			a.Value = value
+		} else {
+			end := int(e.ValueOffs) + int(e.ValueSize)
+			if end > len(b) {
+				return nil, fmt.Errorf("%s: value out of bounds", a.Name)
+			}
+			a.Value = b[e.ValueOffs:end]
+			if e.Hash != 0 && xattrEntryHash(name, a.Value) != e.Hash {
+				return nil, fmt.Errorf("%s: hash did not match 0x%X!=0x%X", a.Name, xattrEntryHash(name, a.Value), e.Hash)
+			}
+		}
+		rv = append(rv, a)
+	}
+	return rv, nil
+}
+
+// readXattrInode reads a large attribute value stored in an EA inode.
+func (er *Reader) readXattrInode(n uint32, size uint32) ([]byte, error) {
+	inode, err := er.GetInode(n)
+	if err != nil {
+		return nil, err
+	}
+	if inode.Flags&InodeFlagEAInode == 0 {
+		return nil, fmt.Errorf("inode %d is not an EA inode", n)
+	}
+	if inode.Size() < uint64(size) {
+		return nil, fmt.Errorf("EA inode %d is too small (%d<%d)", n, inode.Size(), size)
+	}
+	r, err := er.GetInodeReader(inode)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// xattrEntryHash is ext4_xattr_hash_entry: the name, then the value as
+// little endian 32-bit words.
+func xattrEntryHash(name, value []byte) uint32 {
+	var hash uint32
+	for _, c := range name {
+		hash = (hash << 5) ^ (hash >> 27) ^ uint32(c)
+	}
+	for i := 0; i < len(value); i += 4 {
+		var w [4]byte
+		copy(w[:], value[i:])
+		hash = (hash << 16) ^ (hash >> 16) ^ binary.LittleEndian.Uint32(w[:])
+	}
+	return hash
+}
+
+// xattrBlockHash is ext4_xattr_rehash: a combination of all entry hashes.
+func xattrBlockHash(b []byte) uint32 {
+	var hash uint32
+	var e XattrEntry
+	size := binary.Size(e)
+	for pos := binary.Size(XattrHeader{}); pos+size <= len(b) && binary.LittleEndian.Uint32(b[pos:]) != 0; {
+		binary.Read(bytes.NewReader(b[pos:]), binary.LittleEndian, &e)
+		if e.Hash == 0 {
+			return 0
+		}
+		hash = (hash << 16) ^ (hash >> 16) ^ e.Hash
+		pos += (size + int(e.NameLen) + 3) &^ 3
+	}
+	return hash
+}
@@ -0,0 +1,82 @@
+package ext4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXattrName(t *testing.T) {
+	tests := []struct {
+		index    byte
+		name     string
+		expected string
+	}{
+		{1, "comment", "user.comment"},
+		{2, "", "system.posix_acl_access"},
+		{3, "", "system.posix_acl_default"},
+		{6, "selinux", "security.selinux"},
+		{7, "data", "system.data"},
+		{42, "x", "unknown42.x"},
+	}
+	for _, test := range tests {
+		if name := xattrName(test.index, []byte(test.name)); name != test.expected {
+			t.Errorf("xattrName(%d, %q) = %q, expected %q", test.index, test.name, name, test.expected)
+		}
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		value []byte
+	}{
+		{"etc/os-release", "user.comment", []byte("hello")},              // in the inode
+		{"var/log/messages", "user.big", bytes.Repeat([]byte("x"), 300)}, // in an attribute block
+	}
+	for _, image := range []string{"ext4", "ext2"} {
+		r := openImage(t, readImage(t, image))
+		root, err := r.Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			e, _, err := root.Lookup(test.path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := r.GetXattr(e.Inode, test.name)
+			if err != nil {
+				t.Errorf("%s: GetXattr(%s, %s): %v", image, test.path, test.name, err)
+				continue
+			}
+			if !bytes.Equal(v, test.value) {
+				t.Errorf("%s: GetXattr(%s, %s) = %q, expected %q", image, test.path, test.name, v, test.value)
+			}
+		}
+
+		e, _, err := root.Lookup("etc/messages", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.GetXattr(e.Inode, "user.comment"); err != ErrNotFound {
+			t.Errorf("%s: GetXattr of a symlink without attributes: %v, expected %v", image, err, ErrNotFound)
+		}
+	}
+}
+
+func TestFileAcl(t *testing.T) {
+	inode := Inode{FileAclLo: 1234, FileAclHigh: 1}
+	for _, test := range []struct {
+		image    string
+		expected int64
+	}{
+		{"ext4", 1<<32 + 1234},
+		{"ext2", 1234},
+	} {
+		r := openImage(t, readImage(t, test.image))
+		if block := r.fileAcl(inode); block != test.expected {
+			t.Errorf("%s: fileAcl() = %d, expected %d", test.image, block, test.expected)
+		}
+	}
+}
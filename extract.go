@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// same as the ext4 package uses for InodeReader.WriteTo, io.Copy's 32k
+// buffer makes for overly chatty HTTP
+const copyChunkSize = 4 * 1024 * 1024
+
+// partialError is returned by extractRange when the file could only be
+// downloaded in part.
+type partialError struct {
+	written, size int64
+	file          string // Where the partial content was kept.
+	err           error
+}
+
+func (e *partialError) Error() string {
+	if e.file == "" {
+		return fmt.Sprintf("only %d of %d bytes downloaded: %v", e.written, e.size, e.err)
+	}
+	return fmt.Sprintf("only %d of %d bytes downloaded, kept as %s: %v", e.written, e.size, e.file, e.err)
+}
+
+// copyRange copies the bytes from start up to end of ir to w, in large
+// chunks so that neither memory use nor the number of requests grows with the
+// file size. Errors writing to w are returned as *outputError.
+func copyRange(w io.Writer, ir ext4.InodeReader, start, end int64) (int64, error) {
+	if _, err := ir.Seek(start, 0); err != nil {
+		return 0, err
+	}
+	// also hides w's ReadFrom, which would not use the buffer
+	ew := &errWriter{w: w}
+	var n int64
+	var err error
+	if start == 0 && end == ir.Size() {
+		n, err = ir.WriteTo(ew)
+	} else {
+		n, err = io.CopyBuffer(ew, io.LimitReader(ir, end-start), make([]byte, copyChunkSize))
+	}
+	if ew.err != nil {
+		return n, &outputError{ew.err}
+	}
+	if err == nil && n != end-start {
+		err = fmt.Errorf("short read")
+	}
+	return n, err
+}
+
+// errWriter remembers the error of the writer it wraps.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// extractRange streams the bytes from start up to end of ir into outFile.
+// The data goes to a temporary file first, which is renamed into place once
+// it has the full length. If reading stops short, the data read so far is
+// kept as outFile.partial and a *partialError is returned.
+func extractRange(ir ext4.InodeReader, outFile string, start, end int64) (int64, error) {
+	tmp, err := ioutil.TempFile(path.Dir(outFile), "."+path.Base(outFile)+".tmp")
+	if err != nil {
+		return 0, &outputError{err}
+	}
+	n, err := copyRange(tmp, ir, start, end)
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = &outputError{cerr}
+	}
+	if err != nil {
+		if _, ok := err.(*outputError); ok || n == 0 {
+			os.Remove(tmp.Name())
+			return 0, err
+		}
+		partial := outFile + ".partial"
+		if rerr := os.Rename(tmp.Name(), partial); rerr != nil {
+			os.Remove(tmp.Name())
+			return n, err
+		}
+		return n, &partialError{written: n, size: end - start, file: partial, err: err}
+	}
+	return n, os.Rename(tmp.Name(), outFile)
+}
@@ -4,7 +4,35 @@ import (
 	"strings"
 )
 
+// windowsReserved are names Windows does not allow for files, with or
+// without extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// fixFilename makes each element of the path name valid on Windows: the
+// characters it does not allow become underscores, as do trailing dots and
+// spaces, and reserved names get an underscore appended. Archives keep the
+// original names.
 func fixFilename(name string) string {
-	name = strings.Replace(name, ":", "_", -1)
-	return name
+	elems := strings.Split(name, "/")
+	for i, e := range elems {
+		b := []byte(e)
+		for j, c := range b {
+			if c < 32 || strings.IndexByte(`<>:"\|?*`, c) >= 0 {
+				b[j] = '_'
+			}
+		}
+		for j := len(b) - 1; j >= 0 && (b[j] == '.' || b[j] == ' ') && string(b) != "." && string(b) != ".."; j-- {
+			b[j] = '_'
+		}
+		e = string(b)
+		if windowsReserved[strings.ToUpper(strings.SplitN(e, ".", 2)[0])] {
+			e += "_"
+		}
+		elems[i] = e
+	}
+	return strings.Join(elems, "/")
 }
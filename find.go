@@ -0,0 +1,281 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// fileTypes are the -type letters of find(1).
+var fileTypes = map[string]ext4.FileType{
+	"f": ext4.FileTypeFile,
+	"d": ext4.FileTypeDir,
+	"l": ext4.FileTypeSymlink,
+	"b": ext4.FileTypeBlockdev,
+	"c": ext4.FileTypeChardev,
+	"p": ext4.FileTypeFIFO,
+	"s": ext4.FileTypeSocket,
+}
+
+// findPredicate tests an entry visited by find.
+type findPredicate func(e ext4.WalkEntry) bool
+
+// parseCompare parses the "+N", "-N" and "N" arguments of find(1), for more
+// than, less than and exactly N. parse converts N.
+func parseCompare(s string, parse func(string) (int64, error)) (func(int64) bool, error) {
+	sign := ""
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		sign, s = s[:1], s[1:]
+	}
+	n, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch sign {
+	case "+":
+		return func(v int64) bool { return v > n }, nil
+	case "-":
+		return func(v int64) bool { return v < n }, nil
+	}
+	return func(v int64) bool { return v == n }, nil
+}
+
+func parseDays(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
+
+// pathDepth returns the number of elements of an absolute path.
+func pathDepth(p string) int {
+	return len(strings.FieldsFunc(p, func(r rune) bool { return r == '/' }))
+}
+
+func findCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	name := f.String("name", "", "Base name matches this pattern.")
+	iname := f.String("iname", "", "Like -name, but regardless of case.")
+	typ := f.String("type", "", "File type: f, d, l, b, c, p or s.")
+	size := f.String("size", "", "Size is more than (+N), less than (-N) or exactly N bytes; K, M and G suffixes allowed.")
+	mtime := f.String("mtime", "", "Modified more than (+N), less than (-N) or exactly N days ago, counted in whole days.")
+	newer := f.String("newer", "", "Modified after this time, e.g. 2006-01-02T15:04:05Z.")
+	maxDepth := f.Int("maxdepth", -1, "Descend at most this many levels below the starting points.")
+	long := f.Bool("ls", false, "List matches like ls -l.")
+	return func(s *session, paths []string) error {
+		var preds []findPredicate
+		if *name != "" || *iname != "" {
+			pattern, fold := *name, false
+			if pattern == "" {
+				pattern, fold = *iname, true
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("-name %s: %v", pattern, err)
+			}
+			preds = append(preds, func(e ext4.WalkEntry) bool {
+				n := e.Entry.Name.String()
+				if n == "" {
+					n = "/"
+				}
+				if fold {
+					ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(n))
+					return ok
+				}
+				ok, _ := path.Match(pattern, n)
+				return ok
+			})
+		}
+		if *typ != "" {
+			ft, ok := fileTypes[*typ]
+			if !ok {
+				return fmt.Errorf("-type %s: unknown type", *typ)
+			}
+			preds = append(preds, func(e ext4.WalkEntry) bool { return e.Inode.Mode.FileType() == ft })
+		}
+		if *size != "" {
+			cmp, err := parseCompare(*size, parseSize)
+			if err != nil {
+				return fmt.Errorf("-size %s: %v", *size, err)
+			}
+			preds = append(preds, func(e ext4.WalkEntry) bool { return cmp(int64(e.Inode.Size())) })
+		}
+		if *mtime != "" {
+			cmp, err := parseCompare(*mtime, parseDays)
+			if err != nil {
+				return fmt.Errorf("-mtime %s: %v", *mtime, err)
+			}
+			now := time.Now()
+			preds = append(preds, func(e ext4.WalkEntry) bool {
+				return cmp(int64(now.Sub(e.Inode.ModTime()) / (24 * time.Hour)))
+			})
+		}
+		if *newer != "" {
+			t, err := parseTime(*newer)
+			if err != nil {
+				return fmt.Errorf("-newer: %v", err)
+			}
+			preds = append(preds, func(e ext4.WalkEntry) bool { return e.Inode.ModTime().After(t) })
+		}
+
+		root, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+
+		visit := func(e ext4.WalkEntry) {
+			for _, p := range preds {
+				if !p(e) {
+					return
+				}
+			}
+			if *long {
+				fmt.Println(formatLong(e.Path, e.Entry.Reader(), e.Inode))
+			} else {
+				fmt.Println(e.Path)
+			}
+		}
+
+		for _, p := range paths {
+			dir, err := root.ChangeDir(p)
+			if err != nil {
+				// not a directory, test just the file
+				e, inode, lerr := root.Lookup(p, true)
+				if lerr != nil {
+					return fmt.Errorf("%s: %v", p, lerr)
+				}
+				visit(ext4.WalkEntry{Path: e.Fullname(), Entry: e, Inode: inode})
+				continue
+			}
+
+			base := -1
+			err = dir.Walk(func(e ext4.WalkEntry, err error) error {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "WARN: %s: %v\n", e.Path, err)
+					return nil
+				}
+				if base < 0 {
+					base = pathDepth(e.Path)
+				}
+				visit(e)
+				if *maxDepth >= 0 && pathDepth(e.Path)-base >= *maxDepth && e.Inode.Mode.FileType() == ext4.FileTypeDir {
+					return fs.SkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// treeLine is an entry shown by tree.
+type treeLine struct {
+	depth int
+	name  string
+	last  bool // Whether it is the last entry in its directory.
+}
+
+func treeCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	maxDepth := f.Int("L", 0, "Descend at most this many levels, 0 for no limit.")
+	return func(s *session, paths []string) error {
+		if len(paths) > 1 {
+			return errUsage
+		}
+		root, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		start := "."
+		if len(paths) > 0 {
+			start = paths[0]
+		}
+		return printTree(root, start, *maxDepth)
+	}
+}
+
+// printTree shows the directory start and everything below it, down to
+// maxDepth levels if that is not 0, like tree(1).
+func printTree(root ext4.Directory, start string, maxDepth int) error {
+	dir, err := root.ChangeDir(start)
+	if err != nil {
+		return fmt.Errorf("%s: %v", start, err)
+	}
+
+	var lines []treeLine
+	dirs, files := 0, 0
+	base := -1
+	err = dir.Walk(func(e ext4.WalkEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: %s: %v\n", e.Path, err)
+			return nil
+		}
+		if base < 0 {
+			base = pathDepth(e.Path)
+			return nil
+		}
+		l := treeLine{depth: pathDepth(e.Path) - base, name: e.Entry.Name.String()}
+		switch e.Inode.Mode.FileType() {
+		case ext4.FileTypeDir:
+			dirs++
+		case ext4.FileTypeSymlink:
+			if target, err := e.Entry.Reader().ReadLink(e.Inode); err == nil {
+				l.name += " -> " + target
+			}
+			files++
+		default:
+			files++
+		}
+		lines = append(lines, l)
+		if maxDepth > 0 && l.depth >= maxDepth && e.Inode.Mode.FileType() == ext4.FileTypeDir {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// an entry is the last in its directory if no other one at its depth
+	// follows before the walk goes back up
+	seen := map[int]bool{}
+	for i := len(lines) - 1; i >= 0; i-- {
+		d := lines[i].depth
+		lines[i].last = !seen[d]
+		seen[d] = true
+		for k := range seen {
+			if k > d {
+				delete(seen, k)
+			}
+		}
+	}
+
+	fmt.Println(dir.Path())
+	lastAt := map[int]bool{}
+	for _, l := range lines {
+		prefix := ""
+		for k := 1; k < l.depth; k++ {
+			if lastAt[k] {
+				prefix += "    "
+			} else {
+				prefix += "│   "
+			}
+		}
+		if l.last {
+			prefix += "└── "
+		} else {
+			prefix += "├── "
+		}
+		lastAt[l.depth] = l.last
+		fmt.Println(prefix + l.name)
+	}
+	fmt.Printf("\n%d directories, %d files\n", dirs, files)
+	return nil
+}
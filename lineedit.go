@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// lineEditor reads lines from the terminal with basic emacs style editing,
+// history and tab completion. If the input is not a terminal, it just reads
+// lines.
+type lineEditor struct {
+	in      *os.File
+	out     io.Writer
+	r       *bufio.Reader
+	history []string
+	// complete returns the candidates for the word ending at the end of
+	// before, and where in before that word starts.
+	complete func(before string) (start int, candidates []string)
+}
+
+func newLineEditor(complete func(string) (int, []string)) *lineEditor {
+	return &lineEditor{
+		in:       os.Stdin,
+		out:      os.Stdout,
+		r:        bufio.NewReader(os.Stdin),
+		complete: complete,
+	}
+}
+
+// readLine shows prompt and returns the line entered, or io.EOF on Ctrl-D.
+// Ctrl-C discards the line.
+func (ed *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(ed.in.Fd())
+	if err != nil {
+		fmt.Fprint(ed.out, prompt)
+		line, err := ed.r.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		ed.addHistory(line)
+		return line, nil
+	}
+	defer restore()
+
+	var buf []rune
+	pos, hist, saved := 0, len(ed.history), ""
+	redraw := func() {
+		fmt.Fprintf(ed.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(ed.out, "\x1b[%dD", n)
+		}
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+
+	redraw()
+	for {
+		r, _, err := ed.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(ed.out, "\r\n")
+			line := string(buf)
+			ed.addHistory(line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(ed.out, "^C\r\n")
+			return "", nil
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(ed.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case '\t':
+			ed.completeAt(&buf, &pos)
+		case 27: // escape sequences of the arrow and other keys
+			key, err := ed.readEscape()
+			if err != nil {
+				return "", err
+			}
+			switch key {
+			case 'A': // Up
+				if hist > 0 {
+					if hist == len(ed.history) {
+						saved = string(buf)
+					}
+					hist--
+					setLine(ed.history[hist])
+				}
+			case 'B': // Down
+				if hist < len(ed.history) {
+					hist++
+					if hist == len(ed.history) {
+						setLine(saved)
+					} else {
+						setLine(ed.history[hist])
+					}
+				}
+			case 'C': // Right
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D': // Left
+				if pos > 0 {
+					pos--
+				}
+			case 'H': // Home
+				pos = 0
+			case 'F': // End
+				pos = len(buf)
+			case '3': // Delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// addHistory remembers line, unless it is empty or repeats the last one.
+func (ed *lineEditor) addHistory(line string) {
+	if n := len(ed.history); strings.TrimSpace(line) != "" && (n == 0 || ed.history[n-1] != line) {
+		ed.history = append(ed.history, line)
+	}
+}
+
+// readEscape reads the rest of an escape sequence and returns the letter of
+// the key, or for "ESC [ n ~" sequences the digit.
+func (ed *lineEditor) readEscape() (rune, error) {
+	r, _, err := ed.r.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0, err
+	}
+	key, _, err := ed.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if key >= '0' && key <= '9' {
+		// skip up to the final ~
+		for r := key; r != '~'; {
+			if r, _, err = ed.r.ReadRune(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return key, nil
+}
+
+// completeAt completes the word before pos in buf. A single candidate
+// replaces the word, several are listed if they share no longer prefix.
+func (ed *lineEditor) completeAt(buf *[]rune, pos *int) {
+	if ed.complete == nil {
+		return
+	}
+	before := string((*buf)[:*pos])
+	start, candidates := ed.complete(before)
+	if len(candidates) == 0 {
+		return
+	}
+	replacement := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(replacement, "/") {
+		replacement += " "
+	}
+	if len(candidates) > 1 && len(replacement) <= len(before)-start {
+		fmt.Fprintf(ed.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		return
+	}
+	head := []rune(before[:start] + replacement)
+	*buf = append(head, (*buf)[*pos:]...)
+	*pos = len(head)
+}
+
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, c := range s[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// readKey returns the next key pressed, or the first character of the next
+// line if the input is not a terminal.
+func (ed *lineEditor) readKey() (rune, error) {
+	if restore, err := makeRaw(ed.in.Fd()); err == nil {
+		defer restore()
+		r, _, err := ed.r.ReadRune()
+		return r, err
+	}
+	line, err := ed.r.ReadString('\n')
+	if line == "" {
+		return 0, err
+	}
+	return []rune(line)[0], nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// formatLong formats an inode like ls -l does, with times in UTC.
+func formatLong(name string, r *ext4.Reader, inode ext4.Inode) string {
+	line := fmt.Sprintf("%v %3d %5d %5d %10d %s %s",
+		inode.Mode.Symbolic(), inode.LinksCount, inode.UID(), inode.GID(), inode.Size(),
+		inode.ModTime().UTC().Format("2006-01-02 15:04"), name)
+	if inode.Mode.FileType() == ext4.FileTypeSymlink {
+		if target, err := r.ReadLink(inode); err == nil {
+			line += " -> " + target
+		}
+	}
+	return line
+}
+
+func lsCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	long := f.Bool("l", false, "Show mode, links, owner, group, size and modification time.")
+	all := f.Bool("a", false, "Show . and .. too.")
+	return func(s *session, paths []string) error {
+		root, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		return listDirs(root, paths, *long, *all)
+	}
+}
+
+// listDirs lists the directories among paths, relative to root, and shows
+// the other paths themselves.
+func listDirs(root ext4.Directory, paths []string, long, all bool) error {
+	for i, p := range paths {
+		// like ls, show a symlink itself in long listings
+		e, inode, err := root.Lookup(p, !long)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		if inode.Mode.FileType() != ext4.FileTypeDir {
+			if long {
+				fmt.Println(formatLong(p, e.Reader(), inode))
+			} else {
+				fmt.Println(p)
+			}
+			continue
+		}
+
+		dir, err := root.ChangeDir(p)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		entries, err := dir.Entries()
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name.String() < entries[j].Name.String() })
+
+		if len(paths) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", p)
+		}
+		for _, e := range entries {
+			name := e.Name.String()
+			if (name == "." || name == "..") && !all {
+				continue
+			}
+			if !long {
+				fmt.Println(name)
+				continue
+			}
+			inode, err := entryInode(dir, e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "WARN: %s: %v\n", e.Fullname(), err)
+				continue
+			}
+			fmt.Println(formatLong(name, e.Reader(), inode))
+		}
+	}
+	return nil
+}
+
+// entryInode returns the inode of the entry e of dir, or for a mount point
+// that of the root of the filesystem mounted there.
+func entryInode(dir ext4.Directory, e ext4.DirEntry) (ext4.Inode, error) {
+	name := e.Name.String()
+	if e.FileType == ext4.FileTypeDir && name != "." && name != ".." {
+		_, inode, err := dir.Lookup(name, false)
+		return inode, err
+	}
+	fi, err := e.Info()
+	return fi.Inode, err
+}
+
+func statCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	follow := f.Bool("L", false, "Follow symlinks.")
+	return func(s *session, paths []string) error {
+		if len(paths) == 0 {
+			return errUsage
+		}
+		root, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		for i, p := range paths {
+			if i > 0 {
+				fmt.Println()
+			}
+			e, inode, err := root.Lookup(p, *follow)
+			if err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			if err := printInode(e, inode); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+		}
+		return nil
+	}
+}
+
+// printInode shows the inode of e, much like stat(1) does, followed by its
+// extended attributes and where its data is on disk.
+func printInode(e ext4.DirEntry, inode ext4.Inode) error {
+	r := e.Reader()
+	ft := inode.Mode.FileType()
+
+	name := e.Fullname()
+	if ft == ext4.FileTypeSymlink {
+		if target, err := r.ReadLink(inode); err == nil {
+			name += " -> " + target
+		}
+	}
+	fmt.Printf("  File: %s\n", name)
+	fmt.Printf("  Type: %v\n", ft)
+	fmt.Printf("  Size: %-12d Allocated: %d\n", inode.Size(), r.AllocatedSize(inode))
+	fmt.Printf(" Inode: %-12d Links: %d\n", e.Inode, inode.LinksCount)
+	fmt.Printf("  Mode: (%04o/%s)  Uid: %d  Gid: %d\n", inode.Mode&07777, inode.Mode.Symbolic(), inode.UID(), inode.GID())
+	fmt.Printf(" Flags: %v\n", inode.Flags)
+	for _, t := range []struct {
+		name string
+		t    time.Time
+	}{
+		{"Access", inode.AccessTime()},
+		{"Modify", inode.ModTime()},
+		{"Change", inode.ChangeTime()},
+		{" Birth", inode.BirthTime()},
+		{"Delete", inode.DeletionTime()},
+	} {
+		if !t.t.IsZero() {
+			fmt.Printf("%s: %s\n", t.name, t.t.UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	xattrs, err := r.Xattrs(e.Inode)
+	if err != nil {
+		fmt.Printf("WARN: could not read extended attributes: %v\n", err)
+	}
+	if len(xattrs) > 0 {
+		var names []string
+		for _, x := range xattrs {
+			names = append(names, x.Name)
+		}
+		fmt.Printf(" Xattr: %s\n", strings.Join(names, ", "))
+	}
+
+	switch {
+	case inode.Flags&ext4.InodeFlagInlineData != 0:
+		fmt.Printf("  Data: inline in the inode\n")
+	case ft == ext4.FileTypeSymlink && inode.Size() < uint64(len(inode.Data)):
+		fmt.Printf("  Data: fast symlink, target in the inode\n")
+	case ft == ext4.FileTypeFile || ft == ext4.FileTypeDir || ft == ext4.FileTypeSymlink:
+		extents, err := r.DataExtents(inode)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Extents: %d\n", len(extents))
+		for _, x := range extents {
+			n := int64(x.Length())
+			line := fmt.Sprintf("  %d-%d -> %d-%d (%d blocks)", x.Block, int64(x.Block)+n-1, x.Start(), x.Start()+n-1, n)
+			if x.Uninitialized() {
+				line += " uninitialized"
+			}
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
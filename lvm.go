@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LVM2 on-disk format, see lib/format_text/layout.h of lvm2.
+const (
+	lvmLabelID     = "LABELONE"
+	lvmLabelType   = "LVM2 001"
+	lvmMDAMagic    = " LVM2 x[5A%r0N*>"
+	lvmMDAHeader   = 512 // Size of the metadata area header; the text buffer follows it.
+	lvmLocnIgnored = 1   // raw_locn flag of metadata areas that are not used.
+)
+
+// physicalVolume is a partition with an LVM2 label.
+type physicalVolume struct {
+	part     int    // Partition index.
+	start    int64  // Byte offset of the partition on the disk.
+	uuid     string // Without the dashes of the metadata.
+	metadata []byte // Text metadata of the volume group, nil if it has none.
+}
+
+// logicalVolume is a logical volume of a volume group, as the volume group
+// metadata of its physical volumes maps it.
+type logicalVolume struct {
+	vg, name string
+	part     int   // Partition of the physical volume with the first extent.
+	size     int64 // In bytes.
+	segments []lvSegment
+}
+
+// lvSegment maps a run of extents of a logical volume to its stripes, one
+// for linear segments.
+type lvSegment struct {
+	start, size int64   // Of the segment in the logical volume, in bytes.
+	stripeSize  int64   // In bytes, if there is more than one stripe.
+	stripes     []int64 // Byte offsets on the disk where the stripes start.
+}
+
+func (lv *logicalVolume) String() string {
+	return lv.vg + "/" + lv.name
+}
+
+// dmName returns the device mapper name of the logical volume, as in
+// /dev/mapper/rootvg-rootlv, with dashes in the names doubled.
+func (lv *logicalVolume) dmName() string {
+	return strings.Replace(lv.vg, "-", "--", -1) + "-" + strings.Replace(lv.name, "-", "--", -1)
+}
+
+// readPhysicalVolume returns the physical volume on partition p, or nil if
+// it has no LVM2 label, which is in one of its first four sectors.
+func readPhysicalVolume(s io.ReadSeeker, partitionNum int, p partitionEntry) (*physicalVolume, error) {
+	start := int64(p.LBAfirst) * 512
+	b := make([]byte, 4*512)
+	if _, err := s.Seek(start, 0); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(s, b); err != nil {
+		return nil, err
+	}
+	var label []byte
+	for i := 0; i < 4; i++ {
+		if sector := b[i*512 : (i+1)*512]; string(sector[:8]) == lvmLabelID && string(sector[24:32]) == lvmLabelType {
+			label = sector
+			break
+		}
+	}
+	if label == nil {
+		return nil, nil
+	}
+
+	pv := &physicalVolume{part: partitionNum, start: start}
+	h := label[binary.LittleEndian.Uint32(label[20:24])%512:]
+	if len(h) < 40 {
+		return nil, fmt.Errorf("LVM label is truncated")
+	}
+	pv.uuid = string(h[:32])
+	// the data areas, then the metadata areas, each list ending with an
+	// empty entry
+	var mdas []int64
+	lists := 0
+	for pos := 40; lists < 2 && pos+16 <= len(h); pos += 16 {
+		offset := int64(binary.LittleEndian.Uint64(h[pos:]))
+		if offset == 0 && binary.LittleEndian.Uint64(h[pos+8:]) == 0 {
+			lists++
+		} else if lists == 1 {
+			mdas = append(mdas, offset)
+		}
+	}
+	for _, offset := range mdas {
+		text, err := readMetadataArea(s, start+offset)
+		if err != nil {
+			return pv, err
+		}
+		if text != nil {
+			pv.metadata = text
+			break
+		}
+	}
+	return pv, nil
+}
+
+// readMetadataArea returns the current volume group metadata in the
+// metadata area at byte offset off of the disk, nil if there is none. The
+// area is a ring buffer, so the text may wrap around to its start.
+func readMetadataArea(s io.ReadSeeker, off int64) ([]byte, error) {
+	h := make([]byte, lvmMDAHeader)
+	if _, err := s.Seek(off, 0); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(s, h); err != nil {
+		return nil, err
+	}
+	if string(h[4:20]) != lvmMDAMagic {
+		return nil, fmt.Errorf("no LVM metadata area at offset %d", off)
+	}
+	size := int64(binary.LittleEndian.Uint64(h[32:40]))
+	// the first raw_locn is the current metadata
+	locn := h[40:64]
+	textOffset := int64(binary.LittleEndian.Uint64(locn[0:8]))
+	textSize := int64(binary.LittleEndian.Uint64(locn[8:16]))
+	if textOffset == 0 || binary.LittleEndian.Uint32(locn[20:24])&lvmLocnIgnored != 0 {
+		return nil, nil
+	}
+	if textOffset < lvmMDAHeader || textOffset >= size || textSize > size-lvmMDAHeader {
+		return nil, fmt.Errorf("LVM metadata of %d bytes at %d does not fit its area of %d bytes", textSize, textOffset, size)
+	}
+
+	text := make([]byte, textSize)
+	first := textSize
+	if textOffset+textSize > size {
+		first = size - textOffset
+	}
+	if _, err := s.Seek(off+textOffset, 0); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(s, text[:first]); err != nil {
+		return nil, err
+	}
+	if first < textSize {
+		if _, err := s.Seek(off+lvmMDAHeader, 0); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(s, text[first:]); err != nil {
+			return nil, err
+		}
+	}
+	return bytes.TrimRight(text, "\x00"), nil
+}
+
+// findLogicalVolumes returns the logical volumes of the volume groups on
+// pvs, from the latest metadata of each. Those that are not on the disk
+// completely, or that are not linear or striped (like thin volumes), are
+// left out with a warning.
+func findLogicalVolumes(pvs []*physicalVolume) []*logicalVolume {
+	byUUID := map[string]*physicalVolume{}
+	latest := map[string]*lvmSection{}
+	var names []string
+	for _, pv := range pvs {
+		byUUID[pv.uuid] = pv
+		if pv.metadata == nil {
+			continue
+		}
+		meta, err := parseLVMMetadata(pv.metadata)
+		if err != nil {
+			warnf("partition %d: LVM metadata: %v", pv.part, err)
+			continue
+		}
+		for _, name := range meta.order {
+			vg := meta.section(name)
+			if prev, ok := latest[name]; !ok {
+				names = append(names, name)
+			} else if prev.int("seqno") >= vg.int("seqno") {
+				continue
+			}
+			latest[name] = vg
+		}
+	}
+	sort.Strings(names)
+
+	var lvs []*logicalVolume
+	for _, name := range names {
+		vg := latest[name]
+		extentSize := vg.int("extent_size") * 512
+		pvsOnDisk := map[string]pvExtents{}
+		pvSection := vg.section("physical_volumes")
+		for _, n := range pvSection.order {
+			if pv := byUUID[strings.Replace(pvSection.section(n).string("id"), "-", "", -1)]; pv != nil {
+				pvsOnDisk[n] = pvExtents{part: pv.part, start: pv.start + pvSection.section(n).int("pe_start")*512}
+			}
+		}
+		lvSection := vg.section("logical_volumes")
+		for _, n := range lvSection.order {
+			lv, err := newLogicalVolume(name, n, lvSection.section(n), extentSize, pvsOnDisk)
+			if err != nil {
+				warnf("logical volume %s/%s cannot be read: %v", name, n, err)
+				continue
+			}
+			if lv != nil {
+				lvs = append(lvs, lv)
+			}
+		}
+	}
+	return lvs
+}
+
+// pvExtents is where the extents of a physical volume start on the disk.
+type pvExtents struct {
+	part  int
+	start int64
+}
+
+// newLogicalVolume maps the segments of the logical volume section s to
+// the physical volumes on the disk, by their names in the metadata. It
+// returns nil for hidden volumes, like the metadata of thin pools.
+func newLogicalVolume(vg, name string, s *lvmSection, extentSize int64, pvs map[string]pvExtents) (*logicalVolume, error) {
+	visible := false
+	for _, v := range s.list("status") {
+		visible = visible || v == "VISIBLE"
+	}
+	if !visible {
+		return nil, nil
+	}
+	lv := &logicalVolume{vg: vg, name: name}
+	for _, n := range s.order {
+		seg := s.section(n)
+		if t := seg.string("type"); t != "striped" {
+			return nil, fmt.Errorf("%s segments are not supported", t)
+		}
+		ls := lvSegment{
+			start:      seg.int("start_extent") * extentSize,
+			size:       seg.int("extent_count") * extentSize,
+			stripeSize: seg.int("stripe_size") * 512,
+		}
+		stripes := seg.list("stripes")
+		if len(stripes) == 0 || len(stripes)%2 != 0 || (len(stripes) > 2 && ls.stripeSize == 0) {
+			return nil, fmt.Errorf("%s has stripes %v", n, stripes)
+		}
+		for i := 0; i < len(stripes); i += 2 {
+			pv, _ := stripes[i].(string)
+			extent, _ := stripes[i+1].(int64)
+			on, ok := pvs[pv]
+			if !ok {
+				return nil, fmt.Errorf("physical volume %s is not on this disk", pv)
+			}
+			if ls.start == 0 && i == 0 {
+				lv.part = on.part
+			}
+			ls.stripes = append(ls.stripes, on.start+extent*extentSize)
+		}
+		lv.segments = append(lv.segments, ls)
+		if end := ls.start + ls.size; end > lv.size {
+			lv.size = end
+		}
+	}
+	sort.Slice(lv.segments, func(i, j int) bool { return lv.segments[i].start < lv.segments[j].start })
+	return lv, nil
+}
+
+// diskOffset maps byte offset off of the logical volume to the disk, and
+// returns how many bytes from there on are contiguous, or -1 and 0 if off
+// is not in the volume.
+func (lv *logicalVolume) diskOffset(off int64) (int64, int64) {
+	for _, seg := range lv.segments {
+		if off < seg.start || off >= seg.start+seg.size {
+			continue
+		}
+		rel := off - seg.start
+		if len(seg.stripes) == 1 {
+			return seg.stripes[0] + rel, seg.size - rel
+		}
+		// chunks of stripeSize go to the stripes in turn
+		count := int64(len(seg.stripes))
+		chunk, in := rel/seg.stripeSize, rel%seg.stripeSize
+		n := seg.stripeSize - in
+		if rest := seg.size - rel; n > rest {
+			n = rest
+		}
+		return seg.stripes[chunk%count] + chunk/count*seg.stripeSize + in, n
+	}
+	return -1, 0
+}
+
+// lvReader reads a logical volume from the disk.
+type lvReader struct {
+	s   io.ReadSeeker // The disk.
+	lv  *logicalVolume
+	off int64
+}
+
+func (r *lvReader) Read(b []byte) (int, error) {
+	if r.off >= r.lv.size {
+		return 0, io.EOF
+	}
+	off, n := r.lv.diskOffset(r.off)
+	if off < 0 {
+		return 0, fmt.Errorf("offset %d of %v is not mapped", r.off, r.lv)
+	}
+	if int64(len(b)) > n {
+		b = b[:n]
+	}
+	if _, err := r.s.Seek(off, 0); err != nil {
+		return 0, err
+	}
+	m, err := r.s.Read(b)
+	r.off += int64(m)
+	return m, err
+}
+
+func (r *lvReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 1:
+		offset += r.off
+	case 2:
+		offset += r.lv.size
+	}
+	if offset < 0 {
+		return r.off, fmt.Errorf("negative offset %d", offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// DiskOffset implements ext4.DiskMapper, so that the manifest has offsets
+// on the disk for the files of logical volumes.
+func (r *lvReader) DiskOffset(off int64) int64 {
+	o, _ := r.lv.diskOffset(off)
+	return o
+}
+
+// lvmSection is a section of LVM text metadata, like a volume group or one
+// of its logical volumes: values (int64, string or []interface{} of those)
+// and subsections, by name.
+type lvmSection struct {
+	values   map[string]interface{}
+	sections map[string]*lvmSection
+	order    []string // Names of the subsections, in order.
+}
+
+// section returns the subsection name, an empty one if there is none.
+func (s *lvmSection) section(name string) *lvmSection {
+	if sub, ok := s.sections[name]; ok {
+		return sub
+	}
+	return &lvmSection{}
+}
+
+func (s *lvmSection) int(name string) int64 {
+	n, _ := s.values[name].(int64)
+	return n
+}
+
+func (s *lvmSection) string(name string) string {
+	v, _ := s.values[name].(string)
+	return v
+}
+
+func (s *lvmSection) list(name string) []interface{} {
+	v, _ := s.values[name].([]interface{})
+	return v
+}
+
+// parseLVMMetadata parses the text metadata of a volume group, like
+// vgcfgbackup writes it.
+func parseLVMMetadata(b []byte) (*lvmSection, error) {
+	p := &lvmParser{b: b}
+	s, err := p.section()
+	if err == nil && p.tok != "" {
+		err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	return s, err
+}
+
+type lvmParser struct {
+	b   []byte
+	tok string // The current token, "" at the end.
+	str bool   // Whether tok is a quoted string.
+}
+
+// next reads the next token: a punctuation character, a quoted string or a
+// word.
+func (p *lvmParser) next() error {
+	for len(p.b) > 0 {
+		if c := p.b[0]; c == '#' {
+			if i := bytes.IndexByte(p.b, '\n'); i >= 0 {
+				p.b = p.b[i:]
+			} else {
+				p.b = nil
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			p.b = p.b[1:]
+		} else {
+			break
+		}
+	}
+	p.tok, p.str = "", false
+	if len(p.b) == 0 {
+		return nil
+	}
+	switch c := p.b[0]; {
+	case strings.IndexByte("{}[]=,", c) >= 0:
+		p.tok, p.b = string(c), p.b[1:]
+	case c == '"':
+		var s []byte
+		for i := 1; i < len(p.b); i++ {
+			switch p.b[i] {
+			case '\\':
+				i++
+				if i < len(p.b) {
+					s = append(s, p.b[i])
+				}
+			case '"':
+				p.tok, p.str, p.b = string(s), true, p.b[i+1:]
+				return nil
+			default:
+				s = append(s, p.b[i])
+			}
+		}
+		return fmt.Errorf("unterminated string")
+	default:
+		i := 0
+		for i < len(p.b) && strings.IndexByte("{}[]=,\"# \t\r\n", p.b[i]) < 0 {
+			i++
+		}
+		p.tok, p.b = string(p.b[:i]), p.b[i:]
+	}
+	return nil
+}
+
+// section parses name = value and name { ... } lines up to the closing
+// brace or the end.
+func (p *lvmParser) section() (*lvmSection, error) {
+	s := &lvmSection{values: map[string]interface{}{}, sections: map[string]*lvmSection{}}
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok == "" || p.tok == "}" && !p.str {
+			return s, nil
+		}
+		name := p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch p.tok {
+		case "{":
+			sub, err := p.section()
+			if err != nil {
+				return nil, err
+			}
+			if p.tok != "}" {
+				return nil, fmt.Errorf("section %s is not closed", name)
+			}
+			s.sections[name] = sub
+			s.order = append(s.order, name)
+		case "=":
+			v, err := p.value()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			s.values[name] = v
+		default:
+			return nil, fmt.Errorf("unexpected %q after %s", p.tok, name)
+		}
+	}
+}
+
+// value parses a string, a number or a list of those.
+func (p *lvmParser) value() (interface{}, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok != "[" || p.str {
+		return p.scalar()
+	}
+	list := []interface{}{}
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok == "]" && !p.str {
+			return list, nil
+		}
+		if p.tok == "," && !p.str {
+			continue
+		}
+		v, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+func (p *lvmParser) scalar() (interface{}, error) {
+	if p.str {
+		return p.tok, nil
+	}
+	if p.tok == "" || strings.IndexByte("{}[]=,", p.tok[0]) >= 0 {
+		return nil, fmt.Errorf("unexpected %q", p.tok)
+	}
+	if n, err := strconv.ParseInt(p.tok, 10, 64); err == nil {
+		return n, nil
+	}
+	return p.tok, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testVGMetadata = `# Generated by LVM2 version 2.03.14(2) (2021-10-20): Sat Jan  2 15:04:05 2016
+
+contents = "Text Format Volume Group"
+version = 1
+
+description = ""
+
+creation_host = "test"	# Linux test 5.15.0 #1 SMP x86_64
+creation_time = 1451747045	# Sat Jan  2 15:04:05 2016
+
+rootvg {
+	id = "kXsR2y-0bWi-8JdN-3Y0d-vWmZ-4pFk-Hb7s2L"
+	seqno = 3
+	format = "lvm2"			# informational
+	status = ["RESIZEABLE", "READ", "WRITE"]
+	flags = []
+	extent_size = 2048		# 1 Megabytes
+	max_lv = 0
+	max_pv = 0
+	metadata_copies = 0
+
+	physical_volumes {
+
+		pv0 {
+			id = "Tq1tD3-aG8b-u2Wc-Xk3j-Ue5N-zPd1-Qa0r9F"
+			device = "/dev/sda2"	# Hint only
+
+			status = ["ALLOCATABLE"]
+			flags = []
+			dev_size = 18432	# 9 Megabytes
+			pe_start = 2048
+			pe_count = 8	# 8 Megabytes
+		}
+	}
+
+	logical_volumes {
+
+		rootlv {
+			id = "d8Kp1q-Zr4v-Nn2s-8Gx0-bQ7m-Lw3e-Yt6uHc"
+			status = ["READ", "WRITE", "VISIBLE"]
+			flags = []
+			creation_time = 1451747045	# 2016-01-02 15:04:05 +0000
+			creation_host = "test"
+			segment_count = 2
+
+			segment1 {
+				start_extent = 0
+				extent_count = 4	# 4 Megabytes
+
+				type = "striped"
+				stripe_count = 1	# linear
+
+				stripes = [
+					"pv0", 4
+				]
+			}
+			segment2 {
+				start_extent = 4
+				extent_count = 4	# 4 Megabytes
+
+				type = "striped"
+				stripe_count = 1	# linear
+
+				stripes = [
+					"pv0", 0
+				]
+			}
+		}
+
+		datalv {
+			id = "Fq2mWx-7dLk-Pz9e-Rr1a-Ht5b-Ks3n-Vc8yUo"
+			status = ["READ", "WRITE", "VISIBLE"]
+			flags = []
+			segment_count = 1
+
+			segment1 {
+				start_extent = 0
+				extent_count = 4
+
+				type = "thin"
+				thin_pool = "pool"
+				transaction_id = 1
+				device_id = 1
+			}
+		}
+	}
+
+}
+`
+
+// lvmTestDisk returns a disk with an LVM physical volume on partition 1,
+// which holds rootvg/rootlv with the test ext4 image on it, its halves
+// swapped on the physical volume. The metadata wraps around the end of its
+// metadata area.
+func lvmTestDisk(t *testing.T) []byte {
+	f, err := os.Open("ext4/testdata/ext4.img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		mb       = 1 << 20
+		partLBA  = 2048
+		partSize = 9 * mb
+		mdaStart = 4096
+		mdaSize  = mb - mdaStart
+	)
+	disk := make([]byte, partLBA*512+partSize)
+	le := binary.LittleEndian
+
+	// MBR, with an LVM partition as the second entry
+	entry := disk[446+16:]
+	entry[4] = 0x8e
+	le.PutUint32(entry[8:], partLBA)
+	le.PutUint32(entry[12:], partSize/512)
+	disk[510], disk[511] = 0x55, 0xaa
+
+	pv := disk[partLBA*512:]
+	label := pv[512:]
+	copy(label, "LABELONE")
+	le.PutUint64(label[8:], 1)
+	le.PutUint32(label[20:], 32)
+	copy(label[24:], "LVM2 001")
+	h := label[32:]
+	copy(h, "Tq1tD3aG8bu2WcXk3jUe5NzPd1Qa0r9F")
+	le.PutUint64(h[32:], partSize)
+	le.PutUint64(h[40:], mb) // data area, then the end of the list
+	le.PutUint64(h[72:], mdaStart)
+	le.PutUint64(h[80:], mdaSize)
+
+	mda := pv[mdaStart:]
+	copy(mda[4:], lvmMDAMagic)
+	le.PutUint32(mda[20:], 1)
+	le.PutUint64(mda[24:], mdaStart)
+	le.PutUint64(mda[32:], mdaSize)
+	text := append([]byte(testVGMetadata), 0)
+	textOffset := mdaSize - 100
+	le.PutUint64(mda[40:], uint64(textOffset))
+	le.PutUint64(mda[48:], uint64(len(text)))
+	copy(mda[textOffset:mdaSize], text)
+	copy(mda[lvmMDAHeader:], text[100:])
+
+	copy(pv[mb+4*mb:], img[:4*mb])
+	copy(pv[mb:], img[4*mb:])
+	return disk
+}
+
+func TestLogicalVolumes(t *testing.T) {
+	defer func(w io.Writer) { diag = w }(diag)
+	diag = ioutil.Discard
+	defer func(w []string) { warnings = w }(warnings)
+	warnings = nil
+
+	d, err := openDisk(bytes.NewReader(lvmTestDisk(t)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.pvs) != 1 || len(d.lvs) != 1 || len(d.volumes) != 1 {
+		t.Fatalf("%d physical volumes, %d logical volumes, %d filesystems; expected one each", len(d.pvs), len(d.lvs), len(d.volumes))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "rootvg/datalv") {
+		t.Errorf("warnings %q, expected one about the thin volume rootvg/datalv", warnings)
+	}
+
+	for _, sel := range []string{"", "rootvg/rootlv", "rootlv", "/dev/mapper/rootvg-rootlv", "/dev/rootvg/rootlv", "test"} {
+		v, err := d.selectVolume(sel)
+		if err != nil {
+			t.Errorf("selectVolume(%q): %v", sel, err)
+			continue
+		}
+		if v.lv != d.lvs[0] || v.num != 1 || v.dir() != "rootvg-rootlv" {
+			t.Errorf("selectVolume(%q) = %v", sel, v)
+		}
+	}
+	for _, sel := range []string{"1", "datalv", "otherlv"} {
+		if v, err := d.selectVolume(sel); err == nil {
+			t.Errorf("selectVolume(%q) = %v, expected an error", sel, v)
+		}
+	}
+
+	root, err := d.selectRoot("rootlv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := openFile(root, "/var/log/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("1\n2\n3\n")) || !bytes.HasSuffix(b, []byte("\n3000\n")) {
+		t.Errorf("/var/log/messages is %d bytes that differ from what was written", len(b))
+	}
+
+	// the first extent of the volume is the fifth of the physical volume
+	if off := d.volumes[0].r.DiskOffset(0); off != (2048+2048+4*2048)*512 {
+		t.Errorf("DiskOffset(0) = %d", off)
+	}
+}
+
+func TestStripedLogicalVolume(t *testing.T) {
+	lv := &logicalVolume{size: 8 << 10, segments: []lvSegment{
+		{start: 0, size: 8 << 10, stripeSize: 1 << 10, stripes: []int64{100 << 10, 200 << 10}},
+	}}
+	for _, test := range []struct{ off, disk, n int64 }{
+		{0, 100 << 10, 1 << 10},
+		{1<<10 + 5, 200<<10 + 5, 1<<10 - 5},
+		{2 << 10, 101 << 10, 1 << 10},
+		{7<<10 + 1023, 203<<10 + 1023, 1},
+		{8 << 10, -1, 0},
+	} {
+		if disk, n := lv.diskOffset(test.off); disk != test.disk || n != test.n {
+			t.Errorf("diskOffset(%d) = %d, %d, expected %d, %d", test.off, disk, n, test.disk, test.n)
+		}
+	}
+}
+
+func TestParseLVMMetadata(t *testing.T) {
+	meta, err := parseLVMMetadata([]byte(testVGMetadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vg := meta.section("rootvg")
+	if meta.string("contents") != "Text Format Volume Group" || vg.int("extent_size") != 2048 || vg.int("seqno") != 3 {
+		t.Errorf("parsed %+v", meta)
+	}
+	lvs := vg.section("logical_volumes")
+	if strings.Join(lvs.order, " ") != "rootlv datalv" {
+		t.Errorf("logical volumes %q", lvs.order)
+	}
+	stripes := lvs.section("rootlv").section("segment1").list("stripes")
+	if len(stripes) != 2 || stripes[0] != "pv0" || stripes[1] != int64(4) {
+		t.Errorf("stripes %v", stripes)
+	}
+
+	for _, bad := range []string{"vg {", "vg { a = }", "a = \"x", "vg } }"} {
+		if _, err := parseLVMMetadata([]byte(bad)); err == nil {
+			t.Errorf("parseLVMMetadata(%q) succeeded", bad)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"io"
-	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"flag"
 	"fmt"
@@ -18,130 +20,418 @@ const (
 )
 
 var (
-	help      bool
-	ouputPath string
+	help       bool
+	ouputPath  string
+	superblock int64
+	blocksize  int64
+	excludes   stringList
+	rules      ruleList
+	profiles   stringList
+	maxFiles   int
+	cacheSize  int64
+
+	archivePath   string
+	archiveFormat string
+	reportPath    string
+	uploadURL     string
+	stdout        io.Writer // Where -archive - or -report - goes, as os.Stdout is then stderr.
 )
 
 func init() {
 	flag.BoolVar(&help, "help", false, "Prints this help.")
 	flag.StringVar(&ouputPath, "outputPath", "out", "Specifies the path where logs and files are placed.")
+	flag.Int64Var(&superblock, "superblock", 0, "Use the backup superblock at this block number instead of the primary one (like e2fsck -b).")
+	flag.Int64Var(&blocksize, "blocksize", 0, "Block size to use with -superblock; all sizes are tried if not set.")
+	flag.Var(&excludes, "exclude", "Pattern of files not to download, e.g. \"/var/log/journal/**\". Can be repeated.")
+	flag.Var(&rules, "file", "Additional pattern of files to download, optionally only in part: PATTERN[;tail=SIZE][;lines=N][;since=TIME][;until=TIME][;maxsize=SIZE][;follow=false]. Can be repeated; the first pattern matching a file decides.")
+	flag.Var(&profiles, "profile", "Profile of files to download: auto, the one for the distribution found (the default), "+strings.Join(builtinProfileNames(), ", ")+", or a YAML or JSON file of profiles. Can be repeated or comma separated.")
+	flag.IntVar(&maxFiles, "maxfiles", 10000, "Maximum number of files to download per filesystem.")
+	flag.StringVar(&archivePath, "archive", "", "Write the collected files into this tar, tar.gz or zip archive, by extension, instead of -outputPath; - writes to stdout.")
+	flag.StringVar(&archiveFormat, "format", "", "Format of the -archive: tar, tgz or zip. By default taken from its extension, tar for stdout.")
+	flag.StringVar(&uploadURL, "upload", "", "Upload the collected files as an archive to the blob container with this SAS URL (Azure or an emulator like Azurite), named as -archive or after the disk.")
+	flag.StringVar(&reportPath, "report", "", "Also write the JSON report of the disk, its filesystems and the collected files to this file; - writes to stdout.")
+	flag.Int64Var(&cacheSize, "cache", 64, "MiB of disk blocks to keep in memory, so that metadata read again needs no further requests.")
 }
 
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	if flag.NArg() != 1 || help {
-		fmt.Printf("Usage: ./inspect-remote-vhd <vhd-read-uri>\n")
-		flag.PrintDefaults()
+	if flag.NArg() == 0 || help {
+		usage()
 		return
 	}
 
-	uri := flag.Arg(0)
+	cmd, args := findCommand(flag.Arg(0)), flag.Args()[1:]
+	if cmd == nil {
+		if flag.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+			usage()
+			os.Exit(2)
+		}
+		// just the URI, as before there were commands
+		cmd, args = findCommand("collect"), flag.Args()
+	}
+	if uploadURL != "" && archivePath == "-" {
+		fmt.Fprintf(os.Stderr, "-archive - cannot be combined with -upload, use -format to choose the archive format\n")
+		os.Exit(2)
+	}
+	if archivePath == "-" && reportPath == "-" {
+		fmt.Fprintf(os.Stderr, "Only one of -archive and -report can write to stdout\n")
+		os.Exit(2)
+	}
+	if archivePath == "-" || reportPath == "-" {
+		// keep the progress messages out of the archive or report
+		stdout, os.Stdout = os.Stdout, os.Stderr
+	}
+	diag = os.Stdout
+	if cmd.name != "collect" {
+		diag = os.Stderr
+	}
+	cacheSize <<= 20
+	if err := runCommand(cmd, args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERR: %v\n", err)
+		os.Exit(1)
+	}
+}
 
-	s := SasPageBlobAccessor(uri)
+// collectCmd downloads the files of the profiles, and those given with
+// -file, from every filesystem on the disk.
+func collectCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	return func(s *session, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		names := profiles
+		if len(names) == 0 {
+			names = stringList{autoProfile}
+		}
+		// fail on unknown profiles before reading anything
+		if _, err := profileRules(names, nil); err != nil {
+			return err
+		}
+		d, err := openDisk(s.src, true)
+		if err != nil {
+			return err
+		}
+		s.d = d
 
-	fmt.Printf("Reading partition table...\n")
-	// location of MBR partition table http://en.wikipedia.org/wiki/Master_boot_record#Sector_layout
-	partitions, err := readPartitionTable(s)
-	if err != nil {
-		panic(err)
+		if ns := d.ns; ns != nil {
+			fmt.Printf("Root filesystem is on %v\n", ns.root)
+			for _, v := range d.volumes {
+				if v.mountedOn != "" && v != ns.root {
+					fmt.Printf("  %s is %v\n", v.mountedOn, v)
+				}
+			}
+			// whether that keeps the VM from booting is for the boot analyzer
+			// to say, as they may be on other disks or not ext2/3/4
+			for _, e := range ns.unmatched {
+				fmt.Printf("  %s is not mounted, %s is not an ext2/3/4 filesystem on this disk\n", e.File, e.Spec)
+			}
+		}
+
+		out, err := openOutput(ouputPath, s.uri)
+		if err != nil {
+			return err
+		}
+		c, err := newCollection(s, out)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		// volumes mounted in the root's namespace are collected through it, all
+		// others on their own
+		var collectErr error
+		for _, v := range d.volumes {
+			if v.mountedOn != "" && v.mountedOn != "/" {
+				continue
+			}
+			root, err := v.r.Root()
+			if err != nil {
+				err = fmt.Errorf("%s: %v", v.device(), err)
+				c.report.Error = err.Error()
+				c.close()
+				return err
+			}
+			dist := detectDistro(root)
+			printDistro(os.Stdout, dist)
+			c.report.setDistro(v, dist)
+			pr, err := profileRules(names, dist)
+			if err != nil {
+				c.close()
+				return err
+			}
+			if err := collectFiles(c, root, v.dir(), append(rules, pr...)); err != nil {
+				c.report.Error = err.Error()
+				collectErr = err
+				break
+			}
+		}
+		// what was collected is still analyzed and archived, with the error in
+		// the report, which is printed on exit
+		fmt.Printf("Looking for problems...\n")
+		findings, err := analyze(d, nil)
+		if err != nil {
+			c.close()
+			return err
+		}
+		printFindings(os.Stdout, findings)
+		c.report.Findings = findings
+		if err := c.close(); collectErr == nil {
+			collectErr = err
+		}
+		return collectErr
 	}
+}
 
-	for partitionNum, p := range partitions {
-		fmt.Printf("Inspecting filesystem on partition %d...\n", partitionNum)
-		// assume that partition 0 is linux with ext4
-		if p.Type != 0x83 {
-			fmt.Printf("Not a linux partition (%d), skipping!\n", p.Type)
-			continue
+// collectFiles downloads the files matching rules below fs into the
+// collection, below prefix. Files matched by more than one rule are
+// downloaded once, as the first matching rule says.
+func collectFiles(c *collection, fs ext4.Directory, prefix string, rules []collectRule) error {
+	// match all rules first, to tell which ones each file matched
+	type match struct {
+		f     ext4.DirEntry
+		rules []collectRule
+	}
+	var matches []*match
+	byName := map[string]*match{}
+	for _, rule := range rules {
+		files, err := fs.MatchAll([]string{rule.Pattern}, ext4.MatchOptions{
+			Exclude:  append(append([]string{}, excludes...), rule.Exclude...),
+			MaxDepth: 16,
+			MaxFiles: maxFiles,
+		})
+		if err != nil && err != ext4.ErrTooManyMatches {
+			return err
 		}
+		for _, f := range files {
+			if m, ok := byName[f.Fullname()]; ok {
+				m.rules = append(m.rules, rule)
+				continue
+			}
+			m := &match{f: f, rules: []collectRule{rule}}
+			byName[f.Fullname()] = m
+			matches = append(matches, m)
+		}
+	}
 
-		r, err := ext4.NewReader(s, p.LBAfirst, p.Sectors)
-		if err == ext4.ErrNotExt4 {
-			fmt.Printf("Filesystem is not ext4 compatible, skipping!\n")
-			continue
+	// the report goes last, so that it also covers files that failed
+	var report bytes.Buffer
+	defer func() {
+		if err := c.out.writeData(path.Join(prefix, "metadata.txt"), report.Bytes()); err != nil {
+			warnf("could not write metadata report: %v", err)
 		}
-		if err != nil {
-			panic(err)
+	}()
+
+	fmt.Printf("Downloading interesting files...\n")
+	for i, m := range matches {
+		if i >= maxFiles {
+			warnf("more than %d files match, only downloading the first ones", maxFiles)
+			return nil
 		}
+		if err := collectFile(c, m.f, m.rules, prefix, &report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		globs := []string{
-			"/etc/ssh*/*",
-			"/etc/ssh*",
-			"/etc/fstab",
-			"/etc/passwd",
-			"/etc/mtab",
-			"/etc/waagent.conf",
-			"/var/log/messages*",
-			"/var/log/boot*",
-			"/var/log/dmesg*",
-			"/var/log/syslog*",
-			"/var/log/waagent/*",
-			"/var/log/waagent/*/*",
-			"/var/log/waagent/*/*/*",
-			"/var/log/waagent*",
-			"/var/log/walinuxagent/*",
-			"/var/log/walinuxagent/*/*",
-			"/var/log/walinuxagent/*/*/*",
-			"/var/log/walinuxagent*",
-			"/var/log/azure/*",
-			"/var/log/azure/*/*",
-			"/var/log/azure/*/*/*",
-			"/var/log/azure/*/*/*/*",
-			"/var/log/*",
-			"/boot/grub/*cfg",
-			"/grub/*cfg",
+// collectFile downloads the file or symlink target f as the first of the
+// rules that matched it says. Only errors writing the output are returned,
+// those reading f are warned about.
+func collectFile(c *collection, f ext4.DirEntry, matched []collectRule, prefix string, report io.Writer) error {
+	rule := matched[0]
+	var names []string
+	for _, r := range matched {
+		names = append(names, r.String())
+	}
+	notes := []string{"rules: " + strings.Join(names, "; ")}
+	name := path.Join(prefix, f.Fullname())
+	rf := reportFile{DiskPath: f.Fullname(), Type: f.FileType.String(), Rules: names}
+	defer func() { c.record(rf) }()
+
+	special := f.FileType == ext4.FileTypeChardev || f.FileType == ext4.FileTypeBlockdev || f.FileType == ext4.FileTypeFIFO
+	if (f.FileType == ext4.FileTypeSymlink && rule.NoFollow) || special {
+		fi, err := readFileInfo(f.Reader(), f.Inode)
+		if err != nil {
+			warnf("could not read inode %d (%s): %v", f.Inode, f.Fullname(), err)
+			rf.Status, rf.Reason = "failed", err.Error()
+			return nil
+		}
+		target := ""
+		if f.FileType == ext4.FileTypeSymlink {
+			if target, err = f.ReadSymlink(); err != nil {
+				warnf("could not read symlink %s: %v", f.Fullname(), err)
+				rf.Status, rf.Reason = "failed", err.Error()
+				return nil
+			}
+			notes = append(notes, "symlink: "+target)
+		}
+		fmt.Printf("   %s (%s) [%s]\n", f.Fullname(), f.FileType, strings.Join(names, "; "))
+		if target != "" {
+			fmt.Printf("     \\-> not following symlink to %s\n", target)
 		}
+		rf.Size = int64(fi.inode.Size())
+		if err := c.out.writeSpecial(name, fi, target); err != nil {
+			if _, ok := err.(*outputError); ok {
+				rf.Status, rf.Reason = "failed", err.Error()
+				return err
+			}
+			warnf("could not keep %s: %v", f.Fullname(), err)
+			rf.Status, rf.Reason = "skipped", err.Error()
+		} else {
+			c.add(name, f.Fullname(), f.Reader(), f.Inode, fi, target, 0, 0, false, nil)
+			rf.Path, rf.Status = name, "collected"
+		}
+		if err := writeFileMetadata(report, f.Reader(), f.Fullname(), f.Inode, fi.inode, notes...); err != nil {
+			warnf("could not read metadata for %s: %v", f.Fullname(), err)
+		}
+		return nil
+	}
 
-		fs, err := r.Root()
+	var err error
+	orig := f
+	if f.FileType == ext4.FileTypeSymlink {
+		f, err = f.ResolveSymlink()
 		if err != nil {
-			panic(err)
+			warnf("failed to resolve symlink %v", err)
+			rf.Status, rf.Reason = "failed", err.Error()
+			return nil
 		}
+	}
+	if f.FileType != ext4.FileTypeFile {
+		rf.Status, rf.Reason = "skipped", fmt.Sprintf("%s, not a regular file", f.FileType)
+		return nil
+	}
+	// the file, or the target of the symlink, may be on another
+	// filesystem mounted in the tree
+	fr := f.Reader()
+	fi, err := readFileInfo(fr, f.Inode)
+	if err != nil {
+		warnf("could not read inode %d (%s -> %s): %v", f.Inode, orig.Fullname(), f.Fullname(), err)
+		rf.Status, rf.Reason = "failed", err.Error()
+		return nil
+	}
+	inode := fi.inode
+	ir, err := fr.GetInodeReader(inode)
+	if err != nil {
+		warnf("could not read data for %s: %v", orig.Fullname(), err)
+		rf.Status, rf.Reason = "failed", err.Error()
+		return nil
+	}
+	rf.Size = ir.Size()
+
+	fmt.Printf("   %s (%s) [%s]\n", orig.Fullname(), orig.FileType, strings.Join(names, "; "))
+	if rule.MaxSize > 0 && ir.Size() > rule.MaxSize {
+		fmt.Printf("     \\-> not downloading %d bytes, more than the limit of %d\n", ir.Size(), rule.MaxSize)
+		rf.Status, rf.Reason = "skipped", fmt.Sprintf("%d bytes, more than the limit of %d", ir.Size(), rule.MaxSize)
+		if err := writeFileMetadata(report, fr, orig.Fullname(), f.Inode, inode, append(notes, fmt.Sprintf("skipped: %d bytes, more than %d", ir.Size(), rule.MaxSize))...); err != nil {
+			warnf("could not read metadata for %s: %v", orig.Fullname(), err)
+		}
+		return nil
+	}
 
-		fmt.Printf("Downloading interesting files...\n")
-		for _, glob := range globs {
-			files, err := fs.Match(glob)
+	start, end := int64(0), ir.Size()
+	if rule.sliced() {
+		start, end, err = rule.sliceRange(ir, ir.Size(), inode.ModTime())
+		if err != nil {
+			warnf("cannot select by time in %s: %v", orig.Fullname(), err)
+			rule.Since, rule.Until = time.Time{}, time.Time{}
+			start, end, err = rule.sliceRange(ir, ir.Size(), inode.ModTime())
 			if err != nil {
-				panic(err)
+				warnf("could not read data for %s: %v", orig.Fullname(), err)
+				rf.Status, rf.Reason = "failed", err.Error()
+				return nil
 			}
-			for _, f := range files {
-				orig := f
-				for f.FileType == ext4.FileTypeSymlink {
-					f, err = f.ResolveSymlink()
-					if err != nil {
-						fmt.Printf("WARN: failed to resolve symlink %s: %v\n", orig.Fullname(), err)
-						continue
-					}
-				}
-				if f.FileType != ext4.FileTypeFile {
-					continue
-				}
-				inode, err := r.GetInode(f.Inode)
-				if err != nil {
-					fmt.Printf("WARN: could not read inode %d (%s -> %s): %v\n", f.Inode, orig.Fullname(), f.Fullname(), err)
-					continue
-				}
+		}
+		notes = append(notes, fmt.Sprintf("slice: bytes %d-%d of %d", start, end, ir.Size()))
+	}
 
-				fmt.Printf("   %s (%s) \n", orig.Fullname(), orig.FileType)
-				fmt.Printf("     \\-> downloading %d bytes\n", inode.Size())
+	if end-start < ir.Size() {
+		fmt.Printf("     \\-> downloading %d of %d bytes, from offset %d\n", end-start, ir.Size(), start)
+	} else {
+		fmt.Printf("     \\-> downloading %d bytes\n", inode.Size())
+	}
 
-				data, err := r.GetInodeContent(inode)
-				if err != nil {
-					fmt.Printf("WARN: could not read data for %s: %s", orig.Fullname(), err)
-					continue
-				}
+	h := newManifestHashes()
+	n, err := c.out.writeFile(name, fi, hashingReader{ir, h}, start, end)
+	if err != nil {
+		rf.Status, rf.Reason = "failed", err.Error()
+		switch err := err.(type) {
+		case *outputError:
+			return err
+		case *partialError:
+			warnf("partial file %s: %v", orig.Fullname(), err)
+			notes = append(notes, fmt.Sprintf("partial: only %d of %d bytes", err.written, err.size))
+			rf.Status = "partial"
+		default:
+			warnf("could not download %s: %v", orig.Fullname(), err)
+			return nil
+		}
+	} else {
+		rf.Status = "collected"
+	}
+	c.add(name, orig.Fullname(), fr, f.Inode, fi, "", start, n, err != nil, h)
+	rf.Path, rf.Offset, rf.Length = name, start, n
+	rf.SHA256, _, _ = h.sums()
+	if err := writeFileMetadata(report, fr, orig.Fullname(), f.Inode, inode, notes...); err != nil {
+		warnf("could not read metadata for %s: %v", orig.Fullname(), err)
+	}
+	return nil
+}
 
-				outFile := ouputPath + fmt.Sprintf("/%d/", partitionNum) + fixFilename(orig.Fullname())
-				if err := os.MkdirAll(path.Dir(outFile), 0777); err != nil {
-					fmt.Printf("ERR: could not create path %s: %s", path.Dir(outFile), err)
-					return
-				}
-				err = ioutil.WriteFile(outFile, data, 0666)
-				if err != nil {
-					fmt.Printf("ERR: could not write file %s: %s", path.Dir(outFile), err)
-					return
-				}
-			}
+// readFileInfo reads inode n and its extended attributes.
+func readFileInfo(r *ext4.Reader, n uint32) (fileInfo, error) {
+	inode, err := r.GetInode(n)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	xattrs, err := r.Xattrs(n)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	return fileInfo{inode: inode, xattrs: xattrs}, nil
+}
+
+// openFilesystem opens the ext4 filesystem on partition p, falling back to
+// the superblock backups if the checksum of the primary superblock does not
+// match. A partition without an ext4 superblock may well hold another
+// filesystem, so backups are only used for it with -superblock.
+func openFilesystem(s io.ReadSeeker, p partitionEntry) (ext4.Reader, error) {
+	if superblock != 0 {
+		fmt.Fprintf(diag, "Using superblock at block %d...\n", superblock)
+		return ext4.NewReaderFromBackup(s, p.LBAfirst, p.Sectors, ext4.SuperBlockLocation{
+			Block:     superblock,
+			BlockSize: blocksize,
+		})
+	}
+
+	r, err := ext4.NewReader(s, p.LBAfirst, p.Sectors)
+	if err == ext4.ErrNotExt4 {
+		if locs := ext4.ProbeBackupSuperBlocks(s, p.LBAfirst, p.Sectors); len(locs) > 0 {
+			warnf("no ext4 superblock, but a backup is in group %d; if the primary superblock was overwritten, use -superblock %d -blocksize %d",
+				locs[0].Group, locs[0].Block, locs[0].BlockSize)
+		}
+		return r, err
+	}
+	if err != ext4.ErrSuperBlockChecksum {
+		return r, err
+	}
+	for _, loc := range ext4.ProbeBackupSuperBlocks(s, p.LBAfirst, p.Sectors) {
+		br, berr := ext4.NewReaderFromBackup(s, p.LBAfirst, p.Sectors, loc)
+		if berr != nil {
+			continue
 		}
+		warnf("primary superblock is unusable (%v), using backup in group %d (-superblock %d -blocksize %d)",
+			err, loc.Group, loc.Block, loc.BlockSize)
+		return br, nil
 	}
+	return r, err
 }
 
 func SasPageBlobAccessor(url string) io.ReadSeeker {
@@ -165,7 +455,7 @@ func (b *readSeekablePageBlob) Read(buffer []byte) (n int, err error) {
 		return
 	}
 	req.Header.Set("x-ms-version", apiVersion)
-	req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", b.offset, b.offset+int64(len(buffer))))
+	req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", b.offset, b.offset+int64(len(buffer))-1))
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -194,7 +484,7 @@ func (b *readSeekablePageBlob) Seek(offset int64, whence int) (int64, error) {
 		return 0, fmt.Errorf("Cannot seek with negative offset: %d", offset)
 	}
 	if whence < 0 || whence > 2 {
-		return 0, fmt.Errorf("Illegal value for parameter whence: %s", whence)
+		return 0, fmt.Errorf("Illegal value for parameter whence: %d", whence)
 	}
 
 	switch whence {
@@ -259,6 +549,8 @@ func (b readSeekablePageBlob) getProperties() (storage.BlobProperties, error) {
 
 	rv.BlobType = storage.BlobType(res.Header.Get("x-ms-blob-type"))
 	fmt.Sscanf(res.Header.Get("Content-Length"), "%d", &rv.ContentLength)
+	rv.Etag = res.Header.Get("ETag")
+	rv.LastModified = res.Header.Get("Last-Modified")
 	return rv, nil
 }
 
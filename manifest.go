@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+const manifestVersion = 1
+
+// manifest records what was collected from which disk, with hashes of the
+// data, so that the results can be shown to be what the disk held.
+type manifest struct {
+	Version      int            `json:"version"`
+	Source       string         `json:"source"` // URL of the blob, without the signature.
+	ETag         string         `json:"etag"`
+	LastModified string         `json:"last_modified"`
+	DiskSize     int64          `json:"disk_size"`
+	Collected    time.Time      `json:"collected"` // When collecting started.
+	Files        []manifestFile `json:"files"`
+}
+
+// manifestFile is a collected file. Only regular files have data and hashes.
+type manifestFile struct {
+	Path           string           `json:"path"`                     // Name in the output.
+	DiskPath       string           `json:"disk_path"`                // Path on the disk; for followed symlinks that of the link.
+	Partition      int              `json:"partition"`                // Of the inode, which may differ from that of the path for mounted filesystems.
+	LogicalVolume  string           `json:"logical_volume,omitempty"` // VG/LV of the inode, for filesystems on LVM.
+	FilesystemUUID string           `json:"filesystem_uuid"`
+	Inode          uint32           `json:"inode"`
+	Type           string           `json:"type"`
+	Size           int64            `json:"size"`   // Size on the disk.
+	Offset         int64            `json:"offset"` // Of the collected bytes in the file.
+	Length         int64            `json:"length"` // Number of bytes collected.
+	Partial        bool             `json:"partial,omitempty"`
+	Mode           string           `json:"mode"`
+	UID            uint32           `json:"uid"`
+	GID            uint32           `json:"gid"`
+	Atime          time.Time        `json:"atime"`
+	Mtime          time.Time        `json:"mtime"`
+	Ctime          time.Time        `json:"ctime"`
+	Crtime         *time.Time       `json:"crtime,omitempty"`
+	SymlinkTarget  string           `json:"symlink_target,omitempty"`
+	Extents        []manifestExtent `json:"extents,omitempty"`
+	SHA256         string           `json:"sha256,omitempty"`
+	SHA1           string           `json:"sha1,omitempty"`
+	MD5            string           `json:"md5,omitempty"`
+}
+
+type manifestExtent struct {
+	Logical       int64 `json:"logical"`     // First block in the file.
+	Physical      int64 `json:"physical"`    // First block in the filesystem.
+	Blocks        int64 `json:"blocks"`      // Number of blocks.
+	DiskOffset    int64 `json:"disk_offset"` // Byte offset of the first block on the disk. On LVM, the extent may continue elsewhere past the end of a segment.
+	Uninitialized bool  `json:"uninitialized,omitempty"`
+}
+
+func (e manifestExtent) String() string {
+	s := fmt.Sprintf("%d+%d@%d", e.Logical, e.Blocks, e.Physical)
+	if e.Uninitialized {
+		s += "u"
+	}
+	return s
+}
+
+// manifestHashes computes the hashes of the manifest in one pass.
+type manifestHashes struct {
+	sha256, sha1, md5 hash.Hash
+	io.Writer
+}
+
+func newManifestHashes() *manifestHashes {
+	h := &manifestHashes{sha256: sha256.New(), sha1: sha1.New(), md5: md5.New()}
+	h.Writer = io.MultiWriter(h.sha256, h.sha1, h.md5)
+	return h
+}
+
+func (h *manifestHashes) sums() (sha256, sha1, md5 string) {
+	return hex.EncodeToString(h.sha256.Sum(nil)), hex.EncodeToString(h.sha1.Sum(nil)), hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// hashingReader passes the data read from an InodeReader on to w as well.
+type hashingReader struct {
+	ext4.InodeReader
+	w io.Writer
+}
+
+func (r hashingReader) Read(p []byte) (int, error) {
+	n, err := r.InodeReader.Read(p)
+	r.w.Write(p[:n])
+	return n, err
+}
+
+func (r hashingReader) WriteTo(w io.Writer) (int64, error) {
+	return r.InodeReader.WriteTo(io.MultiWriter(w, r.w))
+}
+
+// collection is where collected files go and what is recorded of them.
+type collection struct {
+	out      output
+	d        *disk
+	manifest manifest
+	report   report
+}
+
+// newCollection starts a collection from the disk of s into out.
+func newCollection(s *session, out output) (*collection, error) {
+	d, err := s.disk()
+	if err != nil {
+		return nil, err
+	}
+	c := &collection{out: out, d: d}
+	c.manifest = manifest{
+		Version:   manifestVersion,
+		Source:    redactURL(s.uri),
+		Collected: time.Now().UTC(),
+		Files:     []manifestFile{},
+	}
+	c.report = report{
+		Version:     reportVersion,
+		Started:     c.manifest.Collected,
+		Disk:        reportDisk{Source: c.manifest.Source},
+		Partitions:  []reportPartition{},
+		Filesystems: []reportFilesystem{},
+		Files:       []reportFile{},
+		Findings:    []finding{},
+	}
+	if props, err := (&readSeekablePageBlob{url: s.uri}).getProperties(); err == nil {
+		c.manifest.ETag = props.Etag
+		c.manifest.LastModified = props.LastModified
+		c.manifest.DiskSize = props.ContentLength
+		c.report.Disk.ETag, c.report.Disk.Size = props.Etag, props.ContentLength
+		if c.report.Disk.Format, err = containerFormat(s.src, props.ContentLength); err != nil {
+			warnf("could not read the VHD footer: %v", err)
+		}
+	} else {
+		warnf("could not read blob properties for the manifest: %v", err)
+	}
+	c.report.describeDisk(d)
+	return c, nil
+}
+
+// add records the file or special file name collected from r. Hashes are
+// recorded if h is not nil.
+func (c *collection) add(name, diskPath string, r *ext4.Reader, ino uint32, fi fileInfo, target string, offset, length int64, partial bool, h *manifestHashes) {
+	inode := fi.inode
+	f := manifestFile{
+		Path:          name,
+		DiskPath:      diskPath,
+		Partition:     -1,
+		Inode:         ino,
+		Type:          inode.Mode.FileType().String(),
+		Size:          int64(inode.Size()),
+		Offset:        offset,
+		Length:        length,
+		Partial:       partial,
+		Mode:          fmt.Sprintf("%04o", uint16(inode.Mode)&07777),
+		UID:           inode.UID(),
+		GID:           inode.GID(),
+		Atime:         inode.AccessTime().UTC(),
+		Mtime:         inode.ModTime().UTC(),
+		Ctime:         inode.ChangeTime().UTC(),
+		SymlinkTarget: target,
+	}
+	if crtime := inode.BirthTime(); !crtime.IsZero() {
+		crtime = crtime.UTC()
+		f.Crtime = &crtime
+	}
+	// readers are passed around by value, the volume is told by where its
+	// filesystem starts
+	for _, v := range c.d.volumes {
+		if v.r.DiskOffset(0) == r.DiskOffset(0) {
+			f.Partition, f.LogicalVolume = v.num, lvName(v)
+		}
+	}
+	f.FilesystemUUID = r.SuperBlock().UUID.String()
+	if h != nil {
+		f.SHA256, f.SHA1, f.MD5 = h.sums()
+		extents, err := r.DataExtents(inode)
+		if err != nil {
+			warnf("could not read extents of %s for the manifest: %v", diskPath, err)
+		}
+		for _, x := range extents {
+			f.Extents = append(f.Extents, manifestExtent{
+				Logical:       int64(x.Block),
+				Physical:      x.Start(),
+				Blocks:        int64(x.Length()),
+				DiskOffset:    r.DiskOffset(x.Start()),
+				Uninitialized: x.Uninitialized(),
+			})
+		}
+	}
+	c.manifest.Files = append(c.manifest.Files, f)
+}
+
+// record adds a file one of the rules matched to the report.
+func (c *collection) record(f reportFile) {
+	c.report.Files = append(c.report.Files, f)
+}
+
+// close writes the manifest, as JSON and as CSV, and the report, and closes
+// the output. The report is also written to -report, if set.
+func (c *collection) close() error {
+	c.report.Finished = time.Now().UTC()
+	c.report.Warnings = append([]string{}, warnings...)
+	c.report.Error = redactURLs(c.report.Error)
+	b, err := json.MarshalIndent(c.manifest, "", "  ")
+	if err == nil {
+		err = c.out.writeData("manifest.json", append(b, '\n'))
+	}
+	if err == nil {
+		err = c.out.writeData("manifest.csv", c.manifest.csv())
+	}
+	rb, rerr := c.report.marshal()
+	if err == nil {
+		err = rerr
+	}
+	if err == nil {
+		err = c.out.writeData("report.json", rb)
+	}
+	if cerr := c.out.Close(); err == nil {
+		err = cerr
+	}
+	if reportPath != "" && rerr == nil {
+		if werr := writeReport(reportPath, rb); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+var manifestCSVHeader = []string{
+	"path", "disk_path", "partition", "filesystem_uuid", "inode", "type", "size", "offset", "length", "partial",
+	"mode", "uid", "gid", "atime", "mtime", "ctime", "crtime", "symlink_target", "extents", "sha256", "sha1", "md5",
+	"source", "etag", "collected",
+}
+
+// csv returns the files of the manifest as CSV, one per line, with the
+// source of each repeated.
+func (m manifest) csv() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(manifestCSVHeader)
+	ts := func(t time.Time) string { return t.Format(time.RFC3339Nano) }
+	for _, f := range m.Files {
+		crtime := ""
+		if f.Crtime != nil {
+			crtime = ts(*f.Crtime)
+		}
+		var extents []string
+		for _, x := range f.Extents {
+			extents = append(extents, x.String())
+		}
+		w.Write([]string{
+			f.Path, f.DiskPath, strconv.Itoa(f.Partition), f.FilesystemUUID, strconv.FormatUint(uint64(f.Inode), 10), f.Type,
+			strconv.FormatInt(f.Size, 10), strconv.FormatInt(f.Offset, 10), strconv.FormatInt(f.Length, 10), strconv.FormatBool(f.Partial),
+			f.Mode, strconv.FormatUint(uint64(f.UID), 10), strconv.FormatUint(uint64(f.GID), 10),
+			ts(f.Atime), ts(f.Mtime), ts(f.Ctime), crtime, f.SymlinkTarget, strings.Join(extents, " "), f.SHA256, f.SHA1, f.MD5,
+			m.Source, m.ETag, ts(m.Collected),
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// redactURL returns uri without the signature of its SAS token, which would
+// give anyone reading the manifest access to the disk.
+func redactURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "(unparsable URL)"
+	}
+	q := u.Query()
+	if q.Get("sig") != "" {
+		q.Set("sig", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s"']+`)
+
+// redactURLs redacts the URLs in s, e.g. in errors of requests for the blob.
+func redactURLs(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, redactURL)
+}
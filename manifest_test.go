@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRedactURLs(t *testing.T) {
+	for _, tc := range []struct{ in, out string }{
+		{"", ""},
+		{"no URL here", "no URL here"},
+		{
+			`Get "https://a.blob.core.windows.net/vhds/d.vhd?se=2016-01-02&sig=secret&sp=r": EOF`,
+			`Get "https://a.blob.core.windows.net/vhds/d.vhd?se=2016-01-02&sig=REDACTED&sp=r": EOF`,
+		},
+		{
+			"reading http://127.0.0.1:10000/c/d.vhd?sig=a%2Fb failed, retrying https://x/y?sig=c",
+			"reading http://127.0.0.1:10000/c/d.vhd?sig=REDACTED failed, retrying https://x/y?sig=REDACTED",
+		},
+	} {
+		if got := redactURLs(tc.in); got != tc.out {
+			t.Errorf("redactURLs(%q) = %q, expected %q", tc.in, got, tc.out)
+		}
+	}
+}
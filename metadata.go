@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// writeFileMetadata records ownership, mode, ACLs and SELinux label of a
+// collected file in getfacl format, since the downloaded copy loses them.
+// Notes are added as further comment lines.
+func writeFileMetadata(w io.Writer, r *ext4.Reader, name string, ino uint32, inode ext4.Inode, notes ...string) error {
+	access, dflt, err := r.GetACLs(ino)
+	if err != nil {
+		return err
+	}
+	label, err := r.SELinuxContext(ino)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "# file: %s\n", name)
+	fmt.Fprintf(w, "# inode: %d\n", ino)
+	fmt.Fprintf(w, "# owner: %d\n", inode.UID())
+	fmt.Fprintf(w, "# group: %d\n", inode.GID())
+	fmt.Fprintf(w, "# mode: %v\n", inode.Mode)
+	fmt.Fprintf(w, "# mtime: %s\n", inode.ModTime().UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "# ctime: %s\n", inode.ChangeTime().UTC().Format(time.RFC3339Nano))
+	if crtime := inode.BirthTime(); !crtime.IsZero() {
+		fmt.Fprintf(w, "# crtime: %s\n", crtime.UTC().Format(time.RFC3339Nano))
+	}
+	if label != "" {
+		fmt.Fprintf(w, "# selinux: %s\n", label)
+	}
+	for _, n := range notes {
+		fmt.Fprintf(w, "# %s\n", n)
+	}
+	fmt.Fprintf(w, "%v\n", access)
+	if dflt != nil {
+		fmt.Fprintf(w, "%v\n", *dflt)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// volume is a filesystem found on the disk.
+type volume struct {
+	num       int            // Partition index, also used for the output directory; for logical volumes that of their first physical volume.
+	partUUID  string         // PARTUUID as the kernel derives it from the MBR.
+	lv        *logicalVolume // The LVM logical volume the filesystem is on, if any.
+	r         *ext4.Reader
+	mountedOn string // Mount point in the namespace, if fstab mounts it.
+}
+
+func (v *volume) String() string {
+	if v.lv != nil {
+		return fmt.Sprintf("logical volume %v (UUID=%v LABEL=%q)", v.lv, v.r.SuperBlock().UUID, v.r.SuperBlock().Label())
+	}
+	return fmt.Sprintf("partition %d (UUID=%v LABEL=%q PARTUUID=%s)",
+		v.num, v.r.SuperBlock().UUID, v.r.SuperBlock().Label(), v.partUUID)
+}
+
+// device describes where the filesystem is, like "partition 1" or
+// "logical volume rootvg/rootlv".
+func (v *volume) device() string {
+	if v.lv != nil {
+		return fmt.Sprintf("logical volume %v", v.lv)
+	}
+	return fmt.Sprintf("partition %d", v.num)
+}
+
+// dir returns the output directory of the filesystem: the partition index,
+// or the device mapper name of the logical volume.
+func (v *volume) dir() string {
+	if v.lv != nil {
+		return v.lv.dmName()
+	}
+	return strconv.Itoa(v.num)
+}
+
+// mbrPartUUID formats the PARTUUID of an MBR partition: the disk signature
+// and the partition number, like blkid shows it.
+func mbrPartUUID(signature uint32, partitionNum int) string {
+	return fmt.Sprintf("%08x-%02x", signature, partitionNum+1)
+}
+
+// device names of partitions on the OS disk, with the partition number
+var osDiskDevice = regexp.MustCompile(`^/dev/(?:(?:s|h|v|xv)da|nvme0n1p|disk/azure/root-part)([0-9]+)$`)
+
+// matches reports whether an fstab device spec refers to this volume.
+func (v *volume) matches(spec string) bool {
+	sb := v.r.SuperBlock()
+	key, value := spec, ""
+	if i := strings.Index(spec, "="); i > 0 {
+		key, value = spec[:i], strings.Trim(spec[i+1:], `"`)
+	} else if strings.HasPrefix(spec, "/dev/disk/by-") {
+		key, value = strings.TrimPrefix(spec, "/dev/disk/by-"), ""
+		if i := strings.Index(key, "/"); i > 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		key = strings.ToUpper(key)
+	}
+
+	switch key {
+	case "UUID":
+		return strings.EqualFold(value, sb.UUID.String())
+	case "LABEL":
+		return value != "" && value == sb.Label()
+	case "PARTUUID":
+		return v.lv == nil && strings.EqualFold(value, v.partUUID)
+	}
+	if v.lv != nil {
+		return spec == "/dev/mapper/"+v.lv.dmName() || spec == "/dev/"+v.lv.String()
+	}
+	if m := osDiskDevice.FindStringSubmatch(spec); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n == v.num+1
+	}
+	return false
+}
+
+// fstabEntry is a line of /etc/fstab, see fstab(5).
+type fstabEntry struct {
+	Line    int      // Line number in the file.
+	Spec    string   // Device to mount, e.g. "UUID=...", "LABEL=..." or "/dev/sda1".
+	File    string   // Mount point.
+	VfsType string   // Filesystem type.
+	Options []string // Mount options.
+	Freq    int      // Whether dump should back it up.
+	Passno  int      // Order in which fsck checks it at boot, 0 to not check.
+}
+
+func (e fstabEntry) hasOption(name string) bool {
+	for _, o := range e.Options {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e fstabEntry) String() string {
+	return fmt.Sprintf("line %d: %s on %s type %s (%s)", e.Line, e.Spec, e.File, e.VfsType, strings.Join(e.Options, ","))
+}
+
+// isBlockDevice tells entries for local disks apart from swap, pseudo and
+// network filesystems.
+func (e fstabEntry) isBlockDevice() bool {
+	if e.VfsType == "swap" || e.File == "none" || !strings.HasPrefix(e.File, "/") {
+		return false
+	}
+	for _, prefix := range []string{"UUID=", "LABEL=", "PARTUUID=", "PARTLABEL=", "/dev/"} {
+		if strings.HasPrefix(e.Spec, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var fstabEscape = regexp.MustCompile(`\\[0-7]{3}`)
+
+// unescapeFstab decodes the octal escapes (\040 for space) fstab uses.
+func unescapeFstab(s string) string {
+	return fstabEscape.ReplaceAllStringFunc(s, func(e string) string {
+		c, _ := strconv.ParseUint(e[1:], 8, 8)
+		return string([]byte{byte(c)})
+	})
+}
+
+func parseFstab(b []byte) []fstabEntry {
+	var rv []fstabEntry
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		e := fstabEntry{
+			Line:    line,
+			Spec:    unescapeFstab(fields[0]),
+			File:    unescapeFstab(fields[1]),
+			VfsType: "auto",
+			Options: []string{"defaults"},
+		}
+		if len(fields) > 2 {
+			e.VfsType = fields[2]
+		}
+		if len(fields) > 3 {
+			e.Options = strings.Split(fields[3], ",")
+		}
+		if len(fields) > 4 {
+			e.Freq, _ = strconv.Atoi(fields[4])
+		}
+		if len(fields) > 5 {
+			e.Passno, _ = strconv.Atoi(fields[5])
+		}
+		rv = append(rv, e)
+	}
+	return rv
+}
+
+// namespace is the tree of filesystems as the booted system would see it.
+type namespace struct {
+	root      *volume
+	fstab     []fstabEntry
+	unmatched []fstabEntry // Disk entries for which no filesystem was found.
+}
+
+// mountsRoot reports whether the fstab mounts the root volume on /.
+func (ns *namespace) mountsRoot() bool {
+	for _, e := range ns.fstab {
+		if e.File == "/" && ns.root.matches(e.Spec) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildNamespace picks the root filesystem among vols, i.e. the one holding
+// an /etc/fstab that mounts it on /, or else the first one with an fstab,
+// and mounts the other volumes on it as listed there. It returns nil if no
+// volume has an fstab.
+func buildNamespace(vols []*volume) *namespace {
+	var ns *namespace
+	for _, v := range vols {
+		b, err := fs.ReadFile(v.r.FS(), "etc/fstab")
+		if err != nil {
+			continue
+		}
+		candidate := &namespace{root: v, fstab: parseFstab(b)}
+		if ns == nil {
+			ns = candidate
+		}
+		if candidate.mountsRoot() {
+			ns = candidate
+			break
+		}
+	}
+	if ns == nil {
+		return nil
+	}
+
+	ns.root.mountedOn = "/"
+	for _, e := range ns.fstab {
+		if !e.isBlockDevice() {
+			continue
+		}
+		var match *volume
+		for _, v := range vols {
+			if v.matches(e.Spec) {
+				match = v
+				break
+			}
+		}
+		if match == nil {
+			ns.unmatched = append(ns.unmatched, e)
+			continue
+		}
+		if e.File == "/" || match == ns.root {
+			continue
+		}
+		match.mountedOn = e.File
+		ns.root.r.Mount(e.File, match.r)
+	}
+	return ns
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFstab(t *testing.T) {
+	fstab := `# /etc/fstab: static file system information.
+UUID=6b0c2f5e-7c1e-4b7a-9f2d-1d2c3b4a5f60 /               ext4    errors=remount-ro 0       1
+
+LABEL=boot      /boot   ext4    defaults        1 2
+/dev/disk/by-uuid/1234-ABCD /boot/efi vfat umask=0077 0 1
+/dev/sdc1	/mnt/my\040data	xfs	defaults,nofail	0	2
+tmpfs /tmp tmpfs
+/swapfile none swap sw 0 0
+   # indented comment
+`
+	expected := []fstabEntry{
+		{2, "UUID=6b0c2f5e-7c1e-4b7a-9f2d-1d2c3b4a5f60", "/", "ext4", []string{"errors=remount-ro"}, 0, 1},
+		{4, "LABEL=boot", "/boot", "ext4", []string{"defaults"}, 1, 2},
+		{5, "/dev/disk/by-uuid/1234-ABCD", "/boot/efi", "vfat", []string{"umask=0077"}, 0, 1},
+		{6, "/dev/sdc1", "/mnt/my data", "xfs", []string{"defaults", "nofail"}, 0, 2},
+		{7, "tmpfs", "/tmp", "tmpfs", []string{"defaults"}, 0, 0},
+		{8, "/swapfile", "none", "swap", []string{"sw"}, 0, 0},
+	}
+	entries := parseFstab([]byte(fstab))
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("parseFstab() = %+v,\nexpected %+v", entries, expected)
+	}
+}
+
+func TestFstabEntry(t *testing.T) {
+	tests := []struct {
+		line        string
+		blockDevice bool
+		nofail      bool
+	}{
+		{"UUID=abc / ext4 defaults 0 1", true, false},
+		{"LABEL=data /data ext4 defaults,nofail 0 2", true, true},
+		{"PARTUUID=abc-01 /boot ext4 defaults 0 2", true, false},
+		{"/dev/mapper/rootvg-homelv /home xfs defaults 0 0", true, false},
+		{"/dev/sdb1 none swap sw 0 0", false, false},
+		{"UUID=abc swap swap defaults 0 0", false, false},
+		{"tmpfs /tmp tmpfs defaults 0 0", false, false},
+		{"//server/share /mnt/share cifs nofail 0 0", false, true},
+		{"proc /proc proc defaults 0 0", false, false},
+	}
+	for _, test := range tests {
+		entries := parseFstab([]byte(test.line))
+		if len(entries) != 1 {
+			t.Errorf("parseFstab(%q) = %v", test.line, entries)
+			continue
+		}
+		if e := entries[0]; e.isBlockDevice() != test.blockDevice || e.hasOption("nofail") != test.nofail {
+			t.Errorf("%q: isBlockDevice() = %v, hasOption(nofail) = %v, expected %v, %v",
+				test.line, e.isBlockDevice(), e.hasOption("nofail"), test.blockDevice, test.nofail)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// output is where collected files go: a directory, or an archive.
+type output interface {
+	// writeFile stores the bytes from start up to end of ir as name. A
+	// *partialError means the file could only be stored in part, an
+	// *outputError that nothing more can be stored.
+	writeFile(name string, fi fileInfo, ir ext4.InodeReader, start, end int64) (int64, error)
+	// writeSpecial stores a symlink to target, a device or a FIFO as name.
+	writeSpecial(name string, fi fileInfo, target string) error
+	// writeData stores data made up by the tool, like the metadata report.
+	writeData(name string, data []byte) error
+	Close() error
+}
+
+// fileInfo is what outputs keep of the inode of a collected file.
+type fileInfo struct {
+	inode  ext4.Inode
+	xattrs []ext4.Xattr
+}
+
+// outputError is returned when the output cannot be written to, which makes
+// going on with the collection pointless.
+type outputError struct {
+	err error
+}
+
+func (e *outputError) Error() string {
+	return fmt.Sprintf("cannot write output: %v", e.err)
+}
+
+// openOutput returns the archive uploaded to the container -upload names,
+// if it is set, the archive -archive names, if that is set, and the
+// directory dir otherwise. source is the URL of the disk, which uploads are
+// named after.
+func openOutput(dir, source string) (output, error) {
+	if archivePath == "" && uploadURL == "" {
+		return dirOutput{dir}, nil
+	}
+	name := archivePath
+	var w io.WriteCloser = nopCloser{stdout}
+	switch {
+	case uploadURL != "":
+		name = uploadName(source, time.Now())
+		u, err := blobURL(uploadURL, name)
+		if err != nil {
+			return nil, err
+		}
+		w = newBlockBlobWriter(u)
+	case archivePath != "-":
+		f, err := os.Create(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	format := archiveFormat
+	if format == "" {
+		switch {
+		case strings.HasSuffix(name, ".zip"):
+			format = "zip"
+		case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+			format = "tgz"
+		default:
+			format = "tar"
+		}
+	}
+
+	switch format {
+	case "tar":
+		return &tarOutput{w: tar.NewWriter(w), c: w}, nil
+	case "tgz":
+		gz := gzip.NewWriter(w)
+		return &tarOutput{w: tar.NewWriter(gz), gz: gz, c: w}, nil
+	case "zip":
+		return &zipOutput{w: zip.NewWriter(w), c: w}, nil
+	}
+	w.Close()
+	return nil, fmt.Errorf("unknown archive format %q, expected tar, tgz or zip", format)
+}
+
+// closeDestination closes what an archive is written to, once the archive
+// writer closed with err. An upload of an incomplete archive is not
+// committed, so that it does not show up as a blob that looks complete.
+func closeDestination(c io.Closer, err error) error {
+	if _, ok := c.(*blockBlobWriter); ok && err != nil {
+		return err
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// dirOutput writes files below a directory, with the mode and times of the
+// originals as far as the local filesystem allows.
+type dirOutput struct {
+	path string
+}
+
+func (o dirOutput) file(name string) (string, error) {
+	outFile := o.path + "/" + fixFilename(name)
+	if err := os.MkdirAll(path.Dir(outFile), 0777); err != nil {
+		return "", &outputError{fmt.Errorf("could not create path %s: %s", path.Dir(outFile), err)}
+	}
+	return outFile, nil
+}
+
+func (o dirOutput) writeFile(name string, fi fileInfo, ir ext4.InodeReader, start, end int64) (int64, error) {
+	outFile, err := o.file(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := extractRange(ir, outFile, start, end)
+	if err != nil {
+		return n, err
+	}
+	// keep collected copies readable, the exact mode is in the metadata report
+	if err := os.Chmod(outFile, fi.inode.Mode.FileMode().Perm()|0400); err != nil {
+		warnf("could not set mode of %s: %v", outFile, err)
+	}
+	if err := os.Chtimes(outFile, fi.inode.AccessTime(), fi.inode.ModTime()); err != nil {
+		warnf("could not set times of %s: %v", outFile, err)
+	}
+	return n, nil
+}
+
+func (o dirOutput) writeSpecial(name string, fi fileInfo, target string) error {
+	if fi.inode.Mode.FileType() != ext4.FileTypeSymlink {
+		return fmt.Errorf("%s files can only be kept in archives", fi.inode.Mode.FileType())
+	}
+	outFile, err := o.file(name)
+	if err != nil {
+		return err
+	}
+	os.Remove(outFile)
+	return os.Symlink(target, outFile)
+}
+
+func (o dirOutput) writeData(name string, data []byte) error {
+	outFile, err := o.file(name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outFile, data, 0666)
+}
+
+func (o dirOutput) Close() error { return nil }
+
+// tarOutput writes a PAX tar archive, which keeps ownership, mode, all times,
+// extended attributes and symlinks. POSIX ACLs are left to the metadata
+// report, as their on-disk format is not what tar implementations expect.
+type tarOutput struct {
+	w  *tar.Writer
+	gz *gzip.Writer // Nil unless compressed.
+	c  io.Closer
+}
+
+func (o *tarOutput) header(name string, fi fileInfo) *tar.Header {
+	inode := fi.inode
+	h := &tar.Header{
+		Name:       strings.TrimPrefix(name, "/"),
+		Mode:       int64(inode.Mode & 07777),
+		Uid:        int(inode.UID()),
+		Gid:        int(inode.GID()),
+		ModTime:    inode.ModTime(),
+		AccessTime: inode.AccessTime(),
+		ChangeTime: inode.ChangeTime(),
+		Format:     tar.FormatPAX,
+	}
+	for _, x := range fi.xattrs {
+		if x.Name == ext4.XattrPosixACLAccess || x.Name == ext4.XattrPosixACLDefault {
+			continue
+		}
+		if h.PAXRecords == nil {
+			h.PAXRecords = map[string]string{}
+		}
+		h.PAXRecords["SCHILY.xattr."+x.Name] = string(x.Value)
+	}
+	return h
+}
+
+func (o *tarOutput) writeFile(name string, fi fileInfo, ir ext4.InodeReader, start, end int64) (int64, error) {
+	h := o.header(name, fi)
+	h.Typeflag = tar.TypeReg
+	h.Size = end - start
+	if err := o.w.WriteHeader(h); err != nil {
+		return 0, &outputError{err}
+	}
+	n, err := copyRange(o.w, ir, start, end)
+	if _, ok := err.(*outputError); ok || err == nil {
+		return n, err
+	}
+	// the header promised the full size, make up the rest with zeros
+	zeros := make([]byte, copyChunkSize)
+	for pad := end - start - n; pad > 0; {
+		m := int64(len(zeros))
+		if pad < m {
+			m = pad
+		}
+		if _, werr := o.w.Write(zeros[:m]); werr != nil {
+			return n, &outputError{werr}
+		}
+		pad -= m
+	}
+	return n, &partialError{written: n, size: end - start, err: err}
+}
+
+func (o *tarOutput) writeSpecial(name string, fi fileInfo, target string) error {
+	h := o.header(name, fi)
+	switch fi.inode.Mode.FileType() {
+	case ext4.FileTypeSymlink:
+		h.Typeflag, h.Linkname = tar.TypeSymlink, target
+	case ext4.FileTypeChardev, ext4.FileTypeBlockdev:
+		h.Typeflag = tar.TypeChar
+		if fi.inode.Mode.FileType() == ext4.FileTypeBlockdev {
+			h.Typeflag = tar.TypeBlock
+		}
+		major, minor := fi.inode.Device()
+		h.Devmajor, h.Devminor = int64(major), int64(minor)
+	case ext4.FileTypeFIFO:
+		h.Typeflag = tar.TypeFifo
+	default:
+		return fmt.Errorf("%s files cannot be kept in tar archives", fi.inode.Mode.FileType())
+	}
+	if err := o.w.WriteHeader(h); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+func (o *tarOutput) writeData(name string, data []byte) error {
+	err := o.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	})
+	if err == nil {
+		_, err = o.w.Write(data)
+	}
+	if err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+func (o *tarOutput) Close() error {
+	err := o.w.Close()
+	if o.gz != nil {
+		if gerr := o.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	return closeDestination(o.c, err)
+}
+
+// zipOutput writes a zip archive. Mode and owner are kept in the Unix fields
+// of the entries, times and extended attributes only in the metadata report.
+type zipOutput struct {
+	w *zip.Writer
+	c io.Closer
+}
+
+func (o *zipOutput) create(name string, fi fileInfo) (io.Writer, error) {
+	h := &zip.FileHeader{
+		Name:     strings.TrimPrefix(name, "/"),
+		Method:   zip.Deflate,
+		Modified: fi.inode.ModTime(),
+	}
+	h.SetMode(fi.inode.Mode.FileMode())
+	// Info-ZIP Unix extra field: version, then uid and gid with their sizes
+	ux := make([]byte, 15)
+	binary.LittleEndian.PutUint16(ux[0:], 0x7875)
+	binary.LittleEndian.PutUint16(ux[2:], 11)
+	ux[4], ux[5], ux[10] = 1, 4, 4
+	binary.LittleEndian.PutUint32(ux[6:], fi.inode.UID())
+	binary.LittleEndian.PutUint32(ux[11:], fi.inode.GID())
+	h.Extra = ux
+	w, err := o.w.CreateHeader(h)
+	if err != nil {
+		return nil, &outputError{err}
+	}
+	return w, nil
+}
+
+func (o *zipOutput) writeFile(name string, fi fileInfo, ir ext4.InodeReader, start, end int64) (int64, error) {
+	w, err := o.create(name, fi)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyRange(w, ir, start, end)
+	if _, ok := err.(*outputError); ok || err == nil {
+		return n, err
+	}
+	return n, &partialError{written: n, size: end - start, err: err}
+}
+
+func (o *zipOutput) writeSpecial(name string, fi fileInfo, target string) error {
+	if fi.inode.Mode.FileType() != ext4.FileTypeSymlink {
+		return fmt.Errorf("%s files cannot be kept in zip archives", fi.inode.Mode.FileType())
+	}
+	w, err := o.create(name, fi)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, target); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+func (o *zipOutput) writeData(name string, data []byte) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+	h.SetMode(0644)
+	w, err := o.w.CreateHeader(h)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+func (o *zipOutput) Close() error {
+	err := o.w.Close()
+	return closeDestination(o.c, err)
+}
@@ -30,3 +30,14 @@ func readPartitionTable(s io.ReadSeeker) ([]partitionEntry, error) {
 
 	return rv, nil
 }
+
+// readDiskSignature reads the MBR disk signature, which PARTUUIDs of MBR
+// partitions are derived from.
+func readDiskSignature(s io.ReadSeeker) (uint32, error) {
+	if _, err := s.Seek(440, 0); err != nil {
+		return 0, err
+	}
+	var signature uint32
+	err := binary.Read(s, binary.LittleEndian, &signature)
+	return signature, err
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// profile is a named set of files to collect, as read from a YAML or JSON
+// file, e.g.
+//
+//	name: ssh-access
+//	description: Why a user cannot log in with ssh.
+//	exclude:
+//	  - /etc/ssh/ssh_host_*_key
+//	include:
+//	  - /etc/ssh/**
+//	  - pattern: /var/log/secure*
+//	    tail: 16M
+//
+// A file may hold several profiles as separate YAML documents.
+type profile struct {
+	Name           string        `yaml:"name"`
+	Description    string        `yaml:"description"`
+	Include        []profileRule `yaml:"include"`
+	Exclude        []string      `yaml:"exclude"`         // Patterns of files none of the include rules download.
+	FollowSymlinks *bool         `yaml:"follow_symlinks"` // Whether to download the targets of symlinks, true if not set.
+}
+
+// profileRule is an include rule of a profile, either just a pattern, which
+// may have the options of -file, or a mapping with the pattern and options.
+type profileRule struct {
+	Pattern        string `yaml:"pattern"`
+	Tail           string `yaml:"tail"`     // Size of the end of the file to download, e.g. 16M.
+	Lines          int    `yaml:"lines"`    // Number of lines at the end of the file to download.
+	Since          string `yaml:"since"`    // Time of the first log record to download.
+	Until          string `yaml:"until"`    // Time of the last log record to download.
+	MaxSize        string `yaml:"max_size"` // Size above which files are not downloaded.
+	FollowSymlinks *bool  `yaml:"follow_symlinks"`
+}
+
+func (r *profileRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&r.Pattern); err == nil {
+		return nil
+	}
+	type plain profileRule
+	return unmarshal((*plain)(r))
+}
+
+// rules returns the collect rules of the profile, in order.
+func (p profile) rules() ([]collectRule, error) {
+	var rv []collectRule
+	for _, r := range p.Include {
+		rule, err := parseRule(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		if r.Tail != "" {
+			rule.TailBytes, err = parseSize(r.Tail)
+		}
+		if r.Since != "" && err == nil {
+			rule.Since, err = parseTime(r.Since)
+		}
+		if r.Until != "" && err == nil {
+			rule.Until, err = parseTime(r.Until)
+		}
+		if r.MaxSize != "" && err == nil {
+			rule.MaxSize, err = parseSize(r.MaxSize)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %s: %v", p.Name, r.Pattern, err)
+		}
+		if r.Lines != 0 {
+			rule.TailLines = r.Lines
+		}
+		follow := p.FollowSymlinks
+		if r.FollowSymlinks != nil {
+			follow = r.FollowSymlinks
+		}
+		if follow != nil {
+			rule.NoFollow = !*follow
+		}
+		rule.Exclude = p.Exclude
+		rule.Source = p.Name
+		rv = append(rv, rule)
+	}
+	return rv, nil
+}
+
+// parseProfiles reads the profiles in the YAML or JSON document(s) r.
+func parseProfiles(r io.Reader, source string) ([]profile, error) {
+	var rv []profile
+	dec := yaml.NewDecoder(r)
+	for {
+		var p profile
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		if p.Name == "" {
+			p.Name = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+		}
+		if len(p.Include) == 0 {
+			return nil, fmt.Errorf("%s: profile %s includes no files", source, p.Name)
+		}
+		if _, err := p.rules(); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		rv = append(rv, p)
+	}
+	if len(rv) == 0 {
+		return nil, fmt.Errorf("%s: no profiles", source)
+	}
+	return rv, nil
+}
+
+// profileRules returns the rules of the profiles given by name or by file,
+// in order. Each entry of names may list several, separated by commas. The
+// name auto stands for the profile of the distribution d.
+func profileRules(names []string, d *distro) ([]collectRule, error) {
+	var rv []collectRule
+	for _, n := range names {
+		for _, name := range strings.Split(n, ",") {
+			name = strings.TrimSpace(name)
+			if name == autoProfile {
+				name = d.profile()
+			}
+			profiles, err := loadProfiles(name)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range profiles {
+				r, err := p.rules()
+				if err != nil {
+					return nil, err
+				}
+				rv = append(rv, r...)
+			}
+		}
+	}
+	return rv, nil
+}
+
+// loadProfiles returns the built-in profile name, or the profiles in the file
+// name.
+func loadProfiles(name string) ([]profile, error) {
+	for _, p := range builtinProfiles {
+		if p.Name == name {
+			return []profile{p}, nil
+		}
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s is neither built in (%s) nor a readable file: %v", name, strings.Join(builtinProfileNames(), ", "), err)
+	}
+	return parseProfiles(bytes.NewReader(b), name)
+}
+
+func builtinProfileNames() []string {
+	var names []string
+	for _, p := range builtinProfiles {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const (
+	autoProfile    = "auto"    // The profile of the detected distribution, collected if no profiles are given.
+	defaultProfile = "default" // What auto stands for if the distribution is not known.
+)
+
+var builtinProfiles = mustParseProfiles(builtinProfilesYAML)
+
+func mustParseProfiles(s string) []profile {
+	profiles, err := parseProfiles(strings.NewReader(s), "built-in profiles")
+	if err != nil {
+		panic(err)
+	}
+	return profiles
+}
+
+// builtinProfilesYAML holds the profiles that need no file. Private keys,
+// password hashes and the like are left out wherever possible.
+const builtinProfilesYAML = `
+name: default
+description: The usual ssh, fstab, agent, grub and log files.
+include:
+  - /etc/ssh*/**
+  - /etc/ssh*
+  - /etc/{fstab,passwd,mtab,waagent.conf}
+  - /var/log/{messages,boot,dmesg,syslog}*
+  - /var/log/{waagent,walinuxagent,azure}/**
+  - /var/log/{waagent,walinuxagent}*
+  - /var/log/*
+  - /{boot/,}grub/*cfg
+---
+name: redhat
+description: Logs, network and boot configuration of RHEL, CentOS, Oracle Linux and the like.
+exclude:
+  - /etc/ssh/ssh_host_*_key
+include:
+  - /etc/ssh/**
+  - /etc/{os-release,redhat-release,system-release,fstab,passwd,mtab,waagent.conf,default/grub}
+  - /etc/sysconfig/{network,selinux,kernel,grub}
+  - /etc/sysconfig/network-scripts/{ifcfg,route}-*
+  - /etc/NetworkManager/{NetworkManager.conf,conf.d/*}
+  - /boot/grub2/{grub.cfg,grubenv}
+  - /boot/efi/EFI/*/grub.cfg
+  - /boot/loader/entries/*
+  - pattern: /var/log/{messages,secure,cron,boot.log,dmesg,yum.log,dnf.log}*
+    tail: 64M
+  - pattern: /var/log/{waagent,walinuxagent}*
+    tail: 64M
+  - /var/log/azure/**
+  - /var/log/cloud-init*.log
+---
+name: debian
+description: Logs, network and boot configuration of Debian and Ubuntu.
+exclude:
+  - /etc/ssh/ssh_host_*_key
+include:
+  - /etc/ssh/**
+  - /etc/{os-release,debian_version,lsb-release,fstab,passwd,mtab,waagent.conf,default/grub}
+  - /etc/default/grub.d/*
+  - /etc/netplan/*.yaml
+  - /etc/network/interfaces
+  - /etc/network/interfaces.d/*
+  - /etc/cloud/cloud.cfg.d/*
+  - /boot/grub/{grub.cfg,grubenv}
+  - pattern: /var/log/{syslog,kern.log,auth.log,dmesg,dpkg.log}*
+    tail: 64M
+  - /var/log/apt/history.log*
+  - pattern: /var/log/{waagent,walinuxagent}*
+    tail: 64M
+  - /var/log/azure/**
+  - /var/log/cloud-init*.log
+---
+name: suse
+description: Logs, network and boot configuration of SLES and openSUSE.
+exclude:
+  - /etc/ssh/ssh_host_*_key
+include:
+  - /etc/ssh/**
+  - /etc/{os-release,SuSE-release,fstab,passwd,mtab,waagent.conf,default/grub}
+  - /etc/sysconfig/{bootloader,kernel}
+  - /etc/sysconfig/network/{config,dhcp,routes,ifcfg-*}
+  - /boot/grub2/{grub.cfg,grubenv}
+  - /boot/efi/EFI/*/grub.cfg
+  - pattern: /var/log/{messages,warn,boot.msg,boot.log,zypper.log}*
+    tail: 64M
+  - pattern: /var/log/{waagent,walinuxagent}*
+    tail: 64M
+  - /var/log/azure/**
+  - /var/log/cloud-init*.log
+---
+name: boot-failure
+description: Why the VM does not boot, or drops to emergency mode.
+include:
+  - /etc/{fstab,crypttab,mtab,default/grub,dracut.conf,mdadm.conf,mdadm/mdadm.conf}
+  - /etc/dracut.conf.d/*
+  - /{boot/,}grub{,2}/{grub.cfg,grubenv,menu.lst,grub.conf}
+  - /boot/efi/EFI/*/grub.cfg
+  - /boot/loader/entries/*
+  - /etc/systemd/system/*.{mount,automount}
+  - /etc/{selinux/config,sysconfig/selinux}
+  - /etc/{modprobe.d,modules-load.d}/*
+  - pattern: /var/log/{messages,syslog,kern.log,boot.log,dmesg}*
+    tail: 64M
+  - pattern: /var/log/cloud-init*.log
+    tail: 16M
+---
+name: ssh-access
+description: Why users cannot log in with ssh.
+exclude:
+  - /etc/ssh/ssh_host_*_key
+  - /etc/ssh/*.pem
+include:
+  - /etc/ssh/**
+  - /etc/{passwd,group,nsswitch.conf,sudoers,hosts.allow,hosts.deny,nologin,securetty}
+  - /etc/sudoers.d/*
+  - /etc/pam.d/*
+  - /etc/security/{access.conf,limits.conf,pam_env.conf}
+  - /etc/{selinux/config,sysconfig/selinux}
+  - /{root,home/*}/.ssh/authorized_keys*
+  - pattern: /var/log/{secure,auth.log}*
+    tail: 16M
+  - pattern: /var/log/{messages,syslog}
+    tail: 16M
+---
+name: azure-agent
+description: The Azure Linux agent, extensions and cloud-init.
+exclude:
+  - /var/lib/waagent/ovf-env.xml
+  - /var/lib/waagent/*.{prv,pem,crt,p7m,key}
+include:
+  - /etc/waagent.conf
+  - /var/lib/waagent/*.{xml,json}
+  - /var/lib/waagent/*/{HandlerManifest.json,HandlerEnvironment.json,config/*.settings,status/*.status}
+  - pattern: /var/log/{waagent,walinuxagent}*
+    tail: 64M
+  - pattern: /var/log/azure/**
+    max_size: 64M
+  - /etc/cloud/cloud.cfg
+  - /etc/cloud/cloud.cfg.d/*
+  - pattern: /var/log/cloud-init*.log
+    tail: 16M
+---
+name: networking
+description: Network configuration, name resolution and firewall.
+exclude:
+  - /etc/NetworkManager/system-connections/**
+include:
+  - /etc/{hosts,hostname,resolv.conf,nsswitch.conf,sysctl.conf}
+  - /etc/sysctl.d/*
+  - /etc/sysconfig/{network,iptables,ip6tables}
+  - /etc/sysconfig/network-scripts/{ifcfg,route}-*
+  - /etc/sysconfig/network/{config,ifcfg-*,routes}
+  - /etc/network/interfaces
+  - /etc/network/interfaces.d/*
+  - /etc/netplan/*.yaml
+  - /etc/systemd/network/*
+  - /etc/systemd/resolved.conf
+  - /etc/NetworkManager/{NetworkManager.conf,conf.d/*}
+  - /etc/dhcp/dhclient*.conf
+  - /etc/udev/rules.d/*net*
+  - /etc/iptables/*
+  - /etc/firewalld/**
+  - pattern: /var/log/{messages,syslog}
+    tail: 16M
+---
+name: disk-full
+description: What fills up the filesystems; large files are left for find -size.
+include:
+  - /etc/{fstab,logrotate.conf}
+  - /etc/logrotate.d/*
+  - /etc/systemd/journald.conf
+  - /etc/systemd/journald.conf.d/*
+  - /etc/{rsyslog.conf,syslog-ng/syslog-ng.conf}
+  - /etc/rsyslog.d/*
+  - pattern: /var/log/{messages,syslog}
+    tail: 16M
+  - pattern: /var/log/*
+    max_size: 1M
+---
+name: security
+description: Accounts, privileges, SELinux, auditing and scheduled jobs.
+include:
+  - /etc/{passwd,group,login.defs,sudoers,crontab,anacrontab}
+  - /etc/sudoers.d/*
+  - /etc/pam.d/*
+  - /etc/security/**
+  - /etc/{selinux/config,sysconfig/selinux}
+  - /etc/audit/{auditd.conf,audit.rules}
+  - /etc/audit/rules.d/*
+  - /etc/cron.{d,hourly,daily,weekly,monthly}/*
+  - /var/spool/cron/**
+  - pattern: /var/log/audit/audit.log*
+    tail: 64M
+  - pattern: /var/log/{secure,auth.log}*
+    tail: 16M
+`
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// reportVersion is raised when fields of the report change meaning or go
+// away; new fields may be added within a version. See
+// schema/report-v1.json.
+const reportVersion = 1
+
+// report describes a whole inspection, for automation.
+type report struct {
+	Version     int                `json:"version"`
+	Started     time.Time          `json:"started"`
+	Finished    time.Time          `json:"finished"`
+	Disk        reportDisk         `json:"disk"`
+	Partitions  []reportPartition  `json:"partitions"`
+	Filesystems []reportFilesystem `json:"filesystems"`
+	Files       []reportFile       `json:"files"`
+	Findings    []finding          `json:"findings"` // Of the analyzers, the worst first.
+	Warnings    []string           `json:"warnings"`
+	Error       string             `json:"error,omitempty"` // Why the inspection stopped early, if it did.
+}
+
+type reportDisk struct {
+	Source string `json:"source"` // URL of the blob, without the signature.
+	Size   int64  `json:"size"`
+	Format string `json:"format"` // See containerFormat.
+	ETag   string `json:"etag"`
+}
+
+type reportPartition struct {
+	Index    int    `json:"index"`
+	Bootable bool   `json:"bootable"`
+	Type     int    `json:"type"`  // MBR partition type, 131 (0x83) for Linux.
+	Start    int64  `json:"start"` // First sector.
+	Sectors  int64  `json:"sectors"`
+	Size     int64  `json:"size"`
+	PartUUID string `json:"partuuid"`
+}
+
+type reportFilesystem struct {
+	Partition      int        `json:"partition"`
+	LogicalVolume  string     `json:"logical_volume,omitempty"` // VG/LV, for filesystems on LVM.
+	Type           string     `json:"type"`                     // ext2, ext3 or ext4.
+	UUID           string     `json:"uuid"`
+	Label          string     `json:"label"`
+	MountedOn      string     `json:"mounted_on,omitempty"` // Where the root's /etc/fstab mounts it.
+	LastMountedOn  string     `json:"last_mounted_on"`
+	State          []string   `json:"state"` // Any of Clean, Error and Orphans.
+	ErrorsBehavior string     `json:"errors_behavior"`
+	Features       []string   `json:"features"`
+	BlockSize      int64      `json:"block_size"`
+	Blocks         uint64     `json:"blocks"`
+	FreeBlocks     uint64     `json:"free_blocks"`
+	Inodes         uint64     `json:"inodes"`
+	FreeInodes     uint64     `json:"free_inodes"`
+	Directories    uint64     `json:"directories,omitempty"` // Only known if the bitmaps could be read.
+	Created        *time.Time `json:"created,omitempty"`
+	LastMount      *time.Time `json:"last_mount,omitempty"`
+	LastWrite      *time.Time `json:"last_write,omitempty"`
+	LastCheck      *time.Time `json:"last_check,omitempty"`
+	MountCount     int        `json:"mount_count"`
+	MaxMountCount  int        `json:"max_mount_count"`
+	ErrorCount     uint32     `json:"error_count"`
+	FirstError     string     `json:"first_error,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Distribution   *distro    `json:"distribution,omitempty"` // Only for filesystems collected as root.
+}
+
+// reportFile is a file one of the rules matched, whether it was collected
+// or not.
+type reportFile struct {
+	Path     string   `json:"path,omitempty"` // Name in the output, if it was stored.
+	DiskPath string   `json:"disk_path"`
+	Type     string   `json:"type"`
+	Status   string   `json:"status"`           // collected, partial, skipped or failed.
+	Reason   string   `json:"reason,omitempty"` // Why it was skipped, failed or is partial.
+	Rules    []string `json:"rules"`
+	Size     int64    `json:"size"`
+	Offset   int64    `json:"offset"`
+	Length   int64    `json:"length"` // Number of bytes stored.
+	SHA256   string   `json:"sha256,omitempty"`
+}
+
+// describeDisk fills in the disk, partitions and filesystems of the report.
+func (r *report) describeDisk(d *disk) {
+	for i, p := range d.partitions {
+		if p.Type == 0 {
+			continue
+		}
+		r.Partitions = append(r.Partitions, reportPartition{
+			Index:    i,
+			Bootable: p.Active == 0x80,
+			Type:     int(p.Type),
+			Start:    int64(p.LBAfirst),
+			Sectors:  int64(p.Sectors),
+			Size:     int64(p.Sectors) * 512,
+		})
+	}
+	for _, v := range d.volumes {
+		for i := range r.Partitions {
+			if r.Partitions[i].Index == v.num && v.lv == nil {
+				r.Partitions[i].PartUUID = v.partUUID
+			}
+		}
+		sb := v.r.SuperBlock()
+		fs := reportFilesystem{
+			Partition:      v.num,
+			LogicalVolume:  lvName(v),
+			Type:           fsType(sb),
+			UUID:           sb.UUID.String(),
+			Label:          sb.Label(),
+			MountedOn:      v.mountedOn,
+			LastMountedOn:  sb.LastMountedDir(),
+			State:          flagNames(sb.State),
+			ErrorsBehavior: sb.Errors.String(),
+			Features:       append(append(flagNames(sb.FeatureCompat), flagNames(sb.FeatureIncompat)...), flagNames(sb.FeatureROCompat)...),
+			BlockSize:      int64(1) << (10 + sb.LogBlockSize),
+			Blocks:         sb.BlocksCount(),
+			FreeBlocks:     sb.FreeBlocksCount(),
+			Inodes:         uint64(sb.InodesCount),
+			FreeInodes:     uint64(sb.FreeInodesCount),
+			Created:        optionalTime(sb.MkfsTime()),
+			LastMount:      optionalTime(sb.MountTime()),
+			LastWrite:      optionalTime(sb.WriteTime()),
+			LastCheck:      optionalTime(sb.LastCheck()),
+			MountCount:     int(sb.MountCount),
+			MaxMountCount:  int(sb.MaxMountCount),
+			ErrorCount:     sb.ErrorCount,
+			FirstError:     sb.FirstError(),
+			LastError:      sb.LastError(),
+		}
+		// the bitmaps are more accurate than the superblock counts, which
+		// the kernel only updates now and then
+		if usage, err := v.r.Usage(); err == nil {
+			fs.FreeBlocks, fs.FreeInodes, fs.Directories = usage.FreeBlocks, usage.FreeInodes, usage.Dirs
+		}
+		r.Filesystems = append(r.Filesystems, fs)
+	}
+}
+
+// containerFormat tells how the disk of size bytes in s is stored: by the
+// VHD footer at its end, or at its start for dynamic VHDs, as "vhd-fixed",
+// "vhd-dynamic" or "vhd-differencing", and "raw" without one. Azure only
+// runs fixed VHDs.
+func containerFormat(s io.ReadSeeker, size int64) (string, error) {
+	footer := make([]byte, 512)
+	for _, offset := range []int64{size - 512, 0} {
+		if offset < 0 {
+			continue
+		}
+		if _, err := s.Seek(offset, 0); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(s, footer); err != nil {
+			return "", err
+		}
+		if !bytes.Equal(footer[:8], []byte("conectix")) {
+			continue
+		}
+		switch t := binary.BigEndian.Uint32(footer[60:]); t {
+		case 2:
+			return "vhd-fixed", nil
+		case 3:
+			return "vhd-dynamic", nil
+		case 4:
+			return "vhd-differencing", nil
+		default:
+			return fmt.Sprintf("vhd-type-%d", t), nil
+		}
+	}
+	return "raw", nil
+}
+
+// setDistro records the distribution found on the filesystem of v.
+func (r *report) setDistro(v *volume, d *distro) {
+	for i := range r.Filesystems {
+		if r.Filesystems[i].Partition == v.num && r.Filesystems[i].LogicalVolume == lvName(v) {
+			r.Filesystems[i].Distribution = d
+		}
+	}
+}
+
+// lvName returns the VG/LV name of the logical volume v is on, if any.
+func lvName(v *volume) string {
+	if v.lv == nil {
+		return ""
+	}
+	return v.lv.String()
+}
+
+func (r *report) marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	return append(b, '\n'), err
+}
+
+// writeReport writes the marshalled report b to path, - for stdout.
+func writeReport(path string, b []byte) error {
+	if path == "-" {
+		_, err := stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// fsType tells ext2, ext3 and ext4 apart by their features, like blkid.
+func fsType(sb ext4.SuperBlock) string {
+	if sb.FeatureIncompat&^(ext4.FeatureIncompatFlagFiletype|ext4.FeatureIncompatFlagRecover|ext4.FeatureIncompatFlagMetaBG) != 0 ||
+		sb.FeatureROCompat&^(ext4.FeatureROCompatFlagSparseSuper|ext4.FeatureROCompatFlagLargeFile|ext4.FeatureROCompatFlagBtreeDir) != 0 {
+		return "ext4"
+	}
+	if sb.FeatureCompat&ext4.FeatureCompatFlagHasJournal != 0 {
+		return "ext3"
+	}
+	return "ext2"
+}
+
+// flagNames returns the names of the flags in f, from its "A|B(0x0003)"
+// string form.
+func flagNames(f fmt.Stringer) []string {
+	s := f.String()
+	if i := strings.LastIndex(s, "("); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "|")
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// collectRule is a pattern of files to download, optionally only in part.
+type collectRule struct {
+	Pattern   string
+	TailBytes int64     // Only the last TailBytes bytes, if set.
+	TailLines int       // Only the last TailLines lines, if set.
+	Since     time.Time // Only log records from Since on, if set.
+	Until     time.Time // Only log records up to Until, if set.
+	MaxSize   int64     // Files larger than this are not downloaded, if set.
+	NoFollow  bool      // Symlinks are recorded in the metadata instead of followed.
+	Exclude   []string  // Patterns of files the rule leaves out, besides those of -exclude.
+	Source    string    // Where the rule comes from, e.g. the profile name.
+}
+
+// String returns where the rule comes from and its pattern, to show why a
+// file was downloaded.
+func (rule collectRule) String() string {
+	if rule.Source == "" {
+		return rule.Pattern
+	}
+	return rule.Source + ": " + rule.Pattern
+}
+
+func (rule collectRule) sliced() bool {
+	return rule.TailBytes > 0 || rule.TailLines > 0 || !rule.Since.IsZero() || !rule.Until.IsZero()
+}
+
+// parseRule parses a rule given as PATTERN[;OPTION=VALUE...], with options
+// tail=SIZE (K, M and G suffixes allowed), lines=N, since=TIME, until=TIME,
+// maxsize=SIZE and follow=false, e.g.
+// "/var/log/messages*;since=2016-01-02T15:00:00Z;tail=64M".
+func parseRule(s string) (collectRule, error) {
+	parts := strings.Split(s, ";")
+	rule := collectRule{Pattern: parts[0]}
+	if rule.Pattern == "" {
+		return rule, fmt.Errorf("%s: missing pattern", s)
+	}
+	for _, o := range parts[1:] {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("%s: option %q has no value", s, o)
+		}
+		var err error
+		switch kv[0] {
+		case "tail":
+			rule.TailBytes, err = parseSize(kv[1])
+		case "lines":
+			rule.TailLines, err = strconv.Atoi(kv[1])
+		case "since":
+			rule.Since, err = parseTime(kv[1])
+		case "until":
+			rule.Until, err = parseTime(kv[1])
+		case "maxsize":
+			rule.MaxSize, err = parseSize(kv[1])
+		case "follow":
+			var follow bool
+			follow, err = strconv.ParseBool(kv[1])
+			rule.NoFollow = !follow
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return rule, fmt.Errorf("%s: %s: %v", s, kv[0], err)
+		}
+	}
+	return rule, nil
+}
+
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("negative size")
+	}
+	return n * mult, err
+}
+
+// parseTime accepts RFC 3339 times and, in UTC, "2006-01-02 15:04:05" and
+// plain dates.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q, expected e.g. 2006-01-02T15:04:05Z", s)
+}
+
+// ruleList is the -file flag, which can be given more than once.
+type ruleList []collectRule
+
+func (l *ruleList) String() string {
+	var patterns []string
+	for _, r := range *l {
+		patterns = append(patterns, r.Pattern)
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (l *ruleList) Set(v string) error {
+	rule, err := parseRule(v)
+	if err != nil {
+		return err
+	}
+	rule.Source = "-file"
+	*l = append(*l, rule)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected collectRule
+		err      bool
+	}{
+		{"/var/log/messages", collectRule{Pattern: "/var/log/messages"}, false},
+		{"/var/log/messages*;tail=16M", collectRule{Pattern: "/var/log/messages*", TailBytes: 16 << 20}, false},
+		{"/var/log/syslog;lines=100;maxsize=1g", collectRule{Pattern: "/var/log/syslog", TailLines: 100, MaxSize: 1 << 30}, false},
+		{"/var/log/messages;since=2016-01-02T15:00:00Z;until=2016-01-03",
+			collectRule{Pattern: "/var/log/messages",
+				Since: time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC),
+				Until: time.Date(2016, 1, 3, 0, 0, 0, 0, time.UTC)}, false},
+		{"/etc/**;follow=false", collectRule{Pattern: "/etc/**", NoFollow: true}, false},
+		{"/etc/**;follow=true", collectRule{Pattern: "/etc/**"}, false},
+		{"", collectRule{}, true},
+		{";tail=1M", collectRule{}, true},
+		{"/var/log/messages;tail", collectRule{}, true},
+		{"/var/log/messages;tail=lots", collectRule{}, true},
+		{"/var/log/messages;maxsize=-1M", collectRule{}, true},
+		{"/var/log/messages;since=yesterday", collectRule{}, true},
+		{"/var/log/messages;color=blue", collectRule{}, true},
+	}
+	for _, test := range tests {
+		rule, err := parseRule(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseRule(%q) = %+v, expected an error", test.s, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRule(%q): %v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(rule, test.expected) {
+			t.Errorf("parseRule(%q) = %+v, expected %+v", test.s, rule, test.expected)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected int64
+		err      bool
+	}{
+		{"512", 512, false},
+		{"4k", 4 << 10, false},
+		{"16M", 16 << 20, false},
+		{"2G", 2 << 30, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"1.5M", 0, true},
+		{"-1M", 0, true},
+		{"-512", 0, true},
+	}
+	for _, test := range tests {
+		n, err := parseSize(test.s)
+		if (err != nil) != test.err || (!test.err && n != test.expected) {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d (error: %v)", test.s, n, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected time.Time
+	}{
+		{"2016-01-02T15:04:05Z", time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2016-01-02T15:04:05+01:00", time.Date(2016, 1, 2, 14, 4, 5, 0, time.UTC)},
+		{"2016-01-02 15:04:05", time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2016-01-02T15:04:05", time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2016-01-02", time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		ts, err := parseTime(test.s)
+		if err != nil || !ts.Equal(test.expected) {
+			t.Errorf("parseTime(%q) = %v, %v, expected %v", test.s, ts, err, test.expected)
+		}
+	}
+	if _, err := parseTime("Jan 2 15:04"); err == nil {
+		t.Errorf("parseTime() of a syslog time did not fail")
+	}
+}
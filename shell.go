@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+// shell reads commands from the terminal and runs them on a session, so
+// that the disk is opened once and blocks read before come from the cache.
+type shell struct {
+	s  *session
+	ed *lineEditor
+}
+
+// shellBuiltins are the commands only the shell has, next to those in
+// commands.
+var shellBuiltins = []struct{ name, args, help string }{
+	{"cd", "[PATH]", "Change the current directory, to / if no path is given."},
+	{"pwd", "", "Show the current directory."},
+	{"use", "[PARTITION]", "Switch to the filesystem with this partition index, label, UUID or logical volume name, or back to the default one."},
+	{"less", "PATH", "Show a file a screen at a time, through $PAGER if set."},
+	{"history", "", "Show the commands entered so far."},
+	{"help", "", "Show this help."},
+	{"exit", "", "Leave the shell, as does Ctrl-D."},
+}
+
+func shellCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	sel := partitionFlag(f)
+	return func(s *session, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		root, err := s.dir(*sel)
+		if err != nil {
+			return err
+		}
+		s.cwd = &root
+
+		sh := &shell{s: s}
+		sh.ed = newLineEditor(sh.complete)
+		fmt.Printf("Type help for the commands, Ctrl-D to leave.\n")
+		for {
+			line, err := sh.ed.readLine(s.cwd.Path() + "> ")
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			words, err := splitWords(line)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERR: %v\n", err)
+				continue
+			}
+			if len(words) == 0 {
+				continue
+			}
+			if words[0] == "exit" || words[0] == "quit" {
+				return nil
+			}
+			if err := sh.exec(words); err != nil {
+				fmt.Fprintf(os.Stderr, "ERR: %v\n", err)
+			}
+		}
+	}
+}
+
+// exec runs a builtin or one of the commands.
+func (sh *shell) exec(words []string) error {
+	s := sh.s
+	name, args := words[0], words[1:]
+	switch name {
+	case "cd":
+		p := "/"
+		if len(args) > 0 {
+			p = args[0]
+		}
+		dir, err := s.cwd.ChangeDir(p)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		s.cwd = &dir
+		return nil
+	case "pwd":
+		fmt.Println(s.cwd.Path())
+		return nil
+	case "use":
+		sel := ""
+		if len(args) > 0 {
+			sel = args[0]
+		}
+		d, err := s.disk()
+		if err != nil {
+			return err
+		}
+		v, err := d.selectVolume(sel)
+		if err != nil {
+			return err
+		}
+		root, err := v.r.Root()
+		if err != nil {
+			return err
+		}
+		s.cwd = &root
+		fmt.Printf("Using %v\n", v)
+		return nil
+	case "less", "more":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: less PATH")
+		}
+		ir, err := openFile(*s.cwd, args[0])
+		if err != nil {
+			return err
+		}
+		return sh.page(ir)
+	case "history":
+		for i, h := range sh.ed.history {
+			fmt.Printf("%5d  %s\n", i+1, h)
+		}
+		return nil
+	case "help":
+		sh.help()
+		return nil
+	}
+
+	c := findCommand(name)
+	if c == nil || c.noShell {
+		return fmt.Errorf("unknown command %q, see help", name)
+	}
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s\n", c.name, c.args)
+		f.PrintDefaults()
+	}
+	run := c.setup(f)
+	if err := f.Parse(args); err != nil {
+		// already reported by f
+		return nil
+	}
+	err := run(s, f.Args())
+	if err == errUsage {
+		f.Usage()
+		return nil
+	}
+	return err
+}
+
+func (sh *shell) help() {
+	fmt.Printf("Commands:\n")
+	for _, b := range shellBuiltins {
+		fmt.Printf("  %-30s%s\n", strings.TrimSpace(b.name+" "+b.args), b.help)
+	}
+	for _, c := range commands {
+		if !c.noShell {
+			fmt.Printf("  %-30s%s\n", strings.TrimSpace(c.name+" [flags] "+c.args), c.help)
+		}
+	}
+	fmt.Printf("Relative paths are relative to the current directory. Run a command with -help for its flags.\n")
+}
+
+// complete returns the commands or the paths starting with the last word of
+// before, for the line editor.
+func (sh *shell) complete(before string) (int, []string) {
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	var rv []string
+
+	if strings.TrimSpace(before[:start]) == "" {
+		for _, b := range shellBuiltins {
+			if strings.HasPrefix(b.name, word) {
+				rv = append(rv, b.name)
+			}
+		}
+		for _, c := range commands {
+			if !c.noShell && strings.HasPrefix(c.name, word) {
+				rv = append(rv, c.name)
+			}
+		}
+		sort.Strings(rv)
+		return start, rv
+	}
+
+	dirPart, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dirPart, prefix = word[:i+1], word[i+1:]
+	}
+	prefix = strings.Replace(prefix, `\ `, " ", -1)
+	dir := *sh.s.cwd
+	if dirPart != "" {
+		d, err := dir.ChangeDir(strings.Replace(dirPart, `\ `, " ", -1))
+		if err != nil {
+			return start, nil
+		}
+		dir = d
+	}
+	entries, err := dir.Entries()
+	if err != nil {
+		return start, nil
+	}
+	for _, e := range entries {
+		name := e.Name.String()
+		if name == "." || name == ".." || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		c := dirPart + strings.Replace(name, " ", `\ `, -1)
+		if e.FileType == ext4.FileTypeDir {
+			c += "/"
+		}
+		rv = append(rv, c)
+	}
+	sort.Strings(rv)
+	return start, rv
+}
+
+// page shows r through $PAGER, or less, if there is one, and otherwise a
+// screen at a time, until q is pressed.
+func (sh *shell) page(r io.Reader) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	if args := strings.Fields(pager); len(args) > 0 {
+		if p, err := exec.LookPath(args[0]); err == nil {
+			cmd := exec.Command(p, args[1:]...)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = r, os.Stdout, os.Stderr
+			return cmd.Run()
+		}
+	}
+
+	rows := terminalRows(os.Stdout.Fd())
+	if rows < 2 {
+		rows = 24
+	}
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		fmt.Print(line)
+		if err == io.EOF {
+			if line != "" {
+				fmt.Println()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n%(rows-1) == 0 {
+			fmt.Print("--More--")
+			key, err := sh.ed.readKey()
+			fmt.Print("\r\x1b[K")
+			if err != nil || key == 'q' {
+				return nil
+			}
+		}
+	}
+}
+
+// splitWords splits a command line into words at spaces, except where they
+// are in single or double quotes or escaped with a backslash.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word []rune
+	inWord := false
+	var quote rune
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word = append(word, r)
+			}
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word = append(word, runes[i])
+			inWord = true
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = nil, false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bytes read per probe when searching log files, and at most one record
+const probeSize = 64 * 1024
+
+// reading backwards for tail -n goes in larger steps
+const tailChunkSize = 1024 * 1024
+
+// logFormat describes how records of a log file are separated and where
+// their time stamps are.
+type logFormat struct {
+	name      string
+	separator []byte
+	// timestamp returns the time of a record; ref is the modification time
+	// of the file, for formats that leave out the year.
+	timestamp func(record []byte, ref time.Time) (time.Time, bool)
+}
+
+var logFormats = []logFormat{
+	{"journal-export", []byte("\n\n"), journalTimestamp},
+	{"iso8601", []byte("\n"), isoTimestamp},
+	{"syslog", []byte("\n"), syslogTimestamp},
+}
+
+// journalTimestamp reads __REALTIME_TIMESTAMP (microseconds since the epoch)
+// of an entry in journalctl -o export format.
+func journalTimestamp(record []byte, ref time.Time) (time.Time, bool) {
+	for _, line := range bytes.Split(record, []byte("\n")) {
+		if v := bytes.TrimPrefix(line, []byte("__REALTIME_TIMESTAMP=")); len(v) < len(line) {
+			usec, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return time.Unix(usec/1e6, usec%1e6*1e3).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999",
+}
+
+// isoTimestamp parses an ISO-8601 time at the start of a line, as written by
+// rsyslog's high precision format, waagent, cloud-init and the like. Times
+// without a zone are taken as UTC.
+func isoTimestamp(record []byte, ref time.Time) (time.Time, bool) {
+	if len(record) < 19 || record[0] < '0' || record[0] > '9' {
+		return time.Time{}, false
+	}
+	if len(record) > 40 {
+		record = record[:40]
+	}
+	fields := strings.SplitN(string(record), " ", 3)
+	candidates := []string{fields[0]}
+	if len(fields) > 1 {
+		candidates = append(candidates, fields[0]+" "+fields[1])
+	}
+	for _, c := range candidates {
+		for _, layout := range isoLayouts {
+			if t, err := time.Parse(layout, c); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// syslogTimestamp parses the BSD syslog time stamp ("Jan  2 15:04:05"). It
+// has no year, so the one that puts the record before ref is used.
+func syslogTimestamp(record []byte, ref time.Time) (time.Time, bool) {
+	if len(record) < len(time.Stamp) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.Stamp, string(record[:len(time.Stamp)]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(ref.Year(), 0, 0)
+	if t.After(ref.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+// detectLogFormat returns the format of the first record of r.
+func detectLogFormat(r io.ReaderAt, size int64, ref time.Time) (logFormat, error) {
+	buf := make([]byte, probeSize)
+	if size < probeSize {
+		buf = buf[:size]
+	}
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return logFormat{}, err
+	}
+	for _, f := range logFormats {
+		record := buf
+		if i := bytes.Index(buf, f.separator); i >= 0 {
+			record = buf[:i]
+		}
+		if _, ok := f.timestamp(record, ref); ok {
+			return f, nil
+		}
+	}
+	return logFormat{}, fmt.Errorf("no known time stamp format")
+}
+
+// recordAt finds the first record starting at or after off that has a time
+// stamp. It returns ok=false if there is none.
+func (f logFormat) recordAt(r io.ReaderAt, size, off int64, ref time.Time) (start int64, ts time.Time, ok bool, err error) {
+	buf := make([]byte, probeSize)
+	sep := int64(len(f.separator))
+
+	// align to the start of a record
+	start = off
+	for start > 0 && start < size {
+		from := start - sep
+		if from < 0 {
+			from = 0
+		}
+		n, err := r.ReadAt(buf, from)
+		if err != nil && err != io.EOF {
+			return 0, ts, false, err
+		}
+		if i := bytes.Index(buf[:n], f.separator); i >= 0 {
+			start = from + int64(i) + sep
+			break
+		}
+		start = from + int64(n) - sep + 1
+		if n < len(buf) {
+			start = size
+		}
+	}
+
+	for start < size {
+		n, err := r.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return 0, ts, false, err
+		}
+		record := buf[:n]
+		next := start + int64(n)
+		if i := bytes.Index(record, f.separator); i >= 0 {
+			record = record[:i]
+			next = start + int64(i) + sep
+		}
+		if ts, ok := f.timestamp(record, ref); ok {
+			return start, ts, true, nil
+		}
+		start = next
+	}
+	return size, ts, false, nil
+}
+
+// searchRecords returns the start of the first record whose time stamp
+// satisfies pred, or size if there is none, assuming the records are sorted
+// by time. Each of the about log2(size) probes reads up to probeSize bytes,
+// rather than reading the whole file.
+func (f logFormat) searchRecords(r io.ReaderAt, size int64, ref time.Time, pred func(time.Time) bool) (int64, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, ts, ok, err := f.recordAt(r, size, mid, ref)
+		if err != nil {
+			return 0, err
+		}
+		if ok && !pred(ts) {
+			lo = start + 1
+		} else {
+			hi = mid
+		}
+	}
+	start, _, _, err := f.recordAt(r, size, lo, ref)
+	return start, err
+}
+
+// tailLines returns the offset of the start of the last n lines in the
+// range [from, to) of r, reading backwards from the end.
+func tailLines(r io.ReaderAt, from, to int64, n int) (int64, error) {
+	buf := make([]byte, tailChunkSize)
+	end := to
+	// a newline at the very end does not start another line
+	skip := true
+	for end > from {
+		start := end - int64(len(buf))
+		if start < from {
+			start = from
+		}
+		chunk := buf[:end-start]
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' {
+				skip = false
+				continue
+			}
+			if skip {
+				skip = false
+				continue
+			}
+			if n--; n == 0 {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return from, nil
+}
+
+// sliceRange returns the part of a file of the given size that rule asks
+// for: the records between Since and Until, then of those the last TailLines
+// lines and at most TailBytes bytes. Selecting by time fails for files
+// without recognizable time stamps.
+func (rule collectRule) sliceRange(r io.ReaderAt, size int64, mtime time.Time) (start, end int64, err error) {
+	start, end = 0, size
+	if !rule.Since.IsZero() || !rule.Until.IsZero() {
+		f, err := detectLogFormat(r, size, mtime)
+		if err != nil {
+			return 0, size, err
+		}
+		if !rule.Since.IsZero() {
+			if start, err = f.searchRecords(r, size, mtime, func(t time.Time) bool { return !t.Before(rule.Since) }); err != nil {
+				return 0, size, err
+			}
+		}
+		if !rule.Until.IsZero() {
+			if end, err = f.searchRecords(r, size, mtime, func(t time.Time) bool { return t.After(rule.Until) }); err != nil {
+				return 0, size, err
+			}
+		}
+		if end < start {
+			end = start
+		}
+	}
+	if rule.TailLines > 0 {
+		if start, err = tailLines(r, start, end, rule.TailLines); err != nil {
+			return 0, size, err
+		}
+	}
+	if rule.TailBytes > 0 && end-start > rule.TailBytes {
+		start = end - rule.TailBytes
+	}
+	return start, end, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	ref := time.Date(2016, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		parse    func([]byte, time.Time) (time.Time, bool)
+		record   string
+		expected time.Time // Zero if the record has no time stamp.
+	}{
+		{"iso", isoTimestamp, "2016-01-02T15:04:05.123456+00:00 host sshd[1]: hello", time.Date(2016, 1, 2, 15, 4, 5, 123456000, time.UTC)},
+		{"iso", isoTimestamp, "2016-01-02T15:04:05Z hello", time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"iso", isoTimestamp, "2016-01-02 15:04:05,250 INFO Daemon", time.Date(2016, 1, 2, 15, 4, 5, 250000000, time.UTC)},
+		{"iso", isoTimestamp, "2016-01-02 15:04:05.5 cloud-init", time.Date(2016, 1, 2, 15, 4, 5, 500000000, time.UTC)},
+		{"iso", isoTimestamp, "Jan  2 15:04:05 host kernel: hello", time.Time{}},
+		{"iso", isoTimestamp, "2016", time.Time{}},
+		{"syslog", syslogTimestamp, "Jan  2 15:04:05 host kernel: hello", time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		// a December record in a log written in January is from last year
+		{"syslog", syslogTimestamp, "Dec 31 23:59:59 host kernel: hello", time.Date(2015, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"syslog", syslogTimestamp, "hello", time.Time{}},
+		{"journal", journalTimestamp, "__CURSOR=s=1\n__REALTIME_TIMESTAMP=1451747045000001\nMESSAGE=hello", time.Date(2016, 1, 2, 15, 4, 5, 1000, time.UTC)},
+		{"journal", journalTimestamp, "MESSAGE=hello", time.Time{}},
+	}
+	for _, test := range tests {
+		ts, ok := test.parse([]byte(test.record), ref)
+		if ok != !test.expected.IsZero() || !ts.Equal(test.expected) {
+			t.Errorf("%sTimestamp(%q) = %v, %v, expected %v", test.name, test.record, ts, ok, test.expected)
+		}
+	}
+}
+
+// testLog returns a log with one record a minute from start, in the format
+// of name.
+func testLog(name string, start time.Time, records int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < records; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		switch name {
+		case "syslog":
+			fmt.Fprintf(&b, "%s host app[%d]: record %d\n", ts.Format(time.Stamp), i, i)
+		case "iso8601":
+			fmt.Fprintf(&b, "%s host app[%d]: record %d\n", ts.Format(time.RFC3339), i, i)
+		case "journal-export":
+			fmt.Fprintf(&b, "__REALTIME_TIMESTAMP=%d\nMESSAGE=record %d\n\n", ts.UnixNano()/1e3, i)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestSliceRange(t *testing.T) {
+	start := time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)
+	// large enough for the search to take many probes
+	const records = 20000
+	mtime := start.Add(records * time.Minute)
+	for _, format := range []string{"syslog", "iso8601", "journal-export"} {
+		log := testLog(format, start, records)
+		r := bytes.NewReader(log)
+
+		f, err := detectLogFormat(r, int64(len(log)), mtime)
+		if err != nil || f.name != format {
+			t.Errorf("detectLogFormat() = %s, %v, expected %s", f.name, err, format)
+			continue
+		}
+
+		tests := []struct {
+			rule       collectRule
+			first      int // Record the slice starts with, -1 if empty.
+			last       int // Record the slice ends with.
+			lastRecord bool
+		}{
+			{collectRule{Since: start.Add(100 * time.Minute)}, 100, 19999, true},
+			{collectRule{Since: start.Add(100*time.Minute + time.Second)}, 101, 19999, true},
+			{collectRule{Until: start.Add(100 * time.Minute)}, 0, 100, false},
+			{collectRule{Since: start.Add(10 * time.Minute), Until: start.Add(19 * time.Minute)}, 10, 19, false},
+			{collectRule{Since: start.Add(-time.Hour)}, 0, 19999, true},
+			{collectRule{Since: start.Add(30 * 24 * time.Hour)}, -1, 0, false},
+			{collectRule{Since: start.Add(10 * time.Minute), TailLines: 3}, 19997, 19999, true},
+		}
+		for _, test := range tests {
+			if format == "journal-export" && test.rule.TailLines > 0 {
+				continue // lines are not records there
+			}
+			from, to, err := test.rule.sliceRange(r, int64(len(log)), mtime)
+			if err != nil {
+				t.Errorf("%s: sliceRange(%+v): %v", format, test.rule, err)
+				continue
+			}
+			slice := log[from:to]
+			if test.first < 0 {
+				if len(slice) != 0 {
+					t.Errorf("%s: sliceRange(%+v) = %q, expected nothing", format, test.rule, slice)
+				}
+				continue
+			}
+			if !bytes.Contains(slice, []byte(fmt.Sprintf("record %d\n", test.first))) ||
+				bytes.Contains(slice, []byte(fmt.Sprintf("record %d\n", test.first-1))) ||
+				!bytes.Contains(slice, []byte(fmt.Sprintf("record %d\n", test.last))) ||
+				bytes.Contains(slice, []byte(fmt.Sprintf("record %d\n", test.last+1))) {
+				t.Errorf("%s: sliceRange(%+v) = [%d, %d), expected records %d to %d", format, test.rule, from, to, test.first, test.last)
+			}
+			if test.lastRecord != (to == int64(len(log))) {
+				t.Errorf("%s: sliceRange(%+v) ends at %d of %d", format, test.rule, to, len(log))
+			}
+		}
+	}
+
+	if _, _, err := (collectRule{Since: start}).sliceRange(bytes.NewReader([]byte("no time here\n")), 13, mtime); err == nil {
+		t.Errorf("sliceRange() by time of a file without time stamps did not fail")
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	tests := []struct {
+		content  string
+		n        int
+		expected string
+	}{
+		{"a\nb\nc\n", 1, "c\n"},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc\n", 5, "a\nb\nc\n"},
+		{"\n\n\n", 2, "\n\n"},
+		{"", 1, ""},
+	}
+	for _, test := range tests {
+		from, err := tailLines(bytes.NewReader([]byte(test.content)), 0, int64(len(test.content)), test.n)
+		if err != nil || test.content[from:] != test.expected {
+			t.Errorf("tailLines(%q, %d) = %q, %v, expected %q", test.content, test.n, test.content[from:], err, test.expected)
+		}
+	}
+
+	// across chunks
+	var b bytes.Buffer
+	for i := 0; i < 300000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	from, err := tailLines(bytes.NewReader(b.Bytes()), 0, int64(b.Len()), 200000)
+	if err != nil || !bytes.HasPrefix(b.Bytes()[from:], []byte("line 100000\n")) {
+		t.Errorf("tailLines() of 300000 lines, 200000 of them, starts at %q, %v", b.Bytes()[from:from+12], err)
+	}
+}
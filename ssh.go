@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+const resetSSHRemediation = "Reset the SSH configuration or a user's key with the VMAccess extension (az vm user reset-ssh, az vm user update), or "
+
+// sshd_config keywords of OpenSSH and the patches of the usual
+// distributions, lower case, by whether they may appear in a Match block
+var (
+	sshdMatchKeywords = keywordSet(`acceptenv allowagentforwarding allowgroups allowstreamlocalforwarding
+		allowtcpforwarding allowusers authenticationmethods authorizedkeyscommand authorizedkeyscommanduser
+		authorizedkeysfile authorizedprincipalscommand authorizedprincipalscommanduser authorizedprincipalsfile
+		banner casignaturealgorithms challengeresponseauthentication channeltimeout chrootdirectory
+		clientalivecountmax clientaliveinterval denygroups denyusers disableforwarding exposeauthinfo forcecommand
+		gatewayports gssapiauthentication hostbasedacceptedalgorithms hostbasedacceptedkeytypes
+		hostbasedauthentication hostbasedusesnamefrompacketonly ignorerhosts include ipqos
+		kbdinteractiveauthentication kerberosauthentication kerberosusekuserok loglevel maxauthtries maxsessions
+		pamservicename passwordauthentication permitemptypasswords permitlisten permitopen permitrootlogin permittty
+		permittunnel permituserrc pubkeyacceptedalgorithms pubkeyacceptedkeytypes pubkeyauthentication
+		pubkeyauthoptions rdomain rekeylimit revokedkeys setenv streamlocalbindmask streamlocalbindunlink
+		trustedusercakeys unusedconnectiontimeout x11displayoffset x11forwarding x11uselocalhost`)
+	sshdGlobalKeywords = keywordSet(`addressfamily ciphers compression debianbanner fingerprinthash
+		gssapicleanupcredentials gssapienablek5users gssapikexalgorithms gssapikeyexchange
+		gssapistorecredentialsonrekey gssapistrictacceptorcheck hostcertificate hostkey hostkeyagent
+		hostkeyalgorithms ignoreuserknownhosts kerberosgetafstoken kerberosorlocalpasswd kerberosticketcleanup
+		kexalgorithms listenaddress logingracetime logverbose macs match maxstartups modulifile permituserenvironment
+		persourcemaxstartups persourcenetblocksize persourcepenalties persourcepenaltyexemptlist pidfile port
+		printlastlog printmotd requiredrsasize securitykeyprovider sshdsessionpath strictmodes subsystem
+		syslogfacility tcpkeepalive usedns usepam versionaddendum xauthlocation`)
+	// sshd ignores these with a warning
+	sshdDeprecatedKeywords = keywordSet(`afstokenpassing authorizedkeysfile2 checkmail dsaauthentication
+		keyregenerationinterval kerberostgtpassing pamauthenticationviakbdint protocol reversemappingcheck
+		rhostsauthentication rhostsrsaauthentication rsaauthentication serverkeybits showpatchlevel skeyauthentication
+		uselogin useprivilegeseparation useroaming verifyreversemapping`)
+	sshdFlagKeywords = keywordSet(`allowagentforwarding challengeresponseauthentication disableforwarding
+		exposeauthinfo gssapiauthentication gssapicleanupcredentials hostbasedauthentication
+		kbdinteractiveauthentication kerberosauthentication passwordauthentication permitemptypasswords
+		permittty permituserrc printlastlog printmotd pubkeyauthentication strictmodes tcpkeepalive usedns usepam
+		x11forwarding x11uselocalhost`)
+	sshdMatchCriteria = keywordSet(`all user group host localaddress localport rdomain address invalid-user`)
+)
+
+func keywordSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, k := range strings.Fields(s) {
+		set[k] = true
+	}
+	return set
+}
+
+// sshdDirective is a line of sshd_config or of a file it includes.
+type sshdDirective struct {
+	File    string
+	Line    int
+	Keyword string // Lower case.
+	Args    []string
+	Match   string // The Match line it is under, empty outside of Match blocks.
+}
+
+func (d sshdDirective) String() string {
+	return fmt.Sprintf("%s line %d", d.File, d.Line)
+}
+
+// sshdConfig is sshd_config with the files it includes, in the order sshd
+// reads them.
+type sshdConfig struct {
+	Directives []sshdDirective
+}
+
+// value returns the arguments of the first keyword outside of Match blocks,
+// which is the one sshd uses, or def if there is none.
+func (c *sshdConfig) value(keyword, def string) string {
+	if d := c.first(keyword); d != nil {
+		return strings.Join(d.Args, " ")
+	}
+	return def
+}
+
+func (c *sshdConfig) first(keyword string) *sshdDirective {
+	for i, d := range c.Directives {
+		if d.Keyword == keyword && d.Match == "" && len(d.Args) > 0 {
+			return &c.Directives[i]
+		}
+	}
+	return nil
+}
+
+// all returns every keyword outside of Match blocks, for those that add up,
+// like HostKey and AllowUsers.
+func (c *sshdConfig) all(keyword string) []sshdDirective {
+	var rv []sshdDirective
+	for _, d := range c.Directives {
+		if d.Keyword == keyword && d.Match == "" {
+			rv = append(rv, d)
+		}
+	}
+	return rv
+}
+
+// parseSshdConfig reads the sshd_config file p in fsys and the files it
+// includes, and adds findings for what keeps sshd from starting.
+func parseSshdConfig(a *analysis, c *sshdConfig, p string, depth int, match string) error {
+	b, err := fs.ReadFile(a.fsys, strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		keyword, args, err := splitSshdLine(s.Text())
+		d := sshdDirective{File: p, Line: line, Keyword: strings.ToLower(keyword), Args: args, Match: match}
+		if err != nil {
+			a.add(severityError, "sshd-syntax", d.String(), "Fix the quoting of the line, or remove it.", "%v, sshd does not start", err)
+			continue
+		}
+		if keyword == "" {
+			continue
+		}
+		switch {
+		case sshdDeprecatedKeywords[d.Keyword]:
+			a.add(severityInfo, "sshd-deprecated", d.String(), "", "%s is deprecated, sshd ignores it", keyword)
+			continue
+		case !sshdMatchKeywords[d.Keyword] && !sshdGlobalKeywords[d.Keyword]:
+			// patched sshd builds know more options than these
+			a.add(severityWarning, "sshd-syntax", d.String(), "Check the configuration with sshd -t in a rescue VM, and remove the line if sshd rejects it.",
+				"unknown configuration option %s; if this sshd does not know it either, it does not start", keyword)
+			continue
+		case len(args) == 0:
+			a.add(severityError, "sshd-syntax", d.String(), resetSSHRemediation+"fix the line in a rescue VM.",
+				"%s has no value, sshd does not start", keyword)
+			continue
+		case match != "" && !sshdMatchKeywords[d.Keyword] && d.Keyword != "match":
+			a.add(severityError, "sshd-syntax", d.String(), "Move the line above the first Match line.",
+				"%s is not allowed within a Match block, sshd does not start", keyword)
+			continue
+		case sshdFlagKeywords[d.Keyword] && !isSshdFlag(args[0]):
+			a.add(severityError, "sshd-syntax", d.String(), fmt.Sprintf("Set %s to yes or no.", keyword),
+				"%s %s is neither yes nor no, sshd does not start", keyword, args[0])
+			continue
+		}
+
+		switch d.Keyword {
+		case "match":
+			match = strings.Join(args, " ")
+			d.Match = match
+			if checkMatch(a, d) {
+				match = "" // Match all ends the conditional block
+			}
+		case "include":
+			if depth >= 16 {
+				a.add(severityError, "sshd-include", d.String(), "Remove the include loop.", "includes are nested too deep, sshd does not start")
+				continue
+			}
+			for _, pattern := range args {
+				if !strings.HasPrefix(pattern, "/") {
+					pattern = "/etc/ssh/" + pattern
+				}
+				files, err := fs.Glob(a.fsys, strings.TrimPrefix(pattern, "/"))
+				if err != nil {
+					a.add(severityError, "sshd-include", d.String(), "", "bad pattern %s: %v", pattern, err)
+					continue
+				}
+				if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+					a.add(severityInfo, "sshd-include", d.String(), "", "%s does not exist", pattern)
+				}
+				for _, f := range files {
+					if err := parseSshdConfig(a, c, "/"+f, depth+1, match); err != nil {
+						a.add(severityWarning, "sshd-include", d.String(), "", "cannot read %s: %v", f, err)
+					}
+				}
+			}
+		case "port":
+			if n, err := strconv.Atoi(args[0]); err != nil || n < 1 || n > 65535 {
+				a.add(severityError, "sshd-syntax", d.String(), resetSSHRemediation+"fix the port in a rescue VM.", "bad port %s, sshd does not start", args[0])
+			}
+		}
+		c.Directives = append(c.Directives, d)
+	}
+	return nil
+}
+
+// checkMatch checks the criteria of a Match line, and reports whether it is
+// Match all.
+func checkMatch(a *analysis, d sshdDirective) bool {
+	for i := 0; i < len(d.Args); i++ {
+		criterion := strings.ToLower(d.Args[i])
+		if !sshdMatchCriteria[criterion] {
+			a.add(severityError, "sshd-syntax", d.String(), "Fix the Match line, see man sshd_config.",
+				"unsupported Match attribute %s, sshd does not start", d.Args[i])
+			return false
+		}
+		if criterion == "all" || criterion == "invalid-user" {
+			continue
+		}
+		if i++; i == len(d.Args) {
+			a.add(severityError, "sshd-syntax", d.String(), "Fix the Match line, see man sshd_config.",
+				"Match %s has no value, sshd does not start", d.Args[i-1])
+			return false
+		}
+	}
+	return len(d.Args) == 1 && strings.EqualFold(d.Args[0], "all")
+}
+
+func isSshdFlag(s string) bool {
+	return strings.EqualFold(s, "yes") || strings.EqualFold(s, "no")
+}
+
+// splitSshdLine splits a line of sshd_config into the keyword and its
+// arguments, which may be quoted, and may be separated from the keyword by
+// "=".
+func splitSshdLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, nil, nil
+	}
+	keyword, rest := line[:i], strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	var args []string
+	var arg strings.Builder
+	inArg, quote := false, byte(0)
+	for i := 0; i < len(rest); i++ {
+		ch := rest[i]
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteByte(ch)
+		case ch == '"' || ch == '\'':
+			quote, inArg = ch, true
+		case ch == '#' && !inArg:
+			i = len(rest)
+		case ch == ' ' || ch == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(ch)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return keyword, nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return keyword, args, nil
+}
+
+// passwdEntry is a line of /etc/passwd.
+type passwdEntry struct {
+	Name     string
+	UID, GID uint32
+	Home     string
+	Shell    string
+}
+
+// canLogIn tells users with a shell apart from system accounts.
+func (u passwdEntry) canLogIn() bool {
+	for _, s := range []string{"nologin", "false", "sync", "shutdown", "halt"} {
+		if path.Base(u.Shell) == s {
+			return false
+		}
+	}
+	return u.UID == 0 || u.UID >= 1000
+}
+
+func parsePasswd(b []byte) []passwdEntry {
+	var rv []passwdEntry
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) < 7 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uid, err := strconv.ParseUint(f[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		gid, _ := strconv.ParseUint(f[3], 10, 32)
+		rv = append(rv, passwdEntry{Name: f[0], UID: uint32(uid), GID: uint32(gid), Home: f[5], Shell: f[6]})
+	}
+	return rv
+}
+
+// parseGroups reads /etc/group into the members of each group, by name.
+// The primary group of a user is not listed there.
+func parseGroups(b []byte) map[string][]string {
+	groups := map[string][]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) < 4 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		groups[f[0]] = nil
+		if f[3] != "" {
+			groups[f[0]] = strings.Split(f[3], ",")
+		}
+	}
+	return groups
+}
+
+// analyzeSSH checks that sshd starts and that users can log in: the
+// configuration, the host keys, and the ownership and modes of the
+// authorized keys of each user, as sshd checks them.
+func analyzeSSH(a *analysis) {
+	if a.root == nil {
+		return
+	}
+	if _, err := fs.Stat(a.fsys, "usr/sbin/sshd"); err != nil {
+		a.add(severityError, "sshd", "/usr/sbin/sshd", "Install the openssh-server package in a rescue VM.",
+			"sshd is not installed: %v", err)
+		return
+	}
+	c := &sshdConfig{}
+	if err := parseSshdConfig(a, c, "/etc/ssh/sshd_config", 0, ""); err != nil {
+		a.add(severityError, "sshd-config", "/etc/ssh/sshd_config", resetSSHRemediation+"restore it from the openssh-server package in a rescue VM.",
+			"cannot read sshd_config, sshd does not start: %v", err)
+		return
+	}
+	b, err := fs.ReadFile(a.fsys, "etc/passwd")
+	if err != nil {
+		a.add(severityError, "passwd", "/etc/passwd", "Restore /etc/passwd in a rescue VM.", "cannot read /etc/passwd, nobody can log in: %v", err)
+		return
+	}
+	users := parsePasswd(b)
+	b, _ = fs.ReadFile(a.fsys, "etc/group")
+	groups := parseGroups(b)
+
+	checkSshdSettings(a, c)
+	checkHostKeys(a, c)
+	allowed := checkAllowedUsers(a, c, users, groups)
+	keys := 0
+	for _, u := range allowed {
+		keys += checkAuthorizedKeys(a, c, u)
+	}
+	passwords := strings.EqualFold(c.value("passwordauthentication", "yes"), "yes") ||
+		(strings.EqualFold(c.value("kbdinteractiveauthentication", c.value("challengeresponseauthentication", "yes")), "yes") &&
+			strings.EqualFold(c.value("usepam", "no"), "yes"))
+	pubkeys := strings.EqualFold(c.value("pubkeyauthentication", "yes"), "yes")
+	switch {
+	case !passwords && !pubkeys:
+		a.add(severityError, "ssh-lockout", "/etc/ssh/sshd_config", resetSSHRemediation+"enable PubkeyAuthentication in a rescue VM.",
+			"both password and public key authentication are off, nobody can log in")
+	case !passwords && keys == 0 && strings.EqualFold(c.value("authorizedkeyscommand", "none"), "none"):
+		a.add(severityError, "ssh-lockout", "/etc/ssh/sshd_config", resetSSHRemediation+"add a public key for a user.",
+			"password authentication is off and no user that may log in has a usable authorized key")
+	}
+}
+
+// checkSshdSettings reports settings that expose the VM, or keep it from
+// being reached.
+func checkSshdSettings(a *analysis, c *sshdConfig) {
+	for _, d := range c.Directives {
+		value := strings.ToLower(strings.Join(d.Args, " "))
+		where := "globally"
+		if d.Match != "" {
+			where = "for Match " + d.Match
+		}
+		// only the first value outside of Match blocks counts, but sshd
+		// listens on every Port and ListenAddress
+		first := c.first(d.Keyword)
+		if d.Match == "" && first != nil && (first.File != d.File || first.Line != d.Line) &&
+			d.Keyword != "port" && d.Keyword != "listenaddress" {
+			continue
+		}
+		switch {
+		case d.Keyword == "permitrootlogin" && value == "yes":
+			a.add(severityWarning, "sshd-root-login", d.String(), "Set PermitRootLogin prohibit-password, or no, and log in as a user with sudo.",
+				"root may log in with a password %s", where)
+		case d.Keyword == "passwordauthentication" && value == "yes":
+			a.add(severityWarning, "sshd-password", d.String(), "Use public keys and set PasswordAuthentication no.",
+				"password logins are allowed %s, which invites brute force attacks", where)
+		case d.Keyword == "permitemptypasswords" && value == "yes":
+			a.add(severityWarning, "sshd-empty-password", d.String(), "Set PermitEmptyPasswords no.",
+				"accounts without a password may log in %s", where)
+		case d.Keyword == "port" && value != "22":
+			a.add(severityInfo, "sshd-port", d.String(), "Check that the network security group allows the port.", "sshd listens on port %s", value)
+		case d.Keyword == "listenaddress" && !strings.HasPrefix(value, "0.0.0.0") && !strings.HasPrefix(value, "::") && !strings.HasPrefix(value, "[::]"):
+			a.add(severityWarning, "sshd-listen", d.String(), "Remove the ListenAddress line, private IP addresses of Azure VMs may change.",
+				"sshd only listens on %s", value)
+		case d.Keyword == "strictmodes" && value == "no":
+			a.add(severityInfo, "sshd-strict-modes", d.String(), "", "sshd does not check the ownership and modes of users' files")
+		}
+	}
+	if c.first("passwordauthentication") == nil {
+		a.add(severityWarning, "sshd-password", "/etc/ssh/sshd_config", "Use public keys and set PasswordAuthentication no.",
+			"password logins are allowed by default, which invites brute force attacks")
+	}
+}
+
+// checkHostKeys checks that sshd can load a host key, which it needs to
+// start.
+func checkHostKeys(a *analysis, c *sshdConfig) {
+	var keys []string
+	explicit := false
+	for _, d := range c.all("hostkey") {
+		keys, explicit = append(keys, d.Args...), true
+	}
+	if !explicit {
+		keys = []string{"/etc/ssh/ssh_host_rsa_key", "/etc/ssh/ssh_host_ecdsa_key", "/etc/ssh/ssh_host_ed25519_key"}
+	}
+	loadable := 0
+	for _, k := range keys {
+		fi, err := statInode(a.fsys, k)
+		if err != nil {
+			if explicit {
+				a.add(severityWarning, "sshd-host-key", k, "Remove the HostKey line, or create the key with ssh-keygen -A.",
+					"host key is missing: %v", err)
+			}
+			continue
+		}
+		mode := fi.Mode.FileMode().Perm()
+		// Red Hat lets sshd read keys of the ssh_keys group
+		if mode&077 != 0 && !(mode&007 == 0 && fi.GID() != 0 && groupName(a.fsys, fi.GID()) == "ssh_keys") {
+			a.add(severityError, "sshd-host-key", k, fmt.Sprintf("chmod 600 %s in a rescue VM.", k),
+				"permissions %04o are too open, sshd does not use the key", mode)
+			continue
+		}
+		if fi.UID() != 0 {
+			a.add(severityWarning, "sshd-host-key", k, fmt.Sprintf("chown root %s in a rescue VM.", k),
+				"the key is owned by uid %d, not root", fi.UID())
+		}
+		loadable++
+	}
+	if loadable == 0 {
+		a.add(severityError, "sshd-host-key", "/etc/ssh", resetSSHRemediation+"create host keys with ssh-keygen -A in a rescue VM; some distributions create them at boot.",
+			"no usable host key, sshd does not start")
+	}
+}
+
+// checkAllowedUsers returns the users that may log in: those with a login
+// shell, limited by AllowUsers, AllowGroups, DenyUsers and PermitRootLogin.
+func checkAllowedUsers(a *analysis, c *sshdConfig, users []passwdEntry, groups map[string][]string) []passwdEntry {
+	patterns := func(keyword string) []string {
+		var rv []string
+		for _, d := range c.all(keyword) {
+			for _, p := range d.Args {
+				// the host part of user@host is not known here
+				rv = append(rv, strings.SplitN(p, "@", 2)[0])
+			}
+		}
+		return rv
+	}
+	matchAny := func(ps []string, s string) bool {
+		for _, p := range ps {
+			if ok, _ := path.Match(p, s); ok {
+				return true
+			}
+		}
+		return false
+	}
+	inGroup := func(u passwdEntry, group string) bool {
+		for _, m := range groups[group] {
+			if m == u.Name {
+				return true
+			}
+		}
+		return groupName(a.fsys, u.GID) == group
+	}
+	allowUsers, allowGroups, denyUsers := patterns("allowusers"), patterns("allowgroups"), patterns("denyusers")
+	var allowed []passwdEntry
+	for _, u := range users {
+		if !u.canLogIn() {
+			continue
+		}
+		if u.UID == 0 && strings.EqualFold(c.value("permitrootlogin", "prohibit-password"), "no") {
+			continue
+		}
+		if len(allowUsers) > 0 && !matchAny(allowUsers, u.Name) || matchAny(denyUsers, u.Name) {
+			continue
+		}
+		if len(allowGroups) > 0 {
+			ok := false
+			for _, g := range allowGroups {
+				for name := range groups {
+					if m, _ := path.Match(g, name); m && inGroup(u, name) {
+						ok = true
+					}
+				}
+			}
+			if !ok {
+				continue
+			}
+		}
+		allowed = append(allowed, u)
+	}
+	if len(allowUsers)+len(allowGroups)+len(denyUsers) > 0 {
+		var names []string
+		for _, u := range allowed {
+			names = append(names, u.Name)
+		}
+		if len(allowed) == 0 {
+			a.add(severityError, "sshd-allow-users", "/etc/ssh/sshd_config", resetSSHRemediation+"add a user to AllowUsers or AllowGroups.",
+				"AllowUsers, AllowGroups or DenyUsers leave no user of /etc/passwd that may log in")
+		} else {
+			a.add(severityInfo, "sshd-allow-users", "/etc/ssh/sshd_config", "",
+				"only %s may log in, as AllowUsers, AllowGroups or DenyUsers say", strings.Join(names, ", "))
+		}
+	}
+	return allowed
+}
+
+// checkAuthorizedKeys checks the authorized keys files of u like sshd does
+// with StrictModes, and returns the number of keys in them that sshd would
+// use.
+func checkAuthorizedKeys(a *analysis, c *sshdConfig, u passwdEntry) int {
+	strict := strings.EqualFold(c.value("strictmodes", "yes"), "yes")
+	sev := severityError
+	if !strict {
+		sev = severityWarning
+	}
+	home, err := statInode(a.fsys, u.Home)
+	if err != nil {
+		if u.UID != 0 {
+			a.add(severityWarning, "ssh-home", u.Home, "Create the home directory, owned by "+u.Name+".",
+				"home directory of %s is missing, so are their authorized keys", u.Name)
+		}
+		return 0
+	}
+	if !ownedBySafe(home, u) {
+		a.add(sev, "ssh-home", u.Home, fmt.Sprintf("chown %s %s; chmod go-w %s in a rescue VM.", u.Name, u.Home, u.Home),
+			"home directory of %s is owned by uid %d with mode %04o, so sshd ignores their keys", u.Name, home.UID(), home.Mode.FileMode().Perm())
+		if strict {
+			return 0
+		}
+	}
+	keys := 0
+	for _, f := range strings.Fields(c.value("authorizedkeysfile", ".ssh/authorized_keys .ssh/authorized_keys2")) {
+		f = strings.NewReplacer("%%", "%", "%h", u.Home, "%u", u.Name, "%U", strconv.Itoa(int(u.UID))).Replace(f)
+		if !strings.HasPrefix(f, "/") {
+			f = path.Join(u.Home, f)
+		}
+		fi, err := statInode(a.fsys, f)
+		if err != nil {
+			continue
+		}
+		if fi.Mode.FileType() != ext4.FileTypeFile {
+			a.add(severityError, "ssh-authorized-keys", f, "Replace it with a file.", "authorized keys of %s is not a regular file", u.Name)
+			continue
+		}
+		bad := false
+		// the file, and the directories up to the home directory
+		for p := f; ; p = path.Dir(p) {
+			pi, err := statInode(a.fsys, p)
+			if err != nil {
+				break
+			}
+			if !ownedBySafe(pi, u) {
+				a.add(sev, "ssh-authorized-keys", f, fmt.Sprintf("chown %s %s; chmod go-w %s in a rescue VM.", u.Name, p, p),
+					"%s is owned by uid %d with mode %04o, so sshd ignores the keys of %s (bad ownership or modes)",
+					p, pi.UID(), pi.Mode.FileMode().Perm(), u.Name)
+				bad = true
+				break
+			}
+			if p == u.Home || p == "/" {
+				break
+			}
+			if p != f && !canAccess(pi, u, 01) {
+				a.add(severityError, "ssh-authorized-keys", f, fmt.Sprintf("chmod u+x %s in a rescue VM.", p),
+					"%s cannot enter %s, so sshd cannot read their keys", u.Name, p)
+				bad = true
+				break
+			}
+		}
+		if !bad && !canAccess(fi, u, 04) {
+			a.add(severityError, "ssh-authorized-keys", f, fmt.Sprintf("chown %s %s; chmod 600 %s in a rescue VM.", u.Name, f, f),
+				"%s cannot read their authorized keys (uid %d, mode %04o)", u.Name, fi.UID(), fi.Mode.FileMode().Perm())
+			bad = true
+		}
+		b, err := fs.ReadFile(a.fsys, strings.TrimPrefix(f, "/"))
+		if err != nil {
+			a.add(severityWarning, "ssh-authorized-keys", f, "", "cannot read the authorized keys of %s: %v", u.Name, err)
+			continue
+		}
+		n := countKeys(b)
+		if n == 0 {
+			a.add(severityWarning, "ssh-authorized-keys", f, resetSSHRemediation+"add a public key.", "there are no keys for %s in the file", u.Name)
+		}
+		if !bad || !strict {
+			keys += n
+		}
+	}
+	return keys
+}
+
+// countKeys returns the number of lines of an authorized_keys file that
+// hold a key, with or without options in front.
+func countKeys(b []byte) int {
+	n := 0
+	for _, line := range strings.Split(string(b), "\n") {
+		for _, f := range strings.Fields(line) {
+			if strings.HasPrefix(f, "#") {
+				break
+			}
+			if strings.HasPrefix(f, "ssh-") || strings.HasPrefix(f, "ecdsa-") || strings.HasPrefix(f, "sk-") {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// ownedBySafe checks a file or directory as sshd does for authorized keys:
+// owned by root or the user, and not writable by group or others.
+func ownedBySafe(inode ext4.Inode, u passwdEntry) bool {
+	return (inode.UID() == 0 || inode.UID() == u.UID) && inode.Mode.FileMode().Perm()&022 == 0
+}
+
+// canAccess reports whether u has the access in bits (4 read, 1 execute) to
+// the inode, going by the primary group only.
+func canAccess(inode ext4.Inode, u passwdEntry, bits fs.FileMode) bool {
+	mode := inode.Mode.FileMode().Perm()
+	switch {
+	case u.UID == 0:
+		return true
+	case inode.UID() == u.UID:
+		return mode&(bits<<6) != 0
+	case inode.GID() == u.GID:
+		return mode&(bits<<3) != 0
+	}
+	return mode&bits != 0
+}
+
+// statInode returns the inode at the absolute path p.
+func statInode(fsys fs.FS, p string) (ext4.Inode, error) {
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	if p == "" {
+		p = "."
+	}
+	fi, err := fs.Stat(fsys, p)
+	if err != nil {
+		return ext4.Inode{}, err
+	}
+	inode, ok := fi.Sys().(ext4.Inode)
+	if !ok {
+		return ext4.Inode{}, errors.New("no inode")
+	}
+	return inode, nil
+}
+
+// groupName returns the name of group gid from /etc/group.
+func groupName(fsys fs.FS, gid uint32) string {
+	b, _ := fs.ReadFile(fsys, "etc/group")
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) >= 3 && f[2] == strconv.Itoa(int(gid)) {
+			return f[0]
+		}
+	}
+	return ""
+}
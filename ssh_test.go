@@ -0,0 +1,291 @@
+package main
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/paulmey/inspect-azure-vhd/ext4"
+)
+
+func TestSplitSshdLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		keyword string
+		args    []string
+		err     bool
+	}{
+		{"", "", nil, false},
+		{"  # PermitRootLogin yes", "", nil, false},
+		{"PermitRootLogin no", "PermitRootLogin", []string{"no"}, false},
+		{"\tPort=2222", "Port", []string{"2222"}, false},
+		{"Port = 2222 # not 22", "Port", []string{"2222"}, false},
+		{"UsePAM", "UsePAM", nil, false},
+		{`Banner "/etc/my banner"`, "Banner", []string{"/etc/my banner"}, false},
+		{"AllowUsers alice bob@10.0.0.*", "AllowUsers", []string{"alice", "bob@10.0.0.*"}, false},
+		{"ForceCommand echo a#b", "ForceCommand", []string{"echo", "a#b"}, false},
+		{`Banner "/etc/banner`, "Banner", nil, true},
+	}
+	for _, test := range tests {
+		keyword, args, err := splitSshdLine(test.line)
+		if keyword != test.keyword || !reflect.DeepEqual(args, test.args) || (err != nil) != test.err {
+			t.Errorf("splitSshdLine(%q) = %q, %q, %v, expected %q, %q (error: %v)", test.line, keyword, args, err, test.keyword, test.args, test.err)
+		}
+	}
+}
+
+// findings returns the check and subject of each finding of a.
+func findings(a *analysis) []string {
+	var rv []string
+	for _, f := range a.findings {
+		rv = append(rv, f.Check+" "+f.Subject)
+	}
+	return rv
+}
+
+func TestParseSshdConfig(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/ssh/sshd_config": {Data: []byte(`# comment
+Include /etc/ssh/sshd_config.d/*.conf
+Include missing.conf
+Port 22
+Protocol 2
+Foo bar
+Banner
+UsePAM maybe
+Port 70000
+Banner "/etc/issue
+Match User admin
+	PasswordAuthentication yes
+	Port 2222
+Match all
+PermitRootLogin no
+Match Color blue
+`)},
+		"etc/ssh/sshd_config.d/50-cloud.conf": {Data: []byte("PasswordAuthentication no\nMatch Group wheel\nX11Forwarding yes\n")},
+		"etc/ssh/sshd_config.d/README":        {Data: []byte("Bad line\n")},
+	}
+	a := &analysis{analyzer: "ssh", fsys: fsys}
+	c := &sshdConfig{}
+	if err := parseSshdConfig(a, c, "/etc/ssh/sshd_config", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	type directive struct {
+		String  string
+		Keyword string
+		Match   string
+	}
+	var directives []directive
+	for _, d := range c.Directives {
+		directives = append(directives, directive{d.String(), d.Keyword, d.Match})
+	}
+	expected := []directive{
+		{"/etc/ssh/sshd_config.d/50-cloud.conf line 1", "passwordauthentication", ""},
+		{"/etc/ssh/sshd_config.d/50-cloud.conf line 2", "match", "Group wheel"},
+		// a Match block in an included file ends with the file
+		{"/etc/ssh/sshd_config.d/50-cloud.conf line 3", "x11forwarding", "Group wheel"},
+		{"/etc/ssh/sshd_config line 2", "include", ""},
+		{"/etc/ssh/sshd_config line 3", "include", ""},
+		{"/etc/ssh/sshd_config line 4", "port", ""},
+		{"/etc/ssh/sshd_config line 9", "port", ""},
+		{"/etc/ssh/sshd_config line 11", "match", "User admin"},
+		{"/etc/ssh/sshd_config line 12", "passwordauthentication", "User admin"},
+		{"/etc/ssh/sshd_config line 14", "match", "all"},
+		{"/etc/ssh/sshd_config line 15", "permitrootlogin", ""},
+		{"/etc/ssh/sshd_config line 16", "match", "Color blue"},
+	}
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("parseSshdConfig() directives = %+v,\nexpected %+v", directives, expected)
+	}
+
+	expectedFindings := []string{
+		"sshd-include /etc/ssh/sshd_config line 3",
+		"sshd-deprecated /etc/ssh/sshd_config line 5",
+		"sshd-syntax /etc/ssh/sshd_config line 6",
+		"sshd-syntax /etc/ssh/sshd_config line 7",
+		"sshd-syntax /etc/ssh/sshd_config line 8",
+		"sshd-syntax /etc/ssh/sshd_config line 9",
+		"sshd-syntax /etc/ssh/sshd_config line 10",
+		"sshd-syntax /etc/ssh/sshd_config line 13",
+		"sshd-syntax /etc/ssh/sshd_config line 16",
+	}
+	if rv := findings(a); !reflect.DeepEqual(rv, expectedFindings) {
+		t.Errorf("parseSshdConfig() findings = %q,\nexpected %q", rv, expectedFindings)
+	}
+	if v := c.value("passwordauthentication", "yes"); v != "no" {
+		t.Errorf("value(passwordauthentication) = %q, expected the included no", v)
+	}
+
+	fsys["etc/ssh/sshd_config"] = &fstest.MapFile{Data: []byte("Include sshd_config\n")}
+	a = &analysis{analyzer: "ssh", fsys: fsys}
+	if err := parseSshdConfig(a, &sshdConfig{}, "/etc/ssh/sshd_config", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rv := findings(a); len(rv) == 0 || rv[0] != "sshd-include /etc/ssh/sshd_config line 1" {
+		t.Errorf("parseSshdConfig() of an include loop = %q", rv)
+	}
+}
+
+func TestSshdKeywords(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/ssh/sshd_config": {Data: []byte(`PermitUserEnvironment no
+KerberosUseKuserok yes
+AuthorizedKeysFile2 .ssh/authorized_keys2
+UseBlacklist yes
+`)},
+	}
+	a := &analysis{analyzer: "ssh", fsys: fsys}
+	if err := parseSshdConfig(a, &sshdConfig{}, "/etc/ssh/sshd_config", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"sshd-deprecated /etc/ssh/sshd_config line 3",
+		"sshd-syntax /etc/ssh/sshd_config line 4",
+	}
+	if rv := findings(a); !reflect.DeepEqual(rv, expected) {
+		t.Fatalf("parseSshdConfig() findings = %q,\nexpected %q", rv, expected)
+	}
+	// options of patched builds are not known to be fatal
+	if a.findings[1].Severity != severityWarning {
+		t.Errorf("an unknown option is a %v finding, expected %v", a.findings[1].Severity, severityWarning)
+	}
+}
+
+func TestCheckSshdSettings(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/ssh/sshd_config": {Data: []byte(`Include /etc/ssh/sshd_config.d/*.conf
+PasswordAuthentication yes
+Port 22
+Port 2222
+ListenAddress 0.0.0.0
+ListenAddress 10.0.0.4
+PermitRootLogin yes
+Match User admin
+	PermitEmptyPasswords yes
+`)},
+		"etc/ssh/sshd_config.d/50-cloud.conf": {Data: []byte("PermitRootLogin no\nPasswordAuthentication no\n")},
+	}
+	a := &analysis{analyzer: "ssh", fsys: fsys}
+	c := &sshdConfig{}
+	if err := parseSshdConfig(a, c, "/etc/ssh/sshd_config", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkSshdSettings(a, c)
+	// the included settings come first and win, while every port and
+	// address is listened on
+	expected := []string{
+		"sshd-port /etc/ssh/sshd_config line 4",
+		"sshd-listen /etc/ssh/sshd_config line 6",
+		"sshd-empty-password /etc/ssh/sshd_config line 9",
+	}
+	if rv := findings(a); !reflect.DeepEqual(rv, expected) {
+		t.Errorf("checkSshdSettings() findings = %q,\nexpected %q", rv, expected)
+	}
+}
+
+func TestCheckMatch(t *testing.T) {
+	tests := []struct {
+		args []string
+		all  bool
+		ok   bool
+	}{
+		{[]string{"All"}, true, true},
+		{[]string{"User", "admin"}, false, true},
+		{[]string{"User", "admin", "Address", "10.0.0.0/8"}, false, true},
+		{[]string{"invalid-user", "LocalPort", "22"}, false, true},
+		{[]string{"all", "User", "admin"}, false, true},
+		{[]string{"User"}, false, false},
+		{[]string{"Color", "blue"}, false, false},
+	}
+	for _, test := range tests {
+		a := &analysis{}
+		all := checkMatch(a, sshdDirective{File: "/etc/ssh/sshd_config", Line: 1, Keyword: "match", Args: test.args})
+		if all != test.all || (len(a.findings) == 0) != test.ok {
+			t.Errorf("checkMatch(%q) = %v with findings %q, expected %v (valid: %v)", test.args, all, findings(a), test.all, test.ok)
+		}
+	}
+}
+
+func TestParsePasswd(t *testing.T) {
+	passwd := `root:x:0:0:root:/root:/bin/bash
+# comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+azureuser:x:1000:1000:,,,:/home/azureuser:/bin/bash
+broken:x:abc:1:broken:/:/bin/sh
+short:x:1001
+svc:x:1001:1001::/home/svc:/bin/false
+`
+	expected := []passwdEntry{
+		{"root", 0, 0, "/root", "/bin/bash"},
+		{"daemon", 1, 1, "/usr/sbin", "/usr/sbin/nologin"},
+		{"azureuser", 1000, 1000, "/home/azureuser", "/bin/bash"},
+		{"svc", 1001, 1001, "/home/svc", "/bin/false"},
+	}
+	users := parsePasswd([]byte(passwd))
+	if !reflect.DeepEqual(users, expected) {
+		t.Fatalf("parsePasswd() = %+v,\nexpected %+v", users, expected)
+	}
+	for i, canLogIn := range []bool{true, false, true, false} {
+		if users[i].canLogIn() != canLogIn {
+			t.Errorf("%s: canLogIn() = %v, expected %v", users[i].Name, !canLogIn, canLogIn)
+		}
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	group := "root:x:0:\nwheel:x:10:azureuser,admin\n# comment:x:1:a\nssh_keys:x:998:\nbad\n"
+	expected := map[string][]string{"root": nil, "wheel": {"azureuser", "admin"}, "ssh_keys": nil}
+	if rv := parseGroups([]byte(group)); !reflect.DeepEqual(rv, expected) {
+		t.Errorf("parseGroups() = %q, expected %q", rv, expected)
+	}
+}
+
+func TestCountKeys(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected int
+	}{
+		{"", 0},
+		{"# ssh-rsa AAAA old key\n\n", 0},
+		{"ssh-rsa AAAA user@host\n", 1},
+		{"ssh-ed25519 AAAA a\necdsa-sha2-nistp256 AAAA b\nsk-ssh-ed25519@openssh.com AAAA c\n", 3},
+		{`no-port-forwarding,command="echo 'Please login as azureuser'" ssh-rsa AAAA root` + "\n", 1},
+		{"garbage\n", 0},
+	}
+	for _, test := range tests {
+		if n := countKeys([]byte(test.content)); n != test.expected {
+			t.Errorf("countKeys(%q) = %d, expected %d", test.content, n, test.expected)
+		}
+	}
+}
+
+func TestInodeAccess(t *testing.T) {
+	user := passwdEntry{Name: "azureuser", UID: 1000, GID: 1000}
+	inode := func(uid, gid uint32, mode fs.FileMode) ext4.Inode {
+		return ext4.Inode{Mode: ext4.InodeMode(0x8000 | mode), Uid: uint16(uid), UidHigh: uint16(uid >> 16), Gid: uint16(gid), GidHigh: uint16(gid >> 16)}
+	}
+	tests := []struct {
+		inode ext4.Inode
+		u     passwdEntry
+		safe  bool
+		read  bool
+	}{
+		{inode(1000, 1000, 0600), user, true, true},
+		{inode(0, 0, 0644), user, true, true},
+		{inode(0, 0, 0600), user, true, false},
+		{inode(1001, 1000, 0640), user, false, true},
+		{inode(1000, 1000, 0620), user, false, true},
+		{inode(1000, 1000, 0602), user, false, true},
+		{inode(1000, 1000, 0044), user, true, false},
+		{inode(1<<16+1000, 1000, 0600), user, false, false},
+		{inode(1000, 1000, 0000), passwdEntry{Name: "root"}, false, true},
+	}
+	for _, test := range tests {
+		if safe, read := ownedBySafe(test.inode, test.u), canAccess(test.inode, test.u, 04); safe != test.safe || read != test.read {
+			t.Errorf("%s, inode of %d:%d mode %04o: ownedBySafe() = %v, canAccess(read) = %v, expected %v, %v", test.u.Name,
+				test.inode.UID(), test.inode.GID(), test.inode.Mode.FileMode().Perm(), safe, read, test.safe, test.read)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// makeRaw is not implemented here, so the shell reads whole lines without
+// editing, history or completion.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errNotImplemented
+}
+
+func terminalRows(fd uintptr) int {
+	return 0
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	uploadBlockSize = 4 << 20 // Bytes per Put Block request.
+	uploadRetries   = 5       // Attempts per request beyond the first.
+)
+
+// uploadRetryDelay is how long to wait before the first retry, doubled for
+// each further one.
+var uploadRetryDelay = time.Second
+
+// blobURL returns the URL of the blob name in the container of containerURL,
+// keeping its SAS token. This works for Azure as well as for path style
+// emulators like Azurite (http://127.0.0.1:10000/devstoreaccount1/container).
+func blobURL(containerURL, name string) (string, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%s is not an http(s) URL of a container", redactURL(containerURL))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(name, "/")
+	return u.String(), nil
+}
+
+// uploadName returns the name of the blob results are uploaded to: that of
+// -archive if set, or else one made from the name of the disk blob and the
+// time, like "myvm-osdisk-20160102T150405Z.tar.gz".
+func uploadName(source string, now time.Time) string {
+	if archivePath != "" {
+		return path.Base(archivePath)
+	}
+	base := "disk"
+	if u, err := url.Parse(source); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		base = strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	}
+	ext := ".tar.gz"
+	switch archiveFormat {
+	case "tar":
+		ext = ".tar"
+	case "zip":
+		ext = ".zip"
+	}
+	return base + "-" + now.UTC().Format("20060102T150405Z") + ext
+}
+
+// blockBlobWriter uploads what is written to it as a block blob: in blocks
+// of uploadBlockSize with Put Block, committed with Put Block List on Close.
+// Nothing shows up in the container unless Close succeeds.
+type blockBlobWriter struct {
+	url    string // Of the blob, with the SAS token.
+	buf    []byte
+	blocks []string // IDs of the blocks put so far.
+	size   int64
+	err    error // Of the first failed Put Block; the blob is not committed then.
+}
+
+func newBlockBlobWriter(url string) *blockBlobWriter {
+	return &blockBlobWriter{url: url, buf: make([]byte, 0, uploadBlockSize)}
+}
+
+func (w *blockBlobWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p, n = p[m:], n+m
+		if len(w.buf) == cap(w.buf) {
+			if err := w.putBlock(); err != nil {
+				w.err = err
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// with returns the URL of the blob with query added to its SAS token.
+func (w *blockBlobWriter) with(query string) string {
+	if strings.Contains(w.url, "?") {
+		return w.url + "&" + query
+	}
+	return w.url + "?" + query
+}
+
+// putBlock uploads the buffered data as the next block.
+func (w *blockBlobWriter) putBlock() error {
+	// block IDs must all have the same length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(w.blocks))))
+	sum := md5.Sum(w.buf)
+	err := retryRequest(fmt.Sprintf("block %d", len(w.blocks)), func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", w.with("comp=block&blockid="+url.QueryEscape(id)), bytes.NewReader(w.buf))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	w.blocks = append(w.blocks, id)
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close uploads the last block and commits the blob, unless a block failed.
+func (w *blockBlobWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 || len(w.blocks) == 0 {
+		if err := w.putBlock(); err != nil {
+			return err
+		}
+	}
+	var list bytes.Buffer
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range w.blocks {
+		fmt.Fprintf(&list, "<Latest>%s</Latest>", id)
+	}
+	list.WriteString("</BlockList>")
+	err := retryRequest("block list", func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", w.with("comp=blocklist"), bytes.NewReader(list.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("x-ms-blob-content-type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(diag, "Uploaded %d bytes in %d blocks to %s\n", w.size, len(w.blocks), redactURL(w.url))
+	return nil
+}
+
+// retryRequest sends the request newRequest makes until it succeeds, retrying
+// on network errors, timeouts, throttling and server errors.
+func retryRequest(what string, newRequest func() (*http.Request, error)) error {
+	delay := uploadRetryDelay
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("x-ms-version", apiVersion)
+		retry := true
+		res, err := http.DefaultClient.Do(req)
+		if ue, ok := err.(*url.Error); ok {
+			// the URL has the signature of the SAS token
+			ue.URL = redactURL(ue.URL)
+		}
+		if err == nil {
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("Non success status code: %s", res.Status)
+			if code := errorCode(res, body); code != "" {
+				err = fmt.Errorf("Non success status code: %s (%s)", res.Status, code)
+			}
+			switch res.StatusCode {
+			case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+				http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			default:
+				retry = false
+			}
+		}
+		if !retry || attempt == uploadRetries {
+			return fmt.Errorf("could not upload %s: %v", what, err)
+		}
+		warnf("uploading %s failed, retrying in %v: %v", what, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// errorCode returns the storage error code of a failed request, which tells
+// more than the status, e.g. AuthenticationFailed.
+func errorCode(res *http.Response, body []byte) string {
+	if code := res.Header.Get("x-ms-error-code"); code != "" {
+		return code
+	}
+	if i := bytes.Index(body, []byte("<Code>")); i >= 0 {
+		if j := bytes.Index(body[i:], []byte("</Code>")); j >= 0 {
+			return string(body[i+len("<Code>") : i+j])
+		}
+	}
+	return ""
+}
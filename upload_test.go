@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testContainer is a container that fails the next failBlocks Put Block
+// requests, and counts those that succeed.
+type testContainer struct {
+	mu         sync.Mutex
+	failBlocks int
+	blocks     int
+	blockLists int
+}
+
+func (c *testContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ioutil.ReadAll(r.Body)
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		if c.failBlocks > 0 {
+			c.failBlocks--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		c.blocks++
+	case "blocklist":
+		c.blockLists++
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestUploadIncompleteArchive(t *testing.T) {
+	defer func(d time.Duration, w io.Writer) { uploadRetryDelay, diag = d, w }(uploadRetryDelay, diag)
+	uploadRetryDelay, diag = time.Millisecond, ioutil.Discard
+
+	for _, format := range []string{"tar", "tgz", "zip"} {
+		c := &testContainer{}
+		srv := httptest.NewServer(c)
+		archiveFormat, uploadURL = format, srv.URL+"/results?sv=2019-02-02&sig=secret"
+		out, err := openOutput("", "https://account.blob.core.windows.net/vhds/disk.vhd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := out.writeData("a", bytes.Repeat([]byte("x"), uploadBlockSize)); err != nil {
+			t.Fatal(err)
+		}
+		// one block fails for good, the ones after it would go through
+		c.mu.Lock()
+		c.failBlocks = uploadRetries + 1
+		c.mu.Unlock()
+		// random data, so that the compressed archive fills a block as well
+		data := make([]byte, 2*uploadBlockSize)
+		for i := range data {
+			data[i] = byte(i*7919 + i>>8)
+		}
+		out.writeData("b", data)
+		err = out.Close()
+		if err == nil {
+			t.Errorf("%s: Close() of an archive whose upload failed succeeded", format)
+		}
+		if c.blockLists != 0 {
+			t.Errorf("%s: an incomplete archive was committed", format)
+		}
+		srv.Close()
+	}
+	archiveFormat, uploadURL = "", ""
+}
+
+func TestUploadErrorsRedacted(t *testing.T) {
+	defer func(d time.Duration, w io.Writer) { uploadRetryDelay, diag = d, w }(uploadRetryDelay, diag)
+	uploadRetryDelay, diag = time.Millisecond, ioutil.Discard
+	defer func(w []string) { warnings = w }(warnings)
+	warnings = nil
+
+	srv := httptest.NewServer(&testContainer{})
+	u := srv.URL + "/results/r.tar?sv=2019-02-02&sig=secret"
+	srv.Close() // so that every request fails to connect
+	w := newBlockBlobWriter(u)
+	err := w.Close()
+	if err == nil {
+		t.Fatal("Close() without a server succeeded")
+	}
+	for _, msg := range append(warnings, err.Error()) {
+		if strings.Contains(msg, "secret") {
+			t.Errorf("the SAS signature is in %q", msg)
+		}
+	}
+	if len(warnings) != uploadRetries {
+		t.Errorf("%d retries were reported, expected %d", len(warnings), uploadRetries)
+	}
+}
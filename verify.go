@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storedFile is what an output holds under a name.
+type storedFile struct {
+	size              int64
+	sha256, sha1, md5 string
+	symlink           bool
+	target            string // Of a symlink.
+}
+
+func verifyCmd(f *flag.FlagSet) func(s *session, args []string) error {
+	return func(s *session, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		return verifyOutput(args[0])
+	}
+}
+
+// verifyOutput checks the files in the output directory or archive p, - for
+// a tar archive on stdin, against the manifest in it.
+func verifyOutput(p string) error {
+	var stored map[string]storedFile
+	var b []byte
+	var err error
+	if fi, serr := os.Stat(p); serr == nil && fi.IsDir() {
+		stored, b, err = readOutputDir(p)
+	} else if strings.HasSuffix(p, ".zip") {
+		stored, b, err = readZip(p)
+	} else {
+		stored, b, err = readTar(p)
+	}
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("%s has no manifest.json", p)
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("manifest.json: %v", err)
+	}
+	fmt.Printf("Manifest of %s (ETag %s), collected %s\n", m.Source, m.ETag, m.Collected)
+
+	failed := 0
+	known := map[string]bool{"manifest.json": true, "manifest.csv": true, "report.json": true}
+	for _, f := range m.Files {
+		name := strings.TrimPrefix(fixFilename(f.Path), "/")
+		if f.Partial {
+			if _, ok := stored[name+".partial"]; ok {
+				name += ".partial"
+			}
+		}
+		known[name] = true
+		s, found := stored[name]
+		if problem := checkStored(f, s, found); problem != "" {
+			fmt.Printf("FAILED  %s: %s\n", f.Path, problem)
+			failed++
+		} else {
+			fmt.Printf("OK      %s\n", f.Path)
+		}
+	}
+	for name := range stored {
+		if !known[name] && filepath.Base(name) != "metadata.txt" {
+			fmt.Printf("EXTRA   %s: not in the manifest\n", name)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d problems found, %d files in the manifest", failed, len(m.Files))
+	}
+	fmt.Printf("All %d files match the manifest\n", len(m.Files))
+	return nil
+}
+
+// checkStored returns what is wrong with s, as f in the manifest says, or ""
+// if nothing is.
+func checkStored(f manifestFile, s storedFile, found bool) string {
+	switch {
+	case !found:
+		return "missing"
+	case f.Type == "Symlink":
+		if !s.symlink || s.target != f.SymlinkTarget {
+			return fmt.Sprintf("not a symlink to %s", f.SymlinkTarget)
+		}
+	case f.Type == "File":
+		if f.Partial && s.size != f.Length {
+			// archives pad partial files to the size they announced
+			return fmt.Sprintf("partial, %d of %d bytes collected, cannot be checked", f.Length, f.Size)
+		}
+		if s.size != f.Length {
+			return fmt.Sprintf("%d bytes instead of %d", s.size, f.Length)
+		}
+		if s.sha256 != f.SHA256 || s.sha1 != f.SHA1 || s.md5 != f.MD5 {
+			return "hash mismatch"
+		}
+	}
+	return ""
+}
+
+func hashStored(r io.Reader) (storedFile, error) {
+	h := newManifestHashes()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return storedFile{}, err
+	}
+	s := storedFile{size: n}
+	s.sha256, s.sha1, s.md5 = h.sums()
+	return s, nil
+}
+
+// readOutputDir hashes the files below dir and returns them with the
+// manifest.
+func readOutputDir(dir string) (map[string]storedFile, []byte, error) {
+	stored := map[string]storedFile{}
+	var manifestJSON []byte
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			stored[name] = storedFile{symlink: true, target: target}
+			return err
+		}
+		if name == "manifest.json" {
+			manifestJSON, err = ioutil.ReadFile(p)
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stored[name], err = hashStored(f)
+		return err
+	})
+	return stored, manifestJSON, err
+}
+
+// readTar hashes the files in the tar archive p, compressed or not, and
+// returns them with the manifest.
+func readTar(p string) (map[string]storedFile, []byte, error) {
+	var in io.Reader = os.Stdin
+	if p != "-" {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	br := bufio.NewReader(in)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		in = gz
+	} else {
+		in = br
+	}
+
+	stored := map[string]storedFile{}
+	var manifestJSON []byte
+	tr := tar.NewReader(in)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return stored, manifestJSON, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p, err)
+		}
+		switch {
+		case h.Name == "manifest.json":
+			if manifestJSON, err = ioutil.ReadAll(tr); err != nil {
+				return nil, nil, err
+			}
+		case h.Typeflag == tar.TypeSymlink:
+			stored[h.Name] = storedFile{symlink: true, target: h.Linkname}
+		case h.Typeflag == tar.TypeReg:
+			if stored[h.Name], err = hashStored(tr); err != nil {
+				return nil, nil, fmt.Errorf("%s: %s: %v", p, h.Name, err)
+			}
+		default:
+			stored[h.Name] = storedFile{}
+		}
+	}
+}
+
+// readZip hashes the files in the zip archive p and returns them with the
+// manifest.
+func readZip(p string) (map[string]storedFile, []byte, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	stored := map[string]storedFile{}
+	var manifestJSON []byte
+	for _, zf := range zr.File {
+		r, err := zf.Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %v", p, zf.Name, err)
+		}
+		switch {
+		case zf.Name == "manifest.json":
+			manifestJSON, err = ioutil.ReadAll(r)
+		case zf.Mode()&os.ModeSymlink != 0:
+			var target []byte
+			target, err = ioutil.ReadAll(r)
+			stored[zf.Name] = storedFile{symlink: true, target: string(target)}
+		default:
+			stored[zf.Name], err = hashStored(r)
+		}
+		r.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %v", p, zf.Name, err)
+		}
+	}
+	return stored, manifestJSON, nil
+}